@@ -7,10 +7,11 @@ BUILDDIR := dist
 # capture version information
 GITSHA := $(shell git rev-parse --short HEAD)
 VERSION := $(shell cat version.txt)
+BUILDDATE := $(shell date -u +%Y-%m-%dT%H:%M:%SZ)
 IMAGETAG := $(shell git describe --tags --exact-match 2>/dev/null || git symbolic-ref --short HEAD)
 
 
-CTIMEVAR=-X $(PKG)/version.GitCommit=$(GITSHA) -X $(PKG)/version.Version=$(VERSION)
+CTIMEVAR=-X $(PKG)/version.GitCommit=$(GITSHA) -X $(PKG)/version.Version=$(VERSION) -X $(PKG)/version.BuildDate=$(BUILDDATE)
 GO_LDFLAGS=-ldflags "-w $(CTIMEVAR)"
 GO_LDFLAGS_STATIC=-ldflags "-w $(CTIMEVAR) -extldflags -static"
 
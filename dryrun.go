@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/kylemcc/cwlog/writer"
+)
+
+// dryRunClient is a writer.Client that describes each batch of log events
+// instead of sending it to CloudWatch Logs. Only the calls made by the writer
+// are implemented.
+type dryRunClient struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+
+	mu  sync.Mutex
+	out io.Writer
+	seq int
+}
+
+func newDryRunClient(out io.Writer) *dryRunClient {
+	return &dryRunClient{out: out}
+}
+
+// PutLogEventsWithContext implements cloudwatchlogsiface.CloudWatchLogsAPI
+func (c *dryRunClient) PutLogEventsWithContext(_ aws.Context, input *cloudwatchlogs.PutLogEventsInput, _ ...request.Option) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var (
+		size        int
+		first, last int64
+	)
+	for i, e := range input.LogEvents {
+		size += len(aws.StringValue(e.Message)) + writer.EventOverhead
+		ts := aws.Int64Value(e.Timestamp)
+		if i == 0 || ts < first {
+			first = ts
+		}
+		if i == 0 || ts > last {
+			last = ts
+		}
+	}
+
+	fmt.Fprintf(c.out, "dry run: %s/%s: %d events, %d bytes, %s to %s\n",
+		aws.StringValue(input.LogGroupName), aws.StringValue(input.LogStreamName),
+		len(input.LogEvents), size, formatMillis(first), formatMillis(last))
+
+	c.seq++
+	return &cloudwatchlogs.PutLogEventsOutput{
+		NextSequenceToken: aws.String(strconv.Itoa(c.seq)),
+	}, nil
+}
+
+// formatMillis formats a timestamp in milliseconds since the epoch
+func formatMillis(ms int64) string {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/kylemcc/cwlog/writer"
+)
+
+func TestDryRunClient(t *testing.T) {
+	var out bytes.Buffer
+	client := newDryRunClient(&out)
+
+	w := writer.NewWithContext(context.Background(), "group", "stream", client,
+		writer.WithTimestampFormat("2006-01-02T15:04:05Z07:00"),
+	)
+	if _, err := w.Write([]byte("2020-06-01T12:30:45Z first\n2020-06-01T12:30:46Z second\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "dry run: group/stream: 2 events, 105 bytes, 2020-06-01T12:30:45Z to 2020-06-01T12:30:46Z\n"
+	if got := out.String(); got != expected {
+		t.Errorf("unexpected output: got=%q want=%q", got, expected)
+	}
+	if strings.Contains(out.String(), "first") {
+		t.Error("dry run output should not include the messages")
+	}
+}
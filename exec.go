@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// execCommand runs the command described by args, sending each line of its
+// standard output to outW and each line of its standard error to errW, which
+// may be the same writer. Each line of standard output is also copied to
+// outTee and each line of standard error to errTee, if they are not nil.
+// SIGINT and SIGTERM received while the command is running are forwarded to
+// it.
+//
+// execCommand returns the command's exit code. An error is returned only if
+// the command could not be run or its output could not be read.
+func execCommand(outW, errW io.Writer, args []string, outTee, errTee io.Writer) (int, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	// done stops the forwarding once the command has been waited on, since
+	// sigs is never closed
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				cmd.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs = make(chan error, 2)
+	)
+
+	pump := func(w io.Writer, src io.Reader, teeTo io.Writer) {
+		defer wg.Done()
+		errs <- copyLines(w, src, teeTo, &mu)
+	}
+
+	wg.Add(2)
+	go pump(outW, stdout, outTee)
+	go pump(errW, stderr, errTee)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			cmd.Wait()
+			return 0, err
+		}
+	}
+
+	return exitCode(cmd.Wait())
+}
+
+// copyLines copies src to w one line at a time, so that lines from multiple
+// sources sharing w are never interleaved. If teeTo is not nil, each line is
+// also written there.
+//
+// If writing to w fails, src is still read to the end, and still copied to
+// teeTo, so that the command isn't blocked writing its output. The write
+// error is returned once src is exhausted.
+func copyLines(w io.Writer, src io.Reader, teeTo io.Writer, mu *sync.Mutex) error {
+	var werr error
+
+	r := bufio.NewReader(src)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			mu.Lock()
+			if werr == nil {
+				_, werr = w.Write(line)
+			}
+			if teeTo != nil {
+				teeTo.Write(line)
+			}
+			mu.Unlock()
+		}
+
+		if err == io.EOF {
+			return werr
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// exitCode translates the error returned by exec.Cmd.Wait into an exit code.
+// A command killed by a signal is reported as 128 plus the signal number, as
+// a shell would.
+func exitCode(err error) (int, error) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, err
+	}
+
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), nil
+	}
+	return exitErr.ExitCode(), nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/kylemcc/cwlog/writer"
+	"github.com/kylemcc/cwlog/writer/cwlogtest"
+)
+
+func TestRunCommandExitCode(t *testing.T) {
+	client := cwlogtest.NewClient()
+	args := []string{"sh", "-c", "echo a; echo b; exit 3"}
+
+	code, err := runCommand(context.Background(), client, "group", "stream", "", args, nil, nil, writer.WithClock(cwlogtest.NewClock()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code != 3 {
+		t.Errorf("unexpected exit code: got=%d want=3", code)
+	}
+
+	// the output is still flushed after the command fails
+	expected := []string{"a", "b"}
+	if got := client.Messages(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected messages: got=%q want=%q", got, expected)
+	}
+}
+
+// streamClient records the events sent to each log stream with a separate
+// cwlogtest.Client
+type streamClient struct {
+	*cwlogtest.Client
+	streams map[string]*cwlogtest.Client
+}
+
+// PutLogEventsWithContext implements cloudwatchlogsiface.CloudWatchLogsAPI
+func (c *streamClient) PutLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.PutLogEventsInput, opts ...request.Option) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	return c.streams[aws.StringValue(input.LogStreamName)].PutLogEventsWithContext(ctx, input, opts...)
+}
+
+func TestRunCommandStderrStream(t *testing.T) {
+	stdout, stderr := cwlogtest.NewClient(), cwlogtest.NewClient()
+	client := &streamClient{
+		Client:  cwlogtest.NewClient(),
+		streams: map[string]*cwlogtest.Client{"stream": stdout, "errors": stderr},
+	}
+	args := []string{"sh", "-c", "echo out 1; echo err 1 >&2; echo out 2; echo err 2 >&2"}
+
+	code, err := runCommand(context.Background(), client, "group", "stream", "errors", args, nil, nil, writer.WithClock(cwlogtest.NewClock()))
+	if err != nil || code != 0 {
+		t.Fatalf("unexpected result: code=%d err=%v", code, err)
+	}
+
+	if got, want := stdout.Messages(), []string{"out 1", "out 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected messages in the log stream: got=%q want=%q", got, want)
+	}
+	if got, want := stderr.Messages(), []string{"err 1", "err 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected messages in the stderr stream: got=%q want=%q", got, want)
+	}
+}
+
+func TestExecCommandTee(t *testing.T) {
+	var out, outTee, errTee bytes.Buffer
+	args := []string{"sh", "-c", "echo a; echo b >&2"}
+
+	code, err := execCommand(&out, &out, args, &outTee, &errTee)
+	if err != nil || code != 0 {
+		t.Fatalf("unexpected result: code=%d err=%v", code, err)
+	}
+	if got := out.String(); got != "a\nb\n" && got != "b\na\n" {
+		t.Errorf("unexpected output: %q", got)
+	}
+	if outTee.String() != "a\n" || errTee.String() != "b\n" {
+		t.Errorf("unexpected tee output: stdout=%q stderr=%q", outTee.String(), errTee.String())
+	}
+}
+
+func TestExecCommandNotFound(t *testing.T) {
+	var out bytes.Buffer
+	if _, err := execCommand(&out, &out, []string{"cwlog-no-such-command"}, nil, nil); err == nil {
+		t.Error("expected an error running a missing command")
+	}
+}
+
+// signalWriter sends sig to the test process when it is first written to, so
+// that the signal arrives while the command is running
+type signalWriter struct {
+	bytes.Buffer
+	once sync.Once
+	sig  syscall.Signal
+}
+
+func (w *signalWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { syscall.Kill(syscall.Getpid(), w.sig) })
+	return w.Buffer.Write(p)
+}
+
+func TestExecCommandForwardsSignals(t *testing.T) {
+	out := &signalWriter{sig: syscall.SIGTERM}
+	args := []string{"sh", "-c", "echo ready; exec sleep 10"}
+
+	started := time.Now()
+	code, err := execCommand(out, out, args, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the command was killed by the forwarded signal, as a shell reports it
+	if want := 128 + int(syscall.SIGTERM); code != want {
+		t.Errorf("unexpected exit code: got=%d want=%d", code, want)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("the command was not interrupted: ran for %v", elapsed)
+	}
+	if !strings.HasPrefix(out.String(), "ready") {
+		t.Errorf("unexpected output: %q", out.String())
+	}
+}
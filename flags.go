@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/kylemcc/cwlog/writer"
+)
+
+// tagFlag is a repeatable flag that collects key=value pairs into a map
+// suitable for use as CloudWatch Logs resource tags
+type tagFlag map[string]*string
+
+// String implements flag.Value
+func (t tagFlag) String() string {
+	pairs := make([]string, 0, len(t))
+	for k, v := range t {
+		pairs = append(pairs, k+"="+*v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set implements flag.Value
+func (t tagFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return fmt.Errorf("invalid tag %q: expected key=value", s)
+	}
+
+	v := s[i+1:]
+	t[s[:i]] = &v
+	return nil
+}
+
+// patternFlag is a repeatable flag that collects regular expressions. Each
+// pattern is compiled as it is parsed, so an invalid one is reported
+// immediately
+type patternFlag []*regexp.Regexp
+
+// String implements flag.Value
+func (p *patternFlag) String() string {
+	if p == nil {
+		return ""
+	}
+
+	patterns := make([]string, len(*p))
+	for i, re := range *p {
+		patterns[i] = re.String()
+	}
+	return strings.Join(patterns, ",")
+}
+
+// Set implements flag.Value
+func (p *patternFlag) Set(s string) error {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %v", s, err)
+	}
+
+	*p = append(*p, re)
+	return nil
+}
+
+// metricFlag is a repeatable flag that collects EMF metrics in the form
+// field or field:Unit, where Unit is a CloudWatch metric unit
+type metricFlag []writer.EMFMetric
+
+// String implements flag.Value
+func (m *metricFlag) String() string {
+	if m == nil {
+		return ""
+	}
+
+	metrics := make([]string, len(*m))
+	for i, metric := range *m {
+		metrics[i] = metric.Field
+		if metric.Unit != "" {
+			metrics[i] += ":" + metric.Unit
+		}
+	}
+	return strings.Join(metrics, ",")
+}
+
+// Set implements flag.Value
+func (m *metricFlag) Set(s string) error {
+	field, unit := s, ""
+	if i := strings.LastIndexByte(s, ':'); i >= 0 {
+		field, unit = s[:i], s[i+1:]
+	}
+	if field == "" {
+		return fmt.Errorf("invalid metric %q: expected field or field:Unit", s)
+	}
+	if unit != "" && !validUnit(unit) {
+		return fmt.Errorf("invalid metric %q: unknown unit %q", s, unit)
+	}
+
+	*m = append(*m, writer.EMFMetric{Field: field, Unit: unit})
+	return nil
+}
+
+func validUnit(unit string) bool {
+	for _, u := range cloudwatch.StandardUnit_Values() {
+		if u == unit {
+			return true
+		}
+	}
+	return false
+}
+
+// stringsFlag is a repeatable flag that collects strings
+type stringsFlag []string
+
+// String implements flag.Value
+func (s *stringsFlag) String() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join(*s, ",")
+}
+
+// Set implements flag.Value
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// byteSize is a flag holding a number of bytes, written as a plain number or
+// with a suffix such as 512KB or 5MB. Suffixes are powers of 1024 and are case
+// insensitive; KiB, MiB, and GiB are accepted as well.
+type byteSize int
+
+// byteUnits maps byteSize suffixes to their multipliers, longest first so
+// that e.g. KB isn't mistaken for B
+var byteUnits = []struct {
+	suffix string
+	n      int
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30},
+	{"b", 1},
+}
+
+// String implements flag.Value
+func (b *byteSize) String() string {
+	if b == nil {
+		return "0"
+	}
+	return strconv.Itoa(int(*b))
+}
+
+// Set implements flag.Value
+func (b *byteSize) Set(s string) error {
+	num, mult := strings.TrimSpace(s), 1
+	for _, u := range byteUnits {
+		if strings.HasSuffix(strings.ToLower(num), u.suffix) {
+			num, mult = strings.TrimSpace(num[:len(num)-len(u.suffix)]), u.n
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q: expected a number of bytes, e.g. 512KB or 5MB", s)
+	}
+	*b = byteSize(n * float64(mult))
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import "testing"
+
+func TestTagFlag(t *testing.T) {
+	tags := tagFlag{}
+	for _, s := range []string{"team=logging", "cost-center=1234", "empty=", "url=a=b"} {
+		if err := tags.Set(s); err != nil {
+			t.Errorf("%q: unexpected error: %v", s, err)
+		}
+	}
+
+	if got, want := tags.String(), "cost-center=1234,empty=,team=logging,url=a=b"; got != want {
+		t.Errorf("unexpected tags: got=%q want=%q", got, want)
+	}
+
+	for _, s := range []string{"", "novalue", "=value"} {
+		if err := tags.Set(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestPatternFlag(t *testing.T) {
+	var patterns patternFlag
+	for _, s := range []string{`token=\S+`, `\d{16}`} {
+		if err := patterns.Set(s); err != nil {
+			t.Errorf("%q: unexpected error: %v", s, err)
+		}
+	}
+
+	if got, want := patterns.String(), `token=\S+,\d{16}`; got != want {
+		t.Errorf("unexpected patterns: got=%q want=%q", got, want)
+	}
+
+	if err := patterns.Set("("); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+	if len(patterns) != 2 {
+		t.Errorf("an invalid pattern should not be added: got %d patterns", len(patterns))
+	}
+}
+
+func TestMetricFlag(t *testing.T) {
+	var metrics metricFlag
+	for _, s := range []string{"latency_ms:Milliseconds", "requests", "a:b:Count"} {
+		if err := metrics.Set(s); err != nil {
+			t.Errorf("%q: unexpected error: %v", s, err)
+		}
+	}
+
+	if got, want := metrics.String(), "latency_ms:Milliseconds,requests,a:b:Count"; got != want {
+		t.Errorf("unexpected metrics: got=%q want=%q", got, want)
+	}
+
+	for _, s := range []string{"", ":Count", "latency:Millis"} {
+		if err := metrics.Set(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+	if len(metrics) != 3 {
+		t.Errorf("an invalid metric should not be added: got %d metrics", len(metrics))
+	}
+}
+
+func TestColumnSeparator(t *testing.T) {
+	cases := map[string]string{
+		"tab":   "\t",
+		"TAB":   "\t",
+		`\t`:    "\t",
+		"space": " ",
+		",":     ",",
+		" | ":   " | ",
+	}
+
+	for s, expected := range cases {
+		if got := columnSeparator(s); got != expected {
+			t.Errorf("%q: got=%q want=%q", s, got, expected)
+		}
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	cases := map[string]int{
+		"0":     0,
+		"100":   100,
+		"100B":  100,
+		"512KB": 512 << 10,
+		"512k":  512 << 10,
+		"5MB":   5 << 20,
+		"5 MiB": 5 << 20,
+		"1.5mb": 3 << 19,
+		"1G":    1 << 30,
+	}
+
+	for s, expected := range cases {
+		var b byteSize
+		if err := b.Set(s); err != nil {
+			t.Errorf("%q: unexpected error: %v", s, err)
+			continue
+		}
+		if int(b) != expected {
+			t.Errorf("%q: got=%d want=%d", s, b, expected)
+		}
+	}
+
+	for _, s := range []string{"", "MB", "-1KB", "5TB", "five"} {
+		var b byteSize
+		if err := b.Set(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Package follow provides an io.Reader that follows a file as it grows, in
+// the manner of tail -f
+package follow
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// pollInterval is the default interval at which a Reader checks for new data
+// after reaching the end of the file
+const pollInterval = 250 * time.Millisecond
+
+// Reader reads from a file and, on reaching the end, waits for more data to
+// be appended rather than returning io.EOF. If the file is truncated or
+// replaced (e.g. by log rotation), Reader reopens it and continues from the
+// beginning. When the file is replaced, anything written to the old file
+// before the switch is read first, so lines written just before rotation
+// aren't lost.
+//
+// The zero-value is not usable. NewReader should be used to construct a new
+// Reader
+type Reader struct {
+	// PollInterval is how often the file is checked for new data once the
+	// end has been reached
+	PollInterval time.Duration
+
+	ctx    context.Context
+	path   string
+	f      *os.File
+	offset int64
+
+	// next, if set, is the file that replaced f. It is switched to once f
+	// has been read to the end
+	next *os.File
+}
+
+// NewReader opens the named file and returns a Reader that follows it. The
+// Reader returns io.EOF once ctx is done.
+func NewReader(ctx context.Context, path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{
+		PollInterval: pollInterval,
+		ctx:          ctx,
+		path:         path,
+		f:            f,
+	}, nil
+}
+
+// Read implements io.Reader. It blocks until data is available or the
+// Reader's context is done.
+func (r *Reader) Read(b []byte) (int, error) {
+	for {
+		n, err := r.f.Read(b)
+		r.offset += int64(n)
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		if r.next != nil {
+			r.f.Close()
+			r.f, r.next = r.next, nil
+			r.offset = 0
+			continue
+		}
+
+		rotated, err := r.checkRotation()
+		if err != nil {
+			return 0, err
+		}
+		if rotated {
+			continue
+		}
+
+		t := time.NewTimer(r.PollInterval)
+		select {
+		case <-r.ctx.Done():
+			t.Stop()
+			return 0, io.EOF
+		case <-t.C:
+		}
+	}
+}
+
+// Close implements io.Closer
+func (r *Reader) Close() error {
+	if r.next != nil {
+		r.next.Close()
+	}
+	return r.f.Close()
+}
+
+// checkRotation reports whether the file has been truncated or replaced since
+// it was opened. A truncated file is read again from the beginning. If the
+// file was replaced, the new one is opened as next, and the old one is read
+// once more to pick up anything written since it was last read.
+func (r *Reader) checkRotation() (bool, error) {
+	fi, err := os.Stat(r.path)
+	if os.IsNotExist(err) {
+		// the file has been moved but not yet replaced; keep waiting
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	cur, err := r.f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	if !os.SameFile(fi, cur) {
+		f, err := os.Open(r.path)
+		if err != nil {
+			return false, err
+		}
+		r.next = f
+		return true, nil
+	}
+
+	if fi.Size() < r.offset {
+		if _, err := r.f.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		r.offset = 0
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,133 @@
+package follow
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readString(t *testing.T, r io.Reader, n int) string {
+	t.Helper()
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return string(b)
+}
+
+func appendString(t *testing.T, path, s string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "follow")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte("first\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := NewReader(ctx, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Close()
+	r.PollInterval = time.Millisecond
+
+	if got := readString(t, r, 6); got != "first\n" {
+		t.Errorf("unexpected data: got=%q want=%q", got, "first\n")
+	}
+
+	// data appended after EOF is picked up
+	appendString(t, path, "second\n")
+	if got := readString(t, r, 7); got != "second\n" {
+		t.Errorf("unexpected data: got=%q want=%q", got, "second\n")
+	}
+
+	// a truncated file is read from the beginning
+	if err := ioutil.WriteFile(path, []byte("new\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := readString(t, r, 4); got != "new\n" {
+		t.Errorf("unexpected data: got=%q want=%q", got, "new\n")
+	}
+
+	cancel()
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("expected EOF after cancel: n=%d err=%v", n, err)
+	}
+}
+
+func TestReaderRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "follow")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte("before\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r, err := NewReader(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Close()
+	r.PollInterval = time.Millisecond
+
+	if got := readString(t, r, 7); got != "before\n" {
+		t.Errorf("unexpected data: got=%q want=%q", got, "before\n")
+	}
+
+	// rotate the file as logrotate does. The new file is larger than the
+	// read offset, so only its inode shows that it was replaced
+	rotated := path + ".1"
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte("after rotation\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the reader notices the rotation before the application has reopened
+	// its log, which it writes to once more
+	if rotated, err := r.checkRotation(); err != nil || !rotated {
+		t.Fatalf("rotation was not detected: rotated=%v err=%v", rotated, err)
+	}
+	appendString(t, rotated, "late\n")
+
+	if got := readString(t, r, 5); got != "late\n" {
+		t.Errorf("data written to the old file was lost: got=%q want=%q", got, "late\n")
+	}
+	if got := readString(t, r, 15); got != "after rotation\n" {
+		t.Errorf("unexpected data: got=%q want=%q", got, "after rotation\n")
+	}
+
+	// the new file is followed
+	appendString(t, path, "more\n")
+	if got := readString(t, r, 5); got != "more\n" {
+		t.Errorf("unexpected data: got=%q want=%q", got, "more\n")
+	}
+}
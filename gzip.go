@@ -0,0 +1,48 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// isGzipped reports whether input should be decompressed: if the gzip flag is
+// set, or name is a file ending in .gz
+func isGzipped(name string, force bool) bool {
+	return force || strings.HasSuffix(name, ".gz")
+}
+
+// gzipReader decompresses a gzip stream, closing the underlying reader when
+// it is closed
+type gzipReader struct {
+	*gzip.Reader
+	src io.Closer
+}
+
+// gunzip returns a reader that decompresses src. Concatenated gzip streams,
+// as produced by appending to a .gz file, are read one after another. If src
+// isn't gzip-compressed, or is corrupt, an error is returned, either here or
+// from Read.
+func gunzip(src io.ReadCloser) (io.ReadCloser, error) {
+	zr, err := gzip.NewReader(src)
+	if err != nil {
+		return nil, fmt.Errorf("input is not valid gzip: %v", err)
+	}
+	return &gzipReader{Reader: zr, src: src}, nil
+}
+
+// Read implements io.Reader
+func (r *gzipReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("corrupt gzip input: %w", err)
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (r *gzipReader) Close() error {
+	r.Reader.Close()
+	return r.src.Close()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kylemcc/cwlog/writer/cwlogtest"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return b.Bytes()
+}
+
+func TestRunGzip(t *testing.T) {
+	// two members, as if lines were appended to the file later
+	data := append(gzipped(t, "line 1\nline 2\n"), gzipped(t, "line 3\n")...)
+
+	src, err := gunzip(ioutil.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer src.Close()
+
+	client := cwlogtest.NewClient()
+	if _, err := run(context.Background(), client, "group", "stream", src, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"line 1", "line 2", "line 3"}
+	if got := client.Messages(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected messages: got=%q want=%q", got, expected)
+	}
+}
+
+func TestGunzipCorrupt(t *testing.T) {
+	if _, err := gunzip(ioutil.NopCloser(strings.NewReader("plain text\n"))); err == nil {
+		t.Error("expected an error for input that isn't gzip-compressed")
+	}
+
+	data := gzipped(t, strings.Repeat("a log line\n", 100))
+	src, err := gunzip(ioutil.NopCloser(bytes.NewReader(data[:len(data)-10])))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ioutil.ReadAll(src); err == nil || !strings.Contains(err.Error(), "corrupt gzip input") {
+		t.Errorf("expected a corrupt input error, got: %v", err)
+	}
+}
+
+func TestIsGzipped(t *testing.T) {
+	cases := []struct {
+		name     string
+		force    bool
+		expected bool
+	}{
+		{"app.log.gz", false, true},
+		{"app.log", false, false},
+		{"app.log", true, true},
+		{"", true, true},
+		{"", false, false},
+	}
+
+	for _, c := range cases {
+		if got := isGzipped(c.name, c.force); got != c.expected {
+			t.Errorf("isGzipped(%q, %v) = %v, want %v", c.name, c.force, got, c.expected)
+		}
+	}
+}
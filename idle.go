@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// idleWatch notes activity on both sides of the copy from the input to the
+// writer, so that a lack of input can be detected
+type idleWatch struct {
+	active chan struct{}
+
+	// writing is the number of Writes in progress. A Write blocks while the
+	// writer's buffer is full, and the input isn't idle while it waits to be
+	// written, so the input can't go idle during one
+	writing int32
+}
+
+func (w *idleWatch) mark() {
+	select {
+	case w.active <- struct{}{}:
+	default:
+	}
+}
+
+// idleReader notes whenever a Read returns data
+type idleReader struct {
+	io.Reader
+	watch *idleWatch
+}
+
+// Read implements io.Reader
+func (r *idleReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	if n > 0 {
+		r.watch.mark()
+	}
+	return n, err
+}
+
+// idleWriter notes when each Write starts and ends, so that the idle timeout
+// restarts once a blocked Write returns
+type idleWriter struct {
+	io.WriteCloser
+	watch *idleWatch
+}
+
+// Write implements io.Writer
+func (w *idleWriter) Write(b []byte) (int, error) {
+	atomic.AddInt32(&w.watch.writing, 1)
+	w.watch.mark()
+	defer func() {
+		atomic.AddInt32(&w.watch.writing, -1)
+		w.watch.mark()
+	}()
+
+	return w.WriteCloser.Write(b)
+}
+
+// watchIdle returns a reader for src, a writer for dst, and a channel that is
+// closed once no input has been read from src for timeout, e.g. because the
+// producer has finished without closing the pipe. Time spent blocked writing
+// to dst doesn't count. If timeout is not positive, src and dst are returned
+// unchanged with a nil channel, which is never closed.
+func watchIdle(ctx context.Context, src io.Reader, dst io.WriteCloser, timeout time.Duration) (io.Reader, io.WriteCloser, <-chan struct{}) {
+	if timeout <= 0 {
+		return src, dst, nil
+	}
+
+	watch := &idleWatch{active: make(chan struct{}, 1)}
+	idle := make(chan struct{})
+	go func() {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-watch.active:
+				if !t.Stop() {
+					select {
+					case <-t.C:
+					default:
+					}
+				}
+				t.Reset(timeout)
+			case <-t.C:
+				if atomic.LoadInt32(&watch.writing) > 0 {
+					t.Reset(timeout)
+					continue
+				}
+				close(idle)
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return &idleReader{Reader: src, watch: watch}, &idleWriter{WriteCloser: dst, watch: watch}, idle
+}
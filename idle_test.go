@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kylemcc/cwlog/writer"
+	"github.com/kylemcc/cwlog/writer/cwlogtest"
+)
+
+func TestRunIdleTimeout(t *testing.T) {
+	// a producer that writes some input and goes quiet without closing it
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() {
+		pw.Write([]byte("line 1\n"))
+		time.Sleep(30 * time.Millisecond)
+		pw.Write([]byte("line 2\n"))
+	}()
+
+	client := cwlogtest.NewClient()
+	start := time.Now()
+	sig, err := run(context.Background(), client, "group", "stream", pr, 100*time.Millisecond)
+	if err != nil || sig != nil {
+		t.Fatalf("unexpected result: sig=%v err=%v", sig, err)
+	}
+
+	// the quiet period only starts after the second line
+	if elapsed := time.Since(start); elapsed < 130*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("run did not exit after the idle timeout: took %v", elapsed)
+	}
+
+	expected := []string{"line 1", "line 2"}
+	if got := client.Messages(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected messages: got=%q want=%q", got, expected)
+	}
+}
+
+func TestRunIdleTimeoutBackpressure(t *testing.T) {
+	// a producer that writes more input than the writer can buffer and goes
+	// quiet without closing it
+	var lines []string
+	for i := 0; i < 20; i++ {
+		lines = append(lines, fmt.Sprintf("line %d %s", i, strings.Repeat("x", 1000)))
+	}
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte(strings.Join(lines, "\n") + "\n"))
+
+	// the first flush takes longer than the idle timeout, so the copy is
+	// blocked writing the rest of the input while it runs
+	client := cwlogtest.NewClient()
+	var once sync.Once
+	client.PutHook = func(context.Context) error {
+		once.Do(func() { time.Sleep(300 * time.Millisecond) })
+		return nil
+	}
+
+	sig, err := run(context.Background(), client, "group", "stream", pr, 100*time.Millisecond,
+		writer.WithFlushInterval(10*time.Millisecond),
+		writer.WithMaxBufferBytes(8192),
+		writer.WithOverflowPolicy(writer.Block),
+	)
+	if err != nil || sig != nil {
+		t.Fatalf("unexpected result: sig=%v err=%v", sig, err)
+	}
+
+	if got := client.Messages(); !reflect.DeepEqual(got, lines) {
+		t.Errorf("unexpected messages: got %d, want %d", len(got), len(lines))
+	}
+}
+
+func TestWatchIdleDisabled(t *testing.T) {
+	src := strings.NewReader("input")
+	dst := &fakeWriter{}
+	r, w, idle := watchIdle(context.Background(), src, dst, 0)
+	if r != io.Reader(src) || w != io.WriteCloser(dst) || idle != nil {
+		t.Errorf("expected the source and destination to be returned unchanged without an idle channel")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// openInputs opens the named files and returns a reader that reads them one
+// after another, in the order given, so that timestamps parsed from their
+// lines stay in order. Each file ending in .gz, or every file if gzip is set,
+// is decompressed. A file that doesn't end with sep is followed by one, so
+// that its last record isn't joined to the next file's first. If any file
+// can't be opened, the ones already opened are closed and the error names
+// the file.
+func openInputs(ctx context.Context, names []string, tail, gzip bool, sep byte) (io.ReadCloser, error) {
+	var files multiFile
+	for i, name := range names {
+		f, err := openInput(ctx, name, tail)
+		if err != nil {
+			files.Close()
+			return nil, err
+		}
+
+		if isGzipped(name, gzip) {
+			zr, err := gunzip(f)
+			if err != nil {
+				f.Close()
+				files.Close()
+				return nil, fmt.Errorf("input-file %s: %v", name, err)
+			}
+			f = zr
+		}
+
+		files.closers = append(files.closers, f)
+		if i < len(names)-1 {
+			files.readers = append(files.readers, &terminatedReader{r: f, sep: sep, last: -1})
+		} else {
+			files.readers = append(files.readers, f)
+		}
+	}
+
+	if len(names) == 1 {
+		return files.closers[0], nil
+	}
+	files.Reader = io.MultiReader(files.readers...)
+	return &files, nil
+}
+
+// multiFile reads several files in sequence and closes all of them when it
+// is closed
+type multiFile struct {
+	io.Reader
+	readers []io.Reader
+	closers []io.ReadCloser
+}
+
+// Close implements io.Closer
+func (m *multiFile) Close() error {
+	var err error
+	for _, c := range m.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// terminatedReader reads from r and, if r's data doesn't end with sep, adds
+// one at the end
+type terminatedReader struct {
+	r   io.Reader
+	sep byte
+
+	// last is the last byte read from r, or -1 if nothing has been read
+	last int
+}
+
+// Read implements io.Reader
+func (t *terminatedReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	n, err := t.r.Read(b)
+	if n > 0 {
+		t.last = int(b[n-1])
+	}
+	if err != io.EOF || t.last < 0 || byte(t.last) == t.sep {
+		return n, err
+	}
+
+	// there's no room for the separator in this read, so it's added by the
+	// next one, when r returns io.EOF again
+	if n == len(b) {
+		return n, nil
+	}
+	b[n] = t.sep
+	t.last = int(t.sep)
+	return n + 1, io.EOF
+}
+
+// delimiterByte returns the byte ending each record for the --delimiter
+// value d, as accepted by delimiterSplit
+func delimiterByte(d string) byte {
+	switch strings.ToLower(d) {
+	case "newline", "json":
+		return '\n'
+	case "nul":
+		return 0
+	}
+	return d[0]
+}
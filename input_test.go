@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kylemcc/cwlog/writer/cwlogtest"
+)
+
+func TestOpenInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cwlog")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(name, []byte("line 1\nline 2\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f, err := openInput(context.Background(), name, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+
+	// the file is still copied to the tee
+	var teed bytes.Buffer
+	client := cwlogtest.NewClient()
+	if _, err := run(context.Background(), client, "group", "stream", getSource(f, &teed), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"line 1", "line 2"}
+	if got := client.Messages(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected messages: got=%q want=%q", got, expected)
+	}
+	if teed.String() != "line 1\nline 2\n" {
+		t.Errorf("unexpected tee output: %q", teed.String())
+	}
+
+	missing := filepath.Join(dir, "missing.log")
+	if _, err := openInput(context.Background(), missing, false); err == nil || err.Error() != "input-file "+missing+" does not exist" {
+		t.Errorf("unexpected error for a missing file: %v", err)
+	}
+
+	// root can read the file regardless of its mode
+	if os.Geteuid() == 0 {
+		return
+	}
+	unreadable := filepath.Join(dir, "unreadable.log")
+	if err := ioutil.WriteFile(unreadable, []byte("secret\n"), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := openInput(context.Background(), unreadable, false); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("unexpected error for an unreadable file: %v", err)
+	}
+}
+
+func TestOpenInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cwlog")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// oldest first, as rotated logs would be shipped. app.log.2 has no
+	// trailing newline, so its last line must not run into the next file's
+	files := map[string][]byte{
+		"app.log.3.gz": gzipped(t, "line 1\nline 2\n"),
+		"app.log.2":    []byte("line 3\nline 4"),
+		"app.log.1":    []byte("line 5\n"),
+	}
+	var names []string
+	for _, name := range []string{"app.log.3.gz", "app.log.2", "app.log.1"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, files[name], 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		names = append(names, path)
+	}
+
+	src, err := openInputs(context.Background(), names, false, false, '\n')
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer src.Close()
+
+	client := cwlogtest.NewClient()
+	if _, err := run(context.Background(), client, "group", "stream", src, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"line 1", "line 2", "line 3", "line 4", "line 5"}
+	if got := client.Messages(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected messages: got=%q want=%q", got, expected)
+	}
+
+	missing := filepath.Join(dir, "app.log.0")
+	_, err = openInputs(context.Background(), []string{names[0], missing, names[2]}, false, false, '\n')
+	if err == nil || !strings.Contains(err.Error(), missing) {
+		t.Errorf("expected an error naming %s, got: %v", missing, err)
+	}
+}
+
+func TestTerminatedReader(t *testing.T) {
+	cases := map[string]string{
+		"":           "",
+		"one":        "one\n",
+		"one\n":      "one\n",
+		"one\ntwo":   "one\ntwo\n",
+		"0123456789": "0123456789\n",
+	}
+
+	for in, expected := range cases {
+		// a one byte reader leaves no room for the separator in the last read
+		r := &terminatedReader{r: cwlogtest.NewChunkReader([]byte(in)), sep: '\n', last: -1}
+		got, err := ioutil.ReadAll(&oneByteReader{r})
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", in, err)
+		}
+		if string(got) != expected {
+			t.Errorf("%q: got=%q want=%q", in, got, expected)
+		}
+	}
+}
+
+// oneByteReader reads from r one byte at a time
+type oneByteReader struct {
+	r *terminatedReader
+}
+
+func (o *oneByteReader) Read(b []byte) (int, error) {
+	if len(b) > 1 {
+		b = b[:1]
+	}
+	return o.r.Read(b)
+}
+
+func TestDelimiterByte(t *testing.T) {
+	cases := map[string]byte{"newline": '\n', "json": '\n', "NUL": 0, ",": ','}
+	for d, expected := range cases {
+		if got := delimiterByte(d); got != expected {
+			t.Errorf("%q: got=%q want=%q", d, got, expected)
+		}
+	}
+}
@@ -14,24 +14,131 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/genuinetools/pkg/cli"
+	"github.com/kylemcc/cwlog/follow"
 	"github.com/kylemcc/cwlog/version"
 	"github.com/kylemcc/cwlog/writer"
 )
 
+// maxChunkBytes is the largest chunk-bytes value: the most message text a
+// single CloudWatch Logs event can hold, after its overhead
+const maxChunkBytes = writer.MaxEventSize - writer.EventOverhead
+
+// minFlushInterval is the shortest flush-interval. Flushing more often would
+// mostly send tiny batches into the per-stream request quota
+const minFlushInterval = 100 * time.Millisecond
+
 var (
-	tee bool
+	tee   bool
+	teeTo string
+	quiet bool
+
+	// teeOut and teeErr receive copies of the input and, when running a
+	// command, its standard error. teeFile is set if they are a file
+	teeOut  io.Writer
+	teeErr  io.Writer
+	teeFile *os.File
+
+	// exitStatus is the command's exit code, or the code for the signal that
+	// stopped cwlog. It is set by the Action, which must return before main
+	// exits so that its deferred closes run
+	exitStatus int
 
 	logGroup  string
 	logStream string
+
+	flushInterval   time.Duration
+	shutdownTimeout time.Duration
+	idleTimeout     time.Duration
+	repeatWindow    time.Duration
+	retentionDays   int
+
+	timestampFormat    string
+	jsonTimestampField string
+	timestampColumn    int
+	fieldSeparator     string
+	multilinePattern   string
+	stripANSI          bool
+	trimSpace          bool
+	includePattern     string
+	excludePattern     string
+	include            *regexp.Regexp
+	exclude            *regexp.Regexp
+	redact             patternFlag
+	redactAWSKeys      bool
+	redactEmails       bool
+	maxLineBytes       int
+	maxThroughput      byteSize
+	prefix             string
+	suffix             string
+	enrich             bool
+	enrichFormat       string
+	emfNamespace       string
+	emfMetrics         metricFlag
+	emfDimensions      stringsFlag
+	jsonOutput         bool
+	levelPattern       string
+	level              *regexp.Regexp
+	multilineStart     *regexp.Regexp
+	delimiter          string
+	chunkBytes         byteSize
+	neverSplitLines    bool
+	split              bufio.SplitFunc
+	tags               = tagFlag{}
+	kmsKeyID           string
+	noCreate           bool
+
+	stderrStream string
+
+	inputFiles  stringsFlag
+	followInput bool
+	gzipInput   bool
+	input       io.ReadCloser
+
+	dryRun         bool
+	preflight      bool
+	summary        bool
+	verbose        bool
+	sequenceTokens bool
+	tokenFile      string
+	sequenceToken  string
+	deadLetterFile string
+
+	region      string
+	profile     string
+	endpointURL string
+	roleARN     string
+	externalID  string
+
+	useFIPSEndpoint      bool
+	useDualStackEndpoint bool
+
+	httpTimeout    time.Duration
+	connectTimeout time.Duration
+
+	showVersion bool
+
+	// started is when cwlog started, for the elapsed time in the summary
+	started = time.Now()
 )
 
 func main() {
@@ -42,11 +149,13 @@ func main() {
 	p.Description = `A tee(1)-like command for piping output to CloudWatch Logs.
 
 This program will read line-oriented data from standard input and send
-log events to CloudWatch Logs. If the specified log group and/or log stream
-do not exist, cwlog will attempt to create them. CloudWatch Logs also
-requires a sequence token for existing streams that already contain log
-events. If an existing stream is specified, cwlog will automatically
-retrieve the next sequence token.
+log events to CloudWatch Logs. Alternatively, a command may be given after
+"--", in which case cwlog runs it and sends its standard output and standard
+error, exiting with the command's exit code. If the specified log group and/or log stream
+do not exist, cwlog will attempt to create them unless --no-create is given.
+CloudWatch Logs no longer requires sequence tokens, so none are sent unless
+--sequence-tokens is given, in which case cwlog automatically retrieves the
+next sequence token for existing streams.
 
 The execution of this program is optimized for the scenario where it is
 invoked with an existing-but-empty log stream. It first attempts to write to
@@ -56,46 +165,719 @@ if it receives an error.`
 	p.FlagSet = flag.NewFlagSet("global", flag.ExitOnError)
 	p.FlagSet.BoolVar(&tee, "tee", true, "If true, output will be copied to stdout")
 	p.FlagSet.BoolVar(&tee, "t", true, "If true, output will be copied to stdout")
+	p.FlagSet.BoolVar(&quiet, "quiet", false, "Don't copy output anywhere, overriding tee, but still print errors to stderr")
+	p.FlagSet.BoolVar(&quiet, "q", false, "Don't copy output anywhere, overriding tee, but still print errors to stderr")
+	p.FlagSet.StringVar(&teeTo, "tee-to", "stdout", "Where tee copies output: stdout, stderr, or the path of a file to append to. With stdout, a command's standard error is copied to stderr; otherwise both go to the same place")
 	p.FlagSet.StringVar(&logGroup, "log-group", os.Getenv("CWLOG_LOG_GROUP"), "(Required) The name of the log group where logs should be sent. The program will attempt to create this if it does not exist. [env CWLOG_LOG_GROUP=]")
 	p.FlagSet.StringVar(&logGroup, "g", os.Getenv("CWLOG_LOG_GROUP"), "(Required) The name of the log group where logs should be sent. The program will attempt to create this if it does not exist. [env CWLOG_LOG_GROUP=]")
-	p.FlagSet.StringVar(&logStream, "log-stream", os.Getenv("CWLOG_LOG_STREAM"), "(Required) The name of the log stream where logs should be sent. The program will attempt to create this if it does not exist. [env CWLOG_LOG_STREAM=]")
-	p.FlagSet.StringVar(&logStream, "s", os.Getenv("CWLOG_LOG_STREAM"), "(Required) The name of the log stream where logs should be sent. The program will attempt to create this if it does not exist. [env CWLOG_LOG_STREAM=]")
+	p.FlagSet.StringVar(&logStream, "log-stream", os.Getenv("CWLOG_LOG_STREAM"), "(Required) The name of the log stream where logs should be sent. The program will attempt to create this if it does not exist. May contain the placeholders {date}, {hostname}, and {pid}. [env CWLOG_LOG_STREAM=]")
+	p.FlagSet.StringVar(&logStream, "s", os.Getenv("CWLOG_LOG_STREAM"), "(Required) The name of the log stream where logs should be sent. The program will attempt to create this if it does not exist. May contain the placeholders {date}, {hostname}, and {pid}. [env CWLOG_LOG_STREAM=]")
+	p.FlagSet.DurationVar(&flushInterval, "flush-interval", 2*time.Second, "How often buffered log events are sent to CloudWatch Logs (e.g. 500ms, 30s). Must be at least 100ms")
+	p.FlagSet.DurationVar(&flushInterval, "f", 2*time.Second, "How often buffered log events are sent to CloudWatch Logs (e.g. 500ms, 30s). Must be at least 100ms")
+	p.FlagSet.DurationVar(&idleTimeout, "idle-timeout", 0, "Flush and exit once no input has arrived for this long, e.g. when the producer has finished without closing standard input. 0 waits for the input to end")
+	p.FlagSet.DurationVar(&shutdownTimeout, "shutdown-timeout", 10*time.Second, "How long to keep sending buffered log events once input ends or cwlog is interrupted. Events still unsent are counted and dropped. 0 waits until they are sent")
+	p.FlagSet.StringVar(&region, "region", "", "The AWS region to send logs to. If unset, the region is resolved from the environment (AWS_REGION) or shared config")
+	p.FlagSet.StringVar(&region, "r", "", "The AWS region to send logs to. If unset, the region is resolved from the environment (AWS_REGION) or shared config")
+	p.FlagSet.StringVar(&timestampFormat, "timestamp-format", "", "Parse each event's timestamp from the beginning of the line using this Go time layout or one of the named formats rfc3339, syslog, or datetime. Timestamps without a time zone are read in local time. Lines without a timestamp use the current time")
+	p.FlagSet.IntVar(&timestampColumn, "timestamp-column", 0, "Read each event's timestamp, in epoch milliseconds or RFC3339, from this column of the line, counting from 1, and send the rest of the line as the message. Columns are split by field-separator. Lines with too few columns or no timestamp are sent whole with the current time")
+	p.FlagSet.StringVar(&fieldSeparator, "field-separator", "tab", "The string separating the columns used by timestamp-column: tab, space, or any other string")
+	p.FlagSet.StringVar(&jsonTimestampField, "json-timestamp-field", "", "For lines that are JSON objects, read each event's timestamp from this field, which may hold an RFC3339 string or epoch milliseconds. Other lines use the current time")
+	p.FlagSet.StringVar(&delimiter, "delimiter", "newline", "How input is split into log events: newline, nul (NUL-separated records), json (concatenated JSON values), or any single character")
+	p.FlagSet.Var(&chunkBytes, "chunk-bytes", "Split input into events of this many bytes, e.g. 64KB, instead of into lines, for output where lines aren't meaningful. Newlines are kept in the events. At most 262118 bytes, the most a CloudWatch Logs event can hold")
+	p.FlagSet.BoolVar(&neverSplitLines, "batch-on-newline-only", false, "Always send each line as a single event, for consumers that can't handle part of a line, such as JSON parsers. Lines over the 256KB CloudWatch Logs limit are truncated instead of split into several events. Cannot be used with chunk-bytes")
+	p.FlagSet.DurationVar(&repeatWindow, "compress-repeated", 0, "Collapse runs of identical consecutive lines into a single event of the form \"(repeated 1423 times) line\", or with a \"repeated\" field if the event is JSON, holding each line until a different one arrives or this long has passed, e.g. 10s. 0 sends every line as it arrives")
+	p.FlagSet.BoolVar(&trimSpace, "trim-space", false, "Remove leading and trailing whitespace from each line before sending it, dropping lines that are left empty. Output copied to stdout is unchanged")
+	p.FlagSet.BoolVar(&stripANSI, "strip-ansi", false, "Remove ANSI color and cursor escape sequences from each line before sending it. Output copied to stdout is unchanged")
+	p.FlagSet.StringVar(&includePattern, "include", "", "Only send lines matching this regular expression. Output copied to stdout is not filtered")
+	p.FlagSet.StringVar(&excludePattern, "exclude", "", "Don't send lines matching this regular expression. Output copied to stdout is not filtered")
+	p.FlagSet.Var(&redact, "redact", "Replace text matching this regular expression with *** before sending. May be repeated. Output copied to stdout is not redacted")
+	p.FlagSet.BoolVar(&redactAWSKeys, "redact-aws-keys", false, "Replace AWS access key IDs with *** before sending")
+	p.FlagSet.BoolVar(&redactEmails, "redact-emails", false, "Replace email addresses with *** before sending")
+	p.FlagSet.Var(&maxThroughput, "max-throughput", "The most bytes per second to send to CloudWatch Logs, e.g. 512KB or 5MB, to avoid saturating the network or running up ingestion costs during a large backfill. Sending slows down to stay under the limit; nothing is dropped. 0 means no limit")
+	p.FlagSet.IntVar(&maxLineBytes, "max-line-bytes", 0, "Cut off lines longer than this many bytes, marking them with the number of bytes dropped. By default, lines over the 256KB CloudWatch Logs limit are split into several events")
+	p.FlagSet.StringVar(&prefix, "prefix", "", "Prepend this string to every log event, e.g. to identify the host or environment. Output copied to stdout is unchanged")
+	p.FlagSet.StringVar(&suffix, "suffix", "", "Append this string to every log event. Output copied to stdout is unchanged")
+	p.FlagSet.BoolVar(&enrich, "enrich", false, "Annotate every log event with the hostname and process ID, in the format given by enrich-format")
+	p.FlagSet.StringVar(&enrichFormat, "enrich-format", "json", "How enrich annotates log events: json wraps each event in {\"host\":...,\"pid\":...,\"msg\":...}, kv prepends host=... pid=...")
+	p.FlagSet.StringVar(&emfNamespace, "emf-namespace", "", "Wrap JSON lines containing any of the fields given by emf-metric in the CloudWatch Embedded Metric Format, so that CloudWatch extracts them as metrics in this namespace. The original line is kept in the message field")
+	p.FlagSet.Var(&emfMetrics, "emf-metric", "A numeric JSON field to extract as a metric when emf-namespace is set, in the form field or field:Unit, e.g. latency_ms:Milliseconds. May be repeated")
+	p.FlagSet.Var(&emfDimensions, "emf-dimension", "A string JSON field to attach to extracted metrics as a dimension. May be repeated")
+	p.FlagSet.BoolVar(&jsonOutput, "json-output", false, "Wrap each line that isn't already JSON in {\"@timestamp\":...,\"message\":...}, so that CloudWatch Logs Insights can query its fields. The prefix and suffix become part of the message")
+	p.FlagSet.StringVar(&levelPattern, "level-pattern", "", "With json-output, a regular expression finding the log level of each line, which is added as a level field. If it has a capturing group, the group's text is used, e.g. level=(\\w+)")
+	p.FlagSet.StringVar(&multilinePattern, "multiline-pattern", "", "A regular expression matching the first line of each event. Lines that don't match are appended to the preceding event, e.g. to keep stack traces together")
+	p.FlagSet.IntVar(&retentionDays, "retention-days", 0, "If cwlog creates the log group, set its retention policy to this many days (1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, or 3653). Existing log groups are not modified")
+	p.FlagSet.Var(tags, "tag", "A key=value tag to apply to the log group if cwlog creates it. May be repeated")
+	p.FlagSet.StringVar(&kmsKeyID, "kms-key-id", "", "The ARN of a KMS key used to encrypt the log group if cwlog creates it")
+	p.FlagSet.BoolVar(&noCreate, "no-create", false, "Don't create the log group or log stream if they don't exist, e.g. when cwlog's IAM role isn't allowed to. Writing to a missing log group or stream fails instead")
+	p.FlagSet.StringVar(&stderrStream, "stderr-stream", "", "When running a command, send its standard error to this log stream instead of log-stream")
+	p.FlagSet.Var(&inputFiles, "input-file", "Read log lines from this file instead of standard input. May be repeated to read several files, such as rotated logs, one after another in the order given")
+	p.FlagSet.Var(&inputFiles, "i", "Read log lines from this file instead of standard input. May be repeated to read several files, such as rotated logs, one after another in the order given")
+	p.FlagSet.BoolVar(&followInput, "follow", false, "Keep reading from input-file as it grows, like tail -f. The file is reopened if it is truncated or replaced")
+	p.FlagSet.BoolVar(&gzipInput, "gzip", false, "Decompress gzip-compressed input. This is the default for an input-file ending in .gz")
+	p.FlagSet.BoolVar(&followInput, "F", false, "Keep reading from input-file as it grows, like tail -f. The file is reopened if it is truncated or replaced")
+	p.FlagSet.BoolVar(&dryRun, "dry-run", false, "Print a summary of each batch of log events to stderr instead of sending it to CloudWatch Logs. No AWS credentials are needed")
+	p.FlagSet.BoolVar(&preflight, "preflight", false, "Check that the log group and log stream exist, creating them unless no-create is set, and that cwlog may use them before reading any input or running the command, so that a misconfiguration fails immediately")
+	p.FlagSet.BoolVar(&summary, "summary", false, "Print a summary of what was sent to stderr on exit: events, bytes, requests, retries, dropped and rejected events, and elapsed time")
+	p.FlagSet.BoolVar(&verbose, "verbose", false, "Print diagnostic messages to stderr, such as the size of each batch, failed requests, and the creation of log groups and streams")
+	p.FlagSet.BoolVar(&verbose, "v", false, "Print diagnostic messages to stderr, such as the size of each batch, failed requests, and the creation of log groups and streams")
+	p.FlagSet.BoolVar(&sequenceTokens, "sequence-tokens", false, "Send the sequence token returned by each request with the next one. This is only needed for endpoints that still require sequence tokens")
+	p.FlagSet.StringVar(&deadLetterFile, "dead-letter-file", "", "Append log events that can't be delivered to this file, one JSON object with message and timestamp fields per line, so they can be replayed later instead of being lost")
+	p.FlagSet.StringVar(&sequenceToken, "sequence-token", "", "The sequence token to send with the first request, if it is already known, e.g. from DescribeLogStreams. Takes precedence over token-file. A stale token is replaced by the one CloudWatch Logs expects. Implies sequence-tokens")
+	p.FlagSet.StringVar(&tokenFile, "token-file", "", "Load the sequence token from this file at startup and save the latest token to it after each request, so the next run can continue without a rejected request. Implies sequence-tokens")
+	p.FlagSet.StringVar(&profile, "profile", "", "Use this profile from the shared AWS credentials and config files instead of the default, as if AWS_PROFILE were set")
+	p.FlagSet.StringVar(&roleARN, "role-arn", "", "The ARN of an IAM role to assume before sending logs, e.g. to write to a log group in another account")
+	p.FlagSet.StringVar(&externalID, "external-id", "", "The external ID to pass when assuming the role given by --role-arn")
+	p.FlagSet.BoolVar(&useFIPSEndpoint, "use-fips-endpoint", false, "Send requests to the FIPS 140-2 validated CloudWatch Logs endpoint for the region. These are available in us-east-1, us-east-2, us-west-1, us-west-2, ca-central-1, ca-west-1, us-gov-east-1, and us-gov-west-1. Ignored if endpoint-url is set")
+	p.FlagSet.BoolVar(&useDualStackEndpoint, "use-dualstack-endpoint", false, "Send requests to the dualstack (IPv4 and IPv6) CloudWatch Logs endpoint for the region. Ignored if endpoint-url is set")
+	p.FlagSet.DurationVar(&httpTimeout, "http-timeout", 30*time.Second, "The longest a single request to CloudWatch Logs may take, including reading the response, before it is abandoned and retried. 0 uses the SDK default, which never times out")
+	p.FlagSet.DurationVar(&connectTimeout, "connect-timeout", 5*time.Second, "The longest cwlog waits to establish a connection to CloudWatch Logs. 0 uses the SDK default")
+	p.FlagSet.StringVar(&endpointURL, "endpoint-url", "", "Send requests to this URL instead of the default CloudWatch Logs endpoint, e.g. http://localhost:4566 for LocalStack or https://vpce-xxxx.logs.us-east-1.vpce.amazonaws.com for a VPC endpoint")
+
+	p.FlagSet.BoolVar(&showVersion, "version", false, "Print the version information and exit")
 
 	p.Before = func(ctx context.Context) error {
+		if showVersion {
+			return nil
+		}
+
 		if logGroup == "" || logStream == "" {
 			p.FlagSet.Usage()
 			return fmt.Errorf("log-group and log-stream are required")
 		}
+
+		if err := writer.ValidateLogGroupName(logGroup); err != nil {
+			return err
+		}
+
+		var err error
+		if logStream, err = expandStreamName(logStream); err != nil {
+			return err
+		}
+		if stderrStream != "" {
+			if stderrStream, err = expandStreamName(stderrStream); err != nil {
+				return err
+			}
+		}
+
+		if maxLineBytes < 0 {
+			return fmt.Errorf("max-line-bytes must not be negative")
+		}
+		if enrichFormat != "json" && enrichFormat != "kv" {
+			return fmt.Errorf("enrich-format must be json or kv, got %q", enrichFormat)
+		}
+		if emfNamespace != "" {
+			if len(emfMetrics) == 0 {
+				return fmt.Errorf("emf-namespace requires at least one emf-metric")
+			}
+			if prefix != "" || suffix != "" || enrich {
+				return fmt.Errorf("emf-namespace cannot be combined with prefix, suffix, or enrich")
+			}
+		} else if len(emfMetrics) > 0 || len(emfDimensions) > 0 {
+			return fmt.Errorf("emf-metric and emf-dimension require emf-namespace")
+		}
+		if jsonOutput && enrich {
+			return fmt.Errorf("json-output cannot be combined with enrich")
+		}
+		if levelPattern != "" {
+			if !jsonOutput {
+				return fmt.Errorf("level-pattern requires json-output")
+			}
+			if level, err = regexp.Compile(levelPattern); err != nil {
+				return fmt.Errorf("level-pattern is not a valid regular expression: %v", err)
+			}
+		}
+		if quiet && verbose {
+			return fmt.Errorf("quiet and verbose cannot be used together")
+		}
+		if err := checkFlushInterval(flushInterval); err != nil {
+			return err
+		}
+		if shutdownTimeout < 0 {
+			return fmt.Errorf("shutdown-timeout cannot be negative")
+		}
+		if idleTimeout < 0 {
+			return fmt.Errorf("idle-timeout cannot be negative")
+		}
+		if repeatWindow < 0 {
+			return fmt.Errorf("compress-repeated cannot be negative")
+		}
+		if idleTimeout > 0 && len(p.FlagSet.Args()) > 0 {
+			return fmt.Errorf("idle-timeout cannot be used when running a command")
+		}
+		if httpTimeout < 0 || connectTimeout < 0 {
+			return fmt.Errorf("http-timeout and connect-timeout must not be negative")
+		}
+		if retentionDays != 0 && !writer.ValidRetentionDays(retentionDays) {
+			return fmt.Errorf("retention-days must be one of the values allowed by CloudWatch Logs, got %d", retentionDays)
+		}
+		if timestampFormat != "" {
+			timestampFormat = timestampLayout(timestampFormat)
+		}
+		if timestampColumn < 0 {
+			return fmt.Errorf("timestamp-column must be positive")
+		}
+		if fieldSeparator == "" {
+			return fmt.Errorf("field-separator must not be empty")
+		}
+		if split, err = delimiterSplit(delimiter); err != nil {
+			return err
+		}
+		if chunkBytes > maxChunkBytes {
+			return fmt.Errorf("chunk-bytes must be at most %d", maxChunkBytes)
+		}
+		if chunkBytes > 0 {
+			if !strings.EqualFold(delimiter, "newline") {
+				return fmt.Errorf("chunk-bytes cannot be used with delimiter")
+			}
+			if multilinePattern != "" {
+				return fmt.Errorf("chunk-bytes cannot be used with multiline-pattern")
+			}
+			if neverSplitLines {
+				return fmt.Errorf("chunk-bytes cannot be used with batch-on-newline-only")
+			}
+			split = writer.ScanChunks(int(chunkBytes))
+		}
+		if multilinePattern != "" {
+			if multilineStart, err = regexp.Compile(multilinePattern); err != nil {
+				return fmt.Errorf("multiline-pattern is not a valid regular expression: %v", err)
+			}
+		}
+		if includePattern != "" {
+			if include, err = regexp.Compile(includePattern); err != nil {
+				return fmt.Errorf("include is not a valid regular expression: %v", err)
+			}
+		}
+		if excludePattern != "" {
+			if exclude, err = regexp.Compile(excludePattern); err != nil {
+				return fmt.Errorf("exclude is not a valid regular expression: %v", err)
+			}
+		}
+		if tokenFile != "" {
+			if stderrStream != "" {
+				return fmt.Errorf("token-file cannot be used with stderr-stream, since each stream has its own token")
+			}
+			sequenceTokens = true
+		}
+		if sequenceToken != "" {
+			if stderrStream != "" {
+				return fmt.Errorf("sequence-token cannot be used with stderr-stream, since each stream has its own token")
+			}
+			sequenceTokens = true
+		}
+		if externalID != "" && roleARN == "" {
+			return fmt.Errorf("external-id requires role-arn")
+		}
+		if endpointURL != "" {
+			if err := checkEndpointURL(endpointURL); err != nil {
+				return err
+			}
+		}
+
+		if followInput && len(inputFiles) == 0 {
+			return fmt.Errorf("follow requires input-file")
+		}
+		if followInput && len(inputFiles) > 1 {
+			return fmt.Errorf("follow can only be used with a single input-file")
+		}
+		if len(inputFiles) > 0 && len(p.FlagSet.Args()) > 0 {
+			return fmt.Errorf("input-file cannot be used when running a command")
+		}
+		if followInput && isGzipped(inputFiles[0], gzipInput) {
+			return fmt.Errorf("follow cannot be used with gzip-compressed input")
+		}
+		if gzipInput && len(p.FlagSet.Args()) > 0 {
+			return fmt.Errorf("gzip cannot be used when running a command")
+		}
+		if stderrStream != "" && len(p.FlagSet.Args()) == 0 {
+			return fmt.Errorf("stderr-stream can only be used when running a command")
+		}
+		if preflight && dryRun {
+			return fmt.Errorf("preflight cannot be used with dry-run")
+		}
+
+		if teeOut, teeErr, teeFile, err = openTee(tee, quiet, teeTo, os.Stdout, os.Stderr); err != nil {
+			return err
+		}
+
+		if len(inputFiles) > 0 {
+			input, err = openInputs(ctx, inputFiles, followInput, gzipInput, delimiterByte(delimiter))
+			return err
+		}
+
+		input = os.Stdin
+		if gzipInput {
+			if input, err = gunzip(input); err != nil {
+				return err
+			}
+		}
 		return nil
 	}
 
 	p.Action = func(ctx context.Context, args []string) error {
-		if err := run(logGroup, logStream, getSource(tee)); err != nil {
+		if showVersion {
+			printVersion(os.Stdout)
+			return nil
+		}
+		defer input.Close()
+		if teeFile != nil {
+			defer func() {
+				teeFile.Sync()
+				teeFile.Close()
+			}()
+		}
+
+		opts := []writer.Option{
+			writer.WithFlushInterval(flushInterval),
+			writer.WithRetentionDays(retentionDays),
+			writer.WithTags(tags),
+			writer.WithKMSKeyID(kmsKeyID),
+			writer.WithSplitFunc(split),
+			writer.WithSequenceTokens(sequenceTokens),
+			writer.WithAutoCreate(!noCreate),
+			writer.WithTrimSpace(trimSpace),
+			writer.WithErrorHandler(newErrorPrinter(os.Stderr)),
+			writer.WithMaxLineBytes(maxLineBytes),
+			writer.WithNeverSplitLines(neverSplitLines),
+			writer.WithCompressRepeated(repeatWindow),
+			writer.WithMaxThroughput(int(maxThroughput)),
+			writer.WithPrefix(prefix),
+			writer.WithSuffix(suffix),
+		}
+		if tokenFile != "" {
+			opts = append(opts, writer.WithTokenStore(writer.FileTokenStore(tokenFile)))
+		}
+		if sequenceToken != "" {
+			opts = append(opts, writer.WithInitialSequenceToken(sequenceToken))
+		}
+		if deadLetterFile != "" {
+			opts = append(opts, writer.WithDeadLetterFile(deadLetterFile))
+		}
+		if verbose {
+			opts = append(opts, writer.WithLogger(log.New(os.Stderr, "cwlog: ", log.LstdFlags)))
+		}
+		if chunkBytes > 0 {
+			// a chunk may end partway through a CRLF
+			opts = append(opts, writer.WithKeepCarriageReturn())
+		}
+		if stripANSI {
+			opts = append(opts, writer.WithStripANSI())
+		}
+		if include != nil {
+			opts = append(opts, writer.WithInclude(include))
+		}
+		if exclude != nil {
+			opts = append(opts, writer.WithExclude(exclude))
+		}
+		for _, re := range redact {
+			opts = append(opts, writer.WithRedactor(re))
+		}
+		if redactAWSKeys {
+			opts = append(opts, writer.WithRedactor(writer.AWSAccessKeyPattern))
+		}
+		if redactEmails {
+			opts = append(opts, writer.WithRedactor(writer.EmailPattern))
+		}
+		if enrich {
+			format := writer.EnrichJSON
+			if enrichFormat == "kv" {
+				format = writer.EnrichKeyValue
+			}
+			opts = append(opts, writer.WithEnrichment(format))
+		}
+		if emfNamespace != "" {
+			opts = append(opts, writer.WithEMF(emfNamespace, emfMetrics, emfDimensions...))
+		}
+		if jsonOutput {
+			opts = append(opts, writer.WithJSONOutput(level))
+		}
+		if multilineStart != nil {
+			opts = append(opts, writer.WithMultilinePattern(multilineStart))
+		}
+		if timestampColumn > 0 {
+			opts = append(opts, writer.WithTimestampColumn(timestampColumn, columnSeparator(fieldSeparator)))
+		}
+		if jsonTimestampField != "" {
+			opts = append(opts, writer.WithJSONTimestampField(jsonTimestampField))
+		}
+		if timestampFormat != "" {
+			opts = append(opts, writer.WithTimestampFormat(timestampFormat))
+		}
+
+		var client writer.Client = newDryRunClient(os.Stderr)
+		if !dryRun {
+			c, err := newClient()
+			if err != nil {
+				return fmt.Errorf("error: failed to create CloudWatch Logs client: %v", err)
+			}
+			client = c
+		}
+
+		if len(args) > 0 {
+			code, err := runCommand(ctx, client, logGroup, logStream, stderrStream, args, teeOut, teeErr, opts...)
+			if err != nil {
+				return fmt.Errorf("error: failed to write logs: %v", err)
+			}
+			exitStatus = code
+			return nil
+		}
+
+		sig, err := run(ctx, client, logGroup, logStream, getSource(input, teeOut), idleTimeout, opts...)
+		if err != nil {
 			return fmt.Errorf("error: failed to write logs: %v", err)
 		}
+		if sig != nil {
+			exitStatus = signalExitCode(sig)
+		}
 		return nil
 	}
 
 	p.Run()
+
+	// exit only after the Action's deferred closes have run
+	if exitStatus != 0 {
+		os.Exit(exitStatus)
+	}
 }
 
-func run(logGroup, logStream string, src io.Reader) error {
-	sess := session.Must(session.NewSession())
-	client := cloudwatchlogs.New(sess)
-	w := writer.New(logGroup, logStream, client)
+// printVersion writes the version information injected at build time to w
+func printVersion(w io.Writer) {
+	fmt.Fprintf(w, `cwlog:
+ version     : %s
+ git hash    : %s
+ build date  : %s
+ go version  : %s
+ platform    : %s/%s
+`, version.Version, version.GitCommit, version.BuildDate, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// newClient constructs a CloudWatch Logs client configured by the command
+// line flags. Settings that aren't specified fall back to the SDK's default
+// resolution from the environment and shared config.
+func newClient() (writer.Client, error) {
+	// the SDK quietly falls back to other credentials if the profile doesn't
+	// exist, so check for it up front
+	if profile != "" {
+		if credentialsFile, configFile := sharedConfigFiles(); !profileExists(profile, credentialsFile, configFile) {
+			return nil, fmt.Errorf("profile %q not found in %s or %s", profile, credentialsFile, configFile)
+		}
+	}
+
+	cfg := writer.Config{
+		Region:               region,
+		Profile:              profile,
+		EndpointURL:          endpointURL,
+		UseFIPSEndpoint:      useFIPSEndpoint,
+		UseDualStackEndpoint: useDualStackEndpoint,
+		RoleARN:              roleARN,
+		ExternalID:           externalID,
+		HTTPClient:           newHTTPClient(httpTimeout, connectTimeout),
+	}
+	return cfg.NewClient()
+}
+
+// checkFlushInterval returns an error if d is too short to be a
+// flush-interval
+func checkFlushInterval(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("flush-interval must be positive")
+	}
+	if d < minFlushInterval {
+		return fmt.Errorf("flush-interval must be at least %v", minFlushInterval)
+	}
+	return nil
+}
+
+// checkEndpointURL returns an error if s isn't an absolute URL that requests
+// can be sent to
+func checkEndpointURL(s string) error {
+	if u, err := url.Parse(s); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("endpoint-url must be an absolute URL such as http://localhost:4566")
+	}
+	return nil
+}
+
+// newHTTPClient returns an HTTP client that gives up on a request after
+// timeout and on establishing a connection after connectTimeout. A zero value
+// leaves the corresponding default in place. If both are zero, it returns nil
+// so that the SDK's default client is used.
+func newHTTPClient(timeout, connectTimeout time.Duration) *http.Client {
+	if timeout == 0 && connectTimeout == 0 {
+		return nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if connectTimeout > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// run sends the contents of src to CloudWatch Logs. If SIGINT or SIGTERM is
+// received before src is exhausted, run stops reading, flushes any buffered
+// log events, and returns the signal. If idleTimeout is positive and no input
+// arrives for that long, run stops reading as if src had ended.
+func run(ctx context.Context, client writer.Client, logGroup, logStream string, src io.Reader, idleTimeout time.Duration, opts ...writer.Option) (os.Signal, error) {
+	w := writer.NewWithContext(ctx, logGroup, logStream, client, opts...)
+	if err := checkWriter(ctx, w); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
 
-	_, err := io.Copy(w, src)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// closeWriter enforces the shutdown timeout
+	src, dst, idle := watchIdle(ctx, src, closingWriter{w, logStream}, idleTimeout)
+	return copyLogs(dst, src, sigs, idle)
+}
+
+// runCommand runs the command described by args, sending its output to
+// CloudWatch Logs. If stderrStream is not empty, the command's standard error
+// is sent to that log stream instead of logStream. The command's output is
+// also copied to outTee and errTee, if they are not nil. It returns the
+// command's exit code.
+func runCommand(ctx context.Context, client writer.Client, logGroup, logStream, stderrStream string, args []string, outTee, errTee io.Writer, opts ...writer.Option) (int, error) {
+	w := writer.NewWithContext(ctx, logGroup, logStream, client, opts...)
+
+	errW := w
+	if stderrStream != "" {
+		errW = writer.NewWithContext(ctx, logGroup, stderrStream, client, opts...)
+	}
+
+	err := checkWriter(ctx, w)
+	if err == nil && errW != w {
+		err = checkWriter(ctx, errW)
+	}
 	if err != nil {
-		return fmt.Errorf("error writing logs: %w", err)
+		w.Close()
+		if errW != w {
+			errW.Close()
+		}
+		return 0, err
+	}
+
+	code, err := execCommand(w, errW, args, outTee, errTee)
+	if err != nil {
+		w.Close()
+		if errW != w {
+			errW.Close()
+		}
+		return 0, fmt.Errorf("error running %s: %w", args[0], err)
 	}
 
-	// flush any remaining data in the buffer
-	return w.Close()
+	if errW == w {
+		return code, closeWriter(w, logStream)
+	}
+
+	// close both writers at once so that together they stay within the
+	// shutdown timeout
+	errc := make(chan error, 1)
+	go func() {
+		errc <- closeWriter(errW, stderrStream)
+	}()
+	err = closeWriter(w, logStream)
+	if cerr := <-errc; err == nil {
+		err = cerr
+	}
+
+	return code, err
+}
+
+// checkWriter runs the writer's preflight check, if --preflight is set
+func checkWriter(ctx context.Context, w *writer.LogWriter) error {
+	if !preflight {
+		return nil
+	}
+	if err := w.Preflight(ctx); err != nil {
+		return fmt.Errorf("preflight check failed: %w", err)
+	}
+	return nil
+}
+
+// closeWriter flushes any remaining data in the writer's buffer, giving up
+// after the shutdown timeout, and warns about any log events that were left
+// undelivered or that CloudWatch Logs rejected. If --summary is set, it then
+// prints a summary of what was sent to stream.
+func closeWriter(w *writer.LogWriter, stream string) error {
+	n, err := w.CloseWithTimeout(shutdownTimeout)
+	warnRejected(w)
+	if n > 0 && deadLetterFile != "" {
+		fmt.Fprintf(os.Stderr, "warning: %d log events were not delivered; see %s\n", n, deadLetterFile)
+	} else if n > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %d log events were not delivered\n", n)
+	}
+	if summary {
+		printSummary(os.Stderr, stream, w.Stats(), w.Rejected(), time.Since(started))
+	}
+	return ignoreRejected(err)
+}
+
+// closingWriter is a LogWriter that is closed by closeWriter
+type closingWriter struct {
+	*writer.LogWriter
+	stream string
+}
+
+// Close implements io.Closer
+func (w closingWriter) Close() error {
+	return closeWriter(w.LogWriter, w.stream)
+}
+
+// printSummary prints a one-line summary of a writer's activity, so that a
+// backfill can be checked against its input
+func printSummary(out io.Writer, stream string, s writer.Stats, r writer.RejectedEvents, elapsed time.Duration) {
+	fmt.Fprintf(out, "summary: %s/%s: sent %d events (%d bytes) in %d requests, %d retries, %d dropped (%d rejected), %s\n",
+		logGroup, stream, s.SentEvents, s.SentBytes, s.SentBatches, s.RetryCount, s.DroppedEvents, r.Total(), elapsed.Round(time.Millisecond))
+}
+
+// ignoreRejected returns nil if err only reports log events rejected by
+// CloudWatch Logs, since warnRejected reports those without failing the run
+func ignoreRejected(err error) error {
+	var rerr *writer.RejectedEventsError
+	if errors.As(err, &rerr) {
+		return nil
+	}
+	return err
+}
+
+// newErrorPrinter returns an error handler that prints background flush
+// errors to w. A failed flush stops the writer, so the same error is returned
+// by every flush after it; it is only printed once. Rejected events are left
+// to warnRejected.
+func newErrorPrinter(w io.Writer) func(error) {
+	var (
+		mu   sync.Mutex
+		last string
+	)
+	return func(err error) {
+		if ignoreRejected(err) == nil {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if msg := err.Error(); msg != last {
+			fmt.Fprintf(w, "error: failed to send log events: %s\n", msg)
+			last = msg
+		}
+	}
+}
+
+// warnRejected prints a warning if CloudWatch Logs rejected any log events
+func warnRejected(w *writer.LogWriter) {
+	if r := w.Rejected(); r.Total() > 0 {
+		fmt.Fprintf(os.Stderr, "warning: CloudWatch Logs rejected %d log events (too old: %d, too new: %d, expired: %d)\n",
+			r.Total(), r.TooOld, r.TooNew, r.Expired)
+	}
+}
+
+// timestampLayouts maps the named formats accepted by --timestamp-format to
+// their time layouts
+var timestampLayouts = map[string]string{
+	"rfc3339":  time.RFC3339,
+	"syslog":   time.Stamp,
+	"datetime": "2006-01-02 15:04:05",
+}
+
+// timestampLayout returns the time layout for the named format, or format
+// itself if it isn't a known name
+func timestampLayout(format string) string {
+	if layout, ok := timestampLayouts[strings.ToLower(format)]; ok {
+		return layout
+	}
+	return format
+}
+
+// columnSeparator returns the separator named by the --field-separator value
+// s, which is tab, space, or the separator itself
+func columnSeparator(s string) string {
+	switch strings.ToLower(s) {
+	case "tab", `\t`:
+		return "\t"
+	case "space":
+		return " "
+	}
+	return s
+}
+
+// delimiterSplit returns the split function for the --delimiter value d,
+// which is newline, nul, json, or a single character
+func delimiterSplit(d string) (bufio.SplitFunc, error) {
+	switch strings.ToLower(d) {
+	case "newline":
+		return bufio.ScanLines, nil
+	case "nul":
+		return writer.ScanDelimiter(0), nil
+	case "json":
+		return writer.ScanJSON, nil
+	}
+
+	if len(d) != 1 {
+		return nil, fmt.Errorf("delimiter must be newline, nul, json, or a single character, got %q", d)
+	}
+	return writer.ScanDelimiter(d[0]), nil
+}
+
+// openInput opens the named file for reading, translating the most common
+// failures into friendlier errors. If tail is true, the returned reader
+// waits for more data at the end of the file rather than returning io.EOF.
+func openInput(ctx context.Context, name string, tail bool) (io.ReadCloser, error) {
+	var (
+		f   io.ReadCloser
+		err error
+	)
+	if tail {
+		f, err = follow.NewReader(ctx, name)
+	} else {
+		f, err = os.Open(name)
+	}
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("input-file %s does not exist", name)
+	case errors.Is(err, os.ErrPermission):
+		return nil, fmt.Errorf("input-file %s is not readable: permission denied", name)
+	case err != nil:
+		return nil, fmt.Errorf("unable to open input-file %s: %v", name, err)
+	}
+	return f, nil
+}
+
+// openTee returns where output is copied, as chosen by the tee, quiet, and
+// tee-to flags. quiet takes precedence over tee, so if it is set, or tee is
+// not, out and errOut are nil. If dest is a file, it is opened and returned
+// as file so the caller can close it.
+func openTee(tee, quiet bool, dest string, stdout, stderr io.Writer) (out, errOut io.Writer, file *os.File, err error) {
+	if !tee || quiet {
+		return nil, nil, nil, nil
+	}
+
+	switch dest {
+	case "stdout":
+		return stdout, stderr, nil, nil
+	case "stderr":
+		return stderr, stderr, nil, nil
+	}
+
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to open tee-to file: %v", err)
+	}
+	return f, f, f, nil
 }
 
-func getSource(tee bool) io.Reader {
-	if tee {
-		return io.TeeReader(os.Stdin, os.Stdout)
+// getSource returns a reader for src that also copies everything read to
+// teeTo, if it is not nil
+func getSource(src io.Reader, teeTo io.Writer) io.Reader {
+	if teeTo != nil {
+		return io.TeeReader(src, teeTo)
 	}
-	return os.Stdin
+	return src
 }
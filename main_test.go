@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/kylemcc/cwlog/writer"
+	"github.com/kylemcc/cwlog/writer/cwlogtest"
+)
+
+func TestGetSource(t *testing.T) {
+	input := "line 1\r\nline 2\n\n\x00binary\xff\nno trailing newline"
+
+	var teed bytes.Buffer
+	got, err := ioutil.ReadAll(getSource(strings.NewReader(input), &teed))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if string(got) != input {
+		t.Errorf("source did not match the input: got=%q want=%q", got, input)
+	}
+	if teed.String() != input {
+		t.Errorf("teed bytes did not match the input: got=%q want=%q", teed.String(), input)
+	}
+}
+
+func TestGetSourceNoTee(t *testing.T) {
+	src := strings.NewReader("input")
+	if r := getSource(src, nil); r != io.Reader(src) {
+		t.Errorf("expected the source to be returned unchanged")
+	}
+}
+
+func TestOpenTeeQuiet(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	for _, tee := range []bool{true, false} {
+		out, errOut, f, err := openTee(tee, true, "stdout", &stdout, &stderr)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if out != nil || errOut != nil || f != nil {
+			t.Fatalf("tee=%v: expected no tee target under quiet", tee)
+		}
+
+		if _, err := ioutil.ReadAll(getSource(strings.NewReader("line 1\nline 2\n"), out)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if stdout.Len() > 0 || stderr.Len() > 0 {
+		t.Errorf("output was written under quiet: stdout=%q stderr=%q", stdout.String(), stderr.String())
+	}
+
+	out, errOut, _, err := openTee(true, false, "stdout", &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != &stdout || errOut != &stderr {
+		t.Errorf("expected output to be copied to stdout and stderr without quiet")
+	}
+}
+
+func TestErrorPrinter(t *testing.T) {
+	var b bytes.Buffer
+	h := newErrorPrinter(&b)
+
+	h(errors.New("access denied"))
+	h(errors.New("access denied"))
+	h(&writer.RejectedEventsError{})
+	h(errors.New("throttled"))
+
+	expected := "error: failed to send log events: access denied\nerror: failed to send log events: throttled\n"
+	if b.String() != expected {
+		t.Errorf("unexpected output: got=%q want=%q", b.String(), expected)
+	}
+}
+
+func TestCheckFlushInterval(t *testing.T) {
+	cases := []struct {
+		name     string
+		interval time.Duration
+		ok       bool
+	}{
+		{"zero", 0, false},
+		{"negative", -time.Second, false},
+		{"too small", time.Millisecond, false},
+		{"just under the minimum", minFlushInterval - 1, false},
+		{"minimum", minFlushInterval, true},
+		{"default", 2 * time.Second, true},
+		{"long", time.Minute, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := checkFlushInterval(c.interval); (err == nil) != c.ok {
+				t.Errorf("unexpected result: err=%v want ok=%v", err, c.ok)
+			}
+		})
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	if c := newHTTPClient(0, 0); c != nil {
+		t.Errorf("expected the SDK default client when no timeouts are set")
+	}
+
+	c := newHTTPClient(30*time.Second, 0)
+	if c.Timeout != 30*time.Second {
+		t.Errorf("unexpected timeout: %v", c.Timeout)
+	}
+
+	c = newHTTPClient(0, 50*time.Millisecond)
+	if c.Timeout != 0 {
+		t.Errorf("unexpected timeout: %v", c.Timeout)
+	}
+
+	transport, ok := c.Transport.(*http.Transport)
+	if !ok || transport == http.DefaultTransport || transport.DialContext == nil {
+		t.Errorf("connect timeout was not applied to the transport")
+	}
+}
+
+// setenv sets the environment variable key to value, or unsets it if value
+// is empty, for the duration of the test
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+}
+
+// isolateAWSConfig hides the shared config and credentials files and the
+// environment variables that select a profile or region from the SDK
+func isolateAWSConfig(t *testing.T) {
+	setenv(t, "AWS_CONFIG_FILE", "/nonexistent/config")
+	setenv(t, "AWS_SHARED_CREDENTIALS_FILE", "/nonexistent/credentials")
+	for _, key := range []string{"AWS_PROFILE", "AWS_DEFAULT_PROFILE", "AWS_REGION", "AWS_DEFAULT_REGION", "AWS_SDK_LOAD_CONFIG"} {
+		setenv(t, key, "")
+	}
+}
+
+func TestNewClientRegion(t *testing.T) {
+	isolateAWSConfig(t)
+	setenv(t, "AWS_REGION", "eu-west-1")
+	defer func() { region = "" }()
+
+	cases := []struct {
+		flag     string
+		expected string
+	}{
+		{flag: "us-west-2", expected: "us-west-2"},
+		{flag: "", expected: "eu-west-1"},
+	}
+
+	for _, c := range cases {
+		region = c.flag
+		client, err := newClient()
+		if err != nil {
+			t.Fatalf("region=%q: unexpected error: %v", c.flag, err)
+		}
+
+		// the flag takes precedence over AWS_REGION, which is used otherwise
+		if got := aws.StringValue(client.(*cloudwatchlogs.CloudWatchLogs).Config.Region); got != c.expected {
+			t.Errorf("region=%q: unexpected region: got=%q want=%q", c.flag, got, c.expected)
+		}
+	}
+}
+
+func TestNewClientEndpointURL(t *testing.T) {
+	isolateAWSConfig(t)
+	region, endpointURL = "us-east-1", "http://localhost:4566"
+	defer func() { region, endpointURL = "", "" }()
+
+	client, err := newClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := client.(*cloudwatchlogs.CloudWatchLogs).Endpoint; got != endpointURL {
+		t.Errorf("unexpected endpoint: got=%q want=%q", got, endpointURL)
+	}
+}
+
+func TestCheckEndpointURL(t *testing.T) {
+	cases := []struct {
+		url string
+		ok  bool
+	}{
+		{"http://localhost:4566", true},
+		{"https://vpce-1234.logs.us-east-1.vpce.amazonaws.com", true},
+		{"localhost:4566", false},
+		{"/logs", false},
+		{"http://", false},
+		{"http://local host", false},
+	}
+
+	for _, c := range cases {
+		if err := checkEndpointURL(c.url); (err == nil) != c.ok {
+			t.Errorf("%q: unexpected result: err=%v want ok=%v", c.url, err, c.ok)
+		}
+	}
+}
+
+// assumeRoleResponse is the STS response granting the assumed role's
+// credentials
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAROLE</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>2099-01-01T00:00:00Z</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/logs/cwlog</Arn>
+      <AssumedRoleId>AROA:cwlog</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+
+func TestNewClientRoleARN(t *testing.T) {
+	isolateAWSConfig(t)
+	setenv(t, "AWS_ACCESS_KEY_ID", "AKIABASE")
+	setenv(t, "AWS_SECRET_ACCESS_KEY", "secret")
+
+	var (
+		mu          sync.Mutex
+		roles       []string
+		externalIDs []string
+		keys        []string
+		denied      bool
+	)
+
+	// STS and CloudWatch Logs share the endpoint, and are told apart by
+	// their protocols
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Header.Get("X-Amz-Target") == "" {
+			r.ParseForm()
+			roles = append(roles, r.Form.Get("RoleArn"))
+			externalIDs = append(externalIDs, r.Form.Get("ExternalId"))
+			if denied {
+				rw.WriteHeader(http.StatusForbidden)
+				rw.Write([]byte(`<ErrorResponse><Error><Code>AccessDenied</Code><Message>denied</Message></Error></ErrorResponse>`))
+				return
+			}
+			rw.Write([]byte(assumeRoleResponse))
+			return
+		}
+
+		// the request is signed with the assumed role's credentials
+		auth := r.Header.Get("Authorization")
+		keys = append(keys, strings.SplitN(strings.SplitN(auth, "Credential=", 2)[1], "/", 2)[0])
+		rw.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	region, endpointURL = "us-east-1", srv.URL
+	roleARN, externalID = "arn:aws:iam::123456789012:role/logs", "shared-secret"
+	defer func() { region, endpointURL, roleARN, externalID = "", "", "", "" }()
+
+	client, err := newClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w := writer.New("group", "stream", client)
+	if err := w.WriteEvent("event", time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	if len(roles) != 1 || roles[0] != roleARN || externalIDs[0] != externalID {
+		t.Errorf("unexpected AssumeRole requests: roles=%q external IDs=%q", roles, externalIDs)
+	}
+	if len(keys) != 1 || keys[0] != "ASIAROLE" {
+		t.Errorf("requests were not signed with the role's credentials: %q", keys)
+	}
+	denied = true
+	mu.Unlock()
+
+	// a role that can't be assumed is reported before anything is sent
+	if _, err := newClient(); err == nil || !strings.Contains(err.Error(), "unable to assume role") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// failingWriter fails every write with err
+type failingWriter struct {
+	err error
+}
+
+func (f failingWriter) Write([]byte) (int, error) {
+	return 0, f.err
+}
+
+func TestCopyLinesWriteError(t *testing.T) {
+	errFail := errors.New("delivery failed")
+
+	var (
+		mu    sync.Mutex
+		teed  bytes.Buffer
+		input = "line 1\nline 2\nline 3\n"
+	)
+	err := copyLines(failingWriter{errFail}, strings.NewReader(input), &teed, &mu)
+	if err != errFail {
+		t.Errorf("unexpected error: got=%v want=%v", err, errFail)
+	}
+
+	// the source is still drained so that a command writing to it isn't blocked
+	if teed.String() != input {
+		t.Errorf("unexpected teed output: got=%q want=%q", teed.String(), input)
+	}
+}
+
+func TestRunPreflight(t *testing.T) {
+	preflight = true
+	defer func() { preflight = false }()
+
+	client := cwlogtest.NewClient()
+	client.NoStream = true
+
+	src := strings.NewReader("line\n")
+	_, err := run(context.Background(), client, "group", "stream", src, 0, writer.WithAutoCreate(false))
+	if !errors.Is(err, writer.ErrLogStreamNotFound) {
+		t.Errorf("unexpected error: got=%v want=%v", err, writer.ErrLogStreamNotFound)
+	}
+	if src.Len() == 0 || len(client.Tokens) > 0 {
+		t.Errorf("input was read before the preflight check failed")
+	}
+}
+
+func TestRunSummary(t *testing.T) {
+	summary, logGroup = true, "group"
+	defer func() { summary, logGroup = false, "" }()
+
+	// capture the summary and warnings printed to stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	// the first event of each batch is rejected
+	client := cwlogtest.NewClient()
+	client.Rejected = &cloudwatchlogs.RejectedLogEventsInfo{TooOldLogEventEndIndex: aws.Int64(1)}
+
+	src := strings.NewReader("a\nbb\nccc\n")
+	_, err = run(context.Background(), client, "group", "stream", src, 0, writer.WithMaxBatchEvents(2))
+	os.Stderr = stderr
+	w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out, _ := ioutil.ReadAll(r)
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	expected := "summary: group/stream: sent 1 events (84 bytes) in 2 requests, 0 retries, 2 dropped (2 rejected), "
+	if last := lines[len(lines)-1]; !strings.HasPrefix(last, expected) {
+		t.Errorf("unexpected summary: got=%q want prefix %q", last, expected)
+	}
+}
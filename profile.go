@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sharedConfigFiles returns the paths of the shared credentials and config
+// files, following the same environment variables as the SDK
+func sharedConfigFiles() (credentials, config string) {
+	home, _ := os.UserHomeDir()
+
+	credentials = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if credentials == "" {
+		credentials = filepath.Join(home, ".aws", "credentials")
+	}
+	config = os.Getenv("AWS_CONFIG_FILE")
+	if config == "" {
+		config = filepath.Join(home, ".aws", "config")
+	}
+	return credentials, config
+}
+
+// profileExists reports whether profile is defined in the shared credentials
+// file or the shared config file. In the config file, profiles other than
+// default are named [profile name].
+func profileExists(profile, credentialsFile, configFile string) bool {
+	if hasSection(credentialsFile, profile) {
+		return true
+	}
+	if profile == "default" {
+		return hasSection(configFile, profile)
+	}
+	return hasSection(configFile, "profile "+profile)
+}
+
+// hasSection reports whether the INI file at path has a section named name.
+// A file that can't be read has no sections.
+func hasSection(path, name string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if !strings.HasPrefix(line, "[") {
+			continue
+		}
+
+		end := strings.IndexByte(line, ']')
+		if end < 0 {
+			continue
+		}
+		if strings.Join(strings.Fields(line[1:end]), " ") == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfileExists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cwlog")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	credentials := filepath.Join(dir, "credentials")
+	config := filepath.Join(dir, "config")
+
+	if err := ioutil.WriteFile(credentials, []byte("[default]\naws_access_key_id = AKIA\n\n[ dev ]\naws_access_key_id = AKIA\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(config, []byte("[profile  prod]\nregion = us-east-1\n[staging]\nregion = us-east-1\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		profile string
+		exists  bool
+	}{
+		{"default", true},
+		{"dev", true},
+		{"prod", true},
+		{"staging", false},
+		{"missing", false},
+	}
+
+	for _, c := range cases {
+		if got := profileExists(c.profile, credentials, config); got != c.exists {
+			t.Errorf("%s: got=%v want=%v", c.profile, got, c.exists)
+		}
+	}
+
+	if profileExists("default", filepath.Join(dir, "nope"), filepath.Join(dir, "nope")) {
+		t.Errorf("a profile was found in missing files")
+	}
+}
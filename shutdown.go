@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// copyLogs copies src to w until src is exhausted, a signal is received on
+// sigs, or idle is closed, then closes w to flush any buffered log events. w
+// is responsible for bounding how long closing takes.
+//
+// If copying was interrupted by a signal, that signal is returned.
+func copyLogs(w io.WriteCloser, src io.Reader, sigs <-chan os.Signal, idle <-chan struct{}) (os.Signal, error) {
+	copied := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(w, src)
+		copied <- err
+	}()
+
+	var sig os.Signal
+	select {
+	case err := <-copied:
+		if err != nil {
+			w.Close()
+			return nil, fmt.Errorf("error writing logs: %w", err)
+		}
+	case sig = <-sigs:
+		// stop reading input. The copy may be blocked reading src, so it is
+		// abandoned rather than waited for; once w is closed, any further
+		// writes fail and the copy ends
+	case <-idle:
+		// the input has gone quiet. Stop reading it as if it had ended
+	}
+
+	return sig, w.Close()
+}
+
+// signalExitCode returns the exit code for a process terminated by sig, which
+// by convention is 128 plus the signal number
+func signalExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeWriter records writes and whether it was closed. Close blocks for
+// closeDelay to simulate a slow flush.
+type fakeWriter struct {
+	sync.Mutex
+	buf        bytes.Buffer
+	closed     bool
+	closeDelay time.Duration
+}
+
+func (f *fakeWriter) Write(b []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.buf.Write(b)
+}
+
+func (f *fakeWriter) Close() error {
+	time.Sleep(f.closeDelay)
+
+	f.Lock()
+	defer f.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeWriter) String() string {
+	f.Lock()
+	defer f.Unlock()
+	return f.buf.String()
+}
+
+func TestCopyLogsSignal(t *testing.T) {
+	// a source that never reaches EOF, like an idle stdin
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	w := &fakeWriter{}
+	sigs := make(chan os.Signal, 1)
+
+	go func() {
+		pw.Write([]byte("test input\n"))
+		for w.String() == "" {
+			time.Sleep(time.Millisecond)
+		}
+		sigs <- syscall.SIGTERM
+	}()
+
+	sig, err := copyLogs(w, pr, sigs, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sig != syscall.SIGTERM {
+		t.Errorf("unexpected signal: got=%v want=%v", sig, syscall.SIGTERM)
+	}
+	if !w.closed {
+		t.Error("writer was not closed")
+	}
+	if got := w.String(); got != "test input\n" {
+		t.Errorf("unexpected output: got=%q want=%q", got, "test input\n")
+	}
+	if code := signalExitCode(sig); code != 143 {
+		t.Errorf("unexpected exit code: got=%d want=143", code)
+	}
+}
+
+func TestCopyLogsEOF(t *testing.T) {
+	w := &fakeWriter{}
+	sig, err := copyLogs(w, bytes.NewBufferString("a\nb\n"), make(chan os.Signal), nil)
+	if err != nil || sig != nil {
+		t.Fatalf("unexpected result: sig=%v err=%v", sig, err)
+	}
+	if !w.closed || w.String() != "a\nb\n" {
+		t.Errorf("unexpected writer state: closed=%v output=%q", w.closed, w.String())
+	}
+}
+
+func TestCopyLogsWaitsForClose(t *testing.T) {
+	w := &fakeWriter{closeDelay: 50 * time.Millisecond}
+	if _, err := copyLogs(w, bytes.NewBufferString("a\n"), make(chan os.Signal), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !w.closed {
+		t.Error("copyLogs returned before the writer was closed")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kylemcc/cwlog/writer"
+)
+
+// dateLayout is the time layout used to expand the {date} placeholder in log
+// stream names
+const dateLayout = "2006-01-02"
+
+// expandStreamName replaces placeholders in a log stream name template and
+// validates the result. The supported placeholders are:
+//
+//	{date}      the current UTC date, formatted as 2006-01-02
+//	{hostname}  the hostname of this machine
+//	{pid}       the process ID of cwlog
+//
+// Literal braces may be written as {{ and }}.
+func expandStreamName(tmpl string) (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("unable to determine hostname: %v", err)
+	}
+
+	return expandStreamNameWith(tmpl, map[string]string{
+		"date":     time.Now().UTC().Format(dateLayout),
+		"hostname": hostname,
+		"pid":      strconv.Itoa(os.Getpid()),
+	})
+}
+
+// expandStreamNameWith replaces placeholders in tmpl with the given values
+// and validates the result
+func expandStreamNameWith(tmpl string, values map[string]string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+		c := tmpl[i]
+		switch {
+		case c == '{' && strings.HasPrefix(tmpl[i:], "{{"):
+			b.WriteByte('{')
+			i++
+		case c == '}' && strings.HasPrefix(tmpl[i:], "}}"):
+			b.WriteByte('}')
+			i++
+		case c == '{':
+			end := strings.IndexByte(tmpl[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("log stream name %q has an unterminated placeholder", tmpl)
+			}
+
+			name := tmpl[i+1 : i+end]
+			v, ok := values[name]
+			if !ok {
+				return "", fmt.Errorf("log stream name %q has an unknown placeholder {%s}", tmpl, name)
+			}
+			b.WriteString(v)
+			i += end
+		case c == '}':
+			return "", fmt.Errorf("log stream name %q has an unmatched }; use }} for a literal brace", tmpl)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	name := b.String()
+	if err := writer.ValidateLogStreamName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
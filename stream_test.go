@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandStreamName(t *testing.T) {
+	values := map[string]string{
+		"date":     "2020-06-01",
+		"hostname": "web-3",
+		"pid":      "1234",
+	}
+
+	cases := []struct {
+		tmpl     string
+		expected string
+		err      bool
+	}{
+		{"app", "app", false},
+		{"app-{date}", "app-2020-06-01", false},
+		{"app-{hostname}", "app-web-3", false},
+		{"app-{pid}", "app-1234", false},
+		{"app-{date}-{hostname}-{pid}", "app-2020-06-01-web-3-1234", false},
+		{"{{literal}}-{pid}", "{literal}-1234", false},
+		{"app-{unknown}", "", true},
+		{"app-{date", "", true},
+		{"app}", "", true},
+		{"app:{pid}", "", true},
+		{"app*", "", true},
+		{"", "", true},
+		{strings.Repeat("a", 513), "", true},
+	}
+
+	for _, c := range cases {
+		got, err := expandStreamNameWith(c.tmpl, values)
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %q", c.tmpl, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.tmpl, err)
+		} else if got != c.expected {
+			t.Errorf("%q: got=%q want=%q", c.tmpl, got, c.expected)
+		}
+	}
+}
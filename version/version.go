@@ -6,4 +6,7 @@ var (
 
 	//GitCommit is the commit hash from which the binary was built
 	GitCommit = "unknown"
+
+	// BuildDate is the UTC time at which the binary was built
+	BuildDate = "unknown"
 )
@@ -0,0 +1,46 @@
+package writer
+
+import "strings"
+
+// stripANSI removes ANSI CSI escape sequences, such as the SGR sequences used
+// for colors, from text. Anything that isn't a complete CSI sequence,
+// including a lone ESC, is left as is.
+func stripANSI(text string) string {
+	if strings.IndexByte(text, '\x1b') < 0 {
+		return text
+	}
+
+	var b strings.Builder
+	b.Grow(len(text))
+	for i := 0; i < len(text); {
+		if n := csiLen(text[i:]); n > 0 {
+			i += n
+			continue
+		}
+		b.WriteByte(text[i])
+		i++
+	}
+	return b.String()
+}
+
+// csiLen returns the length of the CSI sequence at the start of s, or 0 if s
+// doesn't begin with one. A sequence is ESC [ followed by any parameter bytes
+// (0x30-0x3F), then any intermediate bytes (0x20-0x2F), then a final byte
+// (0x40-0x7E).
+func csiLen(s string) int {
+	if len(s) < 3 || s[0] != '\x1b' || s[1] != '[' {
+		return 0
+	}
+
+	i := 2
+	for i < len(s) && s[i] >= 0x30 && s[i] <= 0x3f {
+		i++
+	}
+	for i < len(s) && s[i] >= 0x20 && s[i] <= 0x2f {
+		i++
+	}
+	if i < len(s) && s[i] >= 0x40 && s[i] <= 0x7e {
+		return i + 1
+	}
+	return 0
+}
@@ -0,0 +1,30 @@
+package writer
+
+import "testing"
+
+func TestStripANSI(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"plain", "no escapes here", "no escapes here"},
+		{"color", "\x1b[31merror\x1b[0m: failed", "error: failed"},
+		{"bold and 256 colors", "\x1b[1;38;5;208mwarn\x1b[m done", "warn done"},
+		{"cursor movement", "progress\x1b[2K\x1b[1G100%", "progress100%"},
+		{"only escapes", "\x1b[0m\x1b[K", ""},
+		{"lone escape", "a\x1bb", "a\x1bb"},
+		{"other escape", "\x1b(Bkeep", "\x1b(Bkeep"},
+		{"incomplete", "trailing \x1b[31", "trailing \x1b[31"},
+		{"invalid final byte", "\x1b[31\x07x", "\x1b[31\x07x"},
+		{"multibyte", "\x1b[32mé✓\x1b[0m", "é✓"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := stripANSI(c.input); got != c.expected {
+				t.Errorf("unexpected result: got=%q want=%q", got, c.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package writer
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a flush that wasn't attempted because the
+// circuit breaker set by WithCircuitBreaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// breaker is a circuit breaker for flushes. After threshold consecutive
+// failures it opens, and flushes fail without being attempted until cooldown
+// has elapsed. Then a single flush is let through to probe CloudWatch Logs:
+// if it succeeds the breaker closes, and if it fails the breaker opens again.
+// The caller must hold the writer's lock.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	// failures is the number of consecutive failed flushes
+	failures int
+
+	// openedAt is the time at which the breaker last opened
+	openedAt time.Time
+
+	// probing is set while the flush probing a half-open breaker is in
+	// progress
+	probing bool
+
+	// now is a field so tests can substitute a fake clock
+	now func() time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// open reports whether a flush should fail without being attempted. If it
+// returns false for a half-open breaker, the caller's flush is the probe.
+func (b *breaker) open() bool {
+	if b == nil || b.failures < b.threshold {
+		return false
+	}
+	if b.probing || b.now().Sub(b.openedAt) < b.cooldown {
+		return true
+	}
+
+	b.probing = true
+	return false
+}
+
+// record records the result of a flush that open let through. It returns
+// true if the result opened or closed the breaker.
+func (b *breaker) record(err error) bool {
+	if b == nil {
+		return false
+	}
+
+	b.probing = false
+	if err == nil {
+		changed := b.failures >= b.threshold
+		b.failures = 0
+		return changed
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = b.now()
+		return b.failures == b.threshold
+	}
+	return false
+}
+
+// abandon records that a flush let through by open was given up by its
+// caller without a result, so that another flush may probe in its place
+func (b *breaker) abandon() {
+	if b != nil {
+		b.probing = false
+	}
+}
@@ -0,0 +1,101 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWriterCircuitBreaker(t *testing.T) {
+	now = mockNow()
+
+	// CloudWatch Logs is unreachable until down is cleared
+	var down int32 = 1
+	errDown := errors.New("network down")
+	logsClient := newLogsCLientTest()
+	logsClient.PutHook = func(context.Context) error {
+		if atomic.LoadInt32(&down) == 1 {
+			return errDown
+		}
+		return nil
+	}
+
+	var handled int
+	w := New("group", "stream", logsClient,
+		WithFlushInterval(time.Hour),
+		WithMaxRetries(2),
+		WithRequestRate(0),
+		WithCircuitBreaker(3, time.Minute),
+		WithErrorHandler(func(error) { handled++ }),
+	)
+	w.backoff.sleep = func(context.Context, time.Duration) error { return nil }
+
+	clock := time.Unix(0, 0)
+	w.breaker.now = func() time.Time { return clock }
+
+	// each failure costs a flush its retries until the breaker opens
+	for i := 0; i < 3; i++ {
+		w.appendEvent("lost")
+		if err := w.Flush(); err != errDown {
+			t.Fatalf("flush %d: unexpected error: got=%v want=%v", i, err, errDown)
+		}
+	}
+	if calls := len(logsClient.Tokens); calls != 6 {
+		t.Fatalf("unexpected number of PutLogEvents calls: %d", calls)
+	}
+
+	// while open, flushes fail without a request, and events are buffered
+	w.appendEvent("buffered 1")
+	w.appendEvent("buffered 2")
+	if err := w.Flush(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("unexpected error: got=%v want=%v", err, ErrCircuitOpen)
+	}
+	w.backgroundFlush()
+	if calls := len(logsClient.Tokens); calls != 6 {
+		t.Errorf("flushes were attempted while the breaker was open: %d calls", calls)
+	}
+	if handled != 0 {
+		t.Errorf("an open breaker was reported to the error handler %d times", handled)
+	}
+	if _, err := w.Write([]byte("written\n")); err != nil {
+		t.Errorf("unexpected error writing while the breaker was open: %v", err)
+	}
+
+	// after the cooldown, a failed probe opens the breaker again
+	clock = clock.Add(time.Minute)
+	if err := w.Flush(); err != errDown {
+		t.Fatalf("unexpected error: got=%v want=%v", err, errDown)
+	}
+	if calls := len(logsClient.Tokens); calls != 8 {
+		t.Errorf("the breaker was not probed: %d calls", calls)
+	}
+	w.appendEvent("buffered 3")
+	if err := w.Flush(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("unexpected error: got=%v want=%v", err, ErrCircuitOpen)
+	}
+
+	// once CloudWatch Logs is back, a successful probe closes the breaker
+	atomic.StoreInt32(&down, 0)
+	clock = clock.Add(time.Minute)
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.appendEvent("sent")
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// events buffered after the failed probe are delivered
+	delivered := make(map[string]bool)
+	for _, m := range logsClient.Messages() {
+		delivered[m] = true
+	}
+	if !delivered["buffered 3"] || !delivered["sent"] || delivered["lost"] {
+		t.Errorf("unexpected messages: %q", logsClient.Messages())
+	}
+}
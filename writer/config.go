@@ -0,0 +1,150 @@
+package writer
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// Config describes how to connect to CloudWatch Logs, mirroring cwlog's
+// command line flags. Fields that are left empty fall back to the SDK's
+// default resolution from the environment and shared config.
+type Config struct {
+	// Region is the AWS region to send logs to
+	Region string
+
+	// Profile is the profile to use from the shared credentials and config
+	// files, as if AWS_PROFILE were set
+	Profile string
+
+	// EndpointURL, if set, is used instead of the default CloudWatch Logs
+	// endpoint, e.g. http://localhost:4566 for LocalStack
+	EndpointURL string
+
+	// UseFIPSEndpoint and UseDualStackEndpoint select the FIPS and dualstack
+	// variants of the default endpoint. NewClient fails if the region has no
+	// such endpoint.
+	UseFIPSEndpoint      bool
+	UseDualStackEndpoint bool
+
+	// RoleARN, if set, is an IAM role to assume before sending logs.
+	// ExternalID is passed when assuming it.
+	RoleARN    string
+	ExternalID string
+
+	// Credentials, if set, are used instead of the SDK's default credential
+	// chain and Profile
+	Credentials *credentials.Credentials
+
+	// HTTPClient, if set, is used to make requests instead of the SDK's
+	// default client, e.g. to set timeouts
+	HTTPClient *http.Client
+}
+
+// NewFromConfig constructs a LogWriter with a CloudWatch Logs client built
+// from cfg. Use New to supply a client of your own.
+func NewFromConfig(logGroup, logStream string, cfg Config, opts ...Option) (*LogWriter, error) {
+	client, err := cfg.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return New(logGroup, logStream, client, opts...), nil
+}
+
+// NewClient constructs a CloudWatch Logs client from c. If RoleARN is set,
+// the role is assumed up front so that a failure is reported here rather
+// than by the first flush.
+func (c Config) NewClient() (Client, error) {
+	cfg := aws.NewConfig()
+	if c.Region != "" {
+		cfg = cfg.WithRegion(c.Region)
+	}
+	if c.EndpointURL != "" {
+		cfg = cfg.WithEndpoint(c.EndpointURL)
+	}
+	if c.HTTPClient != nil {
+		cfg = cfg.WithHTTPClient(c.HTTPClient)
+	}
+	if c.Credentials != nil {
+		cfg = cfg.WithCredentials(c.Credentials)
+	}
+	if c.UseFIPSEndpoint {
+		cfg.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+	}
+	if c.UseDualStackEndpoint {
+		cfg.UseDualStackEndpoint = endpoints.DualStackEndpointStateEnabled
+	}
+
+	opts := session.Options{Config: *cfg}
+	if c.Profile != "" {
+		opts.Profile = c.Profile
+		opts.SharedConfigState = session.SharedConfigEnable
+	}
+
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// a custom endpoint is used as given, so only the SDK's own endpoints
+	// need checking
+	if c.EndpointURL == "" {
+		if err := checkEndpoint(aws.StringValue(sess.Config.Region), c.UseFIPSEndpoint, c.UseDualStackEndpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.RoleARN != "" {
+		creds := stscreds.NewCredentials(sess, c.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			if c.ExternalID != "" {
+				p.ExternalID = aws.String(c.ExternalID)
+			}
+		})
+
+		if _, err := creds.Get(); err != nil {
+			return nil, fmt.Errorf("unable to assume role %s: %v", c.RoleARN, err)
+		}
+
+		return cloudwatchlogs.New(sess, aws.NewConfig().WithCredentials(creds)), nil
+	}
+
+	return cloudwatchlogs.New(sess), nil
+}
+
+// checkEndpoint returns an error if CloudWatch Logs has no endpoint in region
+// with the requested FIPS and dualstack variants, so that an unsupported
+// combination is reported up front rather than by the first flush
+func checkEndpoint(region string, fips, dualStack bool) error {
+	if !fips && !dualStack {
+		return nil
+	}
+
+	var variant []string
+	if fips {
+		variant = append(variant, "FIPS")
+	}
+	if dualStack {
+		variant = append(variant, "dualstack")
+	}
+
+	_, err := endpoints.DefaultResolver().EndpointFor(cloudwatchlogs.EndpointsID, region, func(o *endpoints.Options) {
+		if fips {
+			o.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+		}
+		if dualStack {
+			o.UseDualStackEndpoint = endpoints.DualStackEndpointStateEnabled
+		}
+		o.StrictMatching = true
+	})
+	if err != nil {
+		return fmt.Errorf("CloudWatch Logs has no %s endpoint in region %q", strings.Join(variant, " "), region)
+	}
+	return nil
+}
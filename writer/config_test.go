@@ -0,0 +1,108 @@
+package writer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+func TestNewFromConfig(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		messages []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		target := r.Header.Get("X-Amz-Target")
+		if !strings.HasSuffix(target, ".PutLogEvents") {
+			rw.Write([]byte("{}"))
+			return
+		}
+
+		var input struct {
+			LogGroupName  string `json:"logGroupName"`
+			LogStreamName string `json:"logStreamName"`
+			LogEvents     []struct {
+				Message string `json:"message"`
+			} `json:"logEvents"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		if input.LogGroupName != "group" || input.LogStreamName != "stream" {
+			t.Errorf("unexpected destination: %s/%s", input.LogGroupName, input.LogStreamName)
+		}
+
+		mu.Lock()
+		for _, e := range input.LogEvents {
+			messages = append(messages, e.Message)
+		}
+		mu.Unlock()
+		rw.Write([]byte(`{"nextSequenceToken":"1"}`))
+	}))
+	defer srv.Close()
+
+	w, err := NewFromConfig("group", "stream", Config{
+		Region:      "us-east-1",
+		EndpointURL: srv.URL,
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := w.Write([]byte("line 1\nline 2\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if expected := []string{"line 1", "line 2"}; !reflect.DeepEqual(messages, expected) {
+		t.Errorf("unexpected messages: got=%q want=%q", messages, expected)
+	}
+}
+
+func TestNewFromConfigUnsupportedEndpoint(t *testing.T) {
+	_, err := NewFromConfig("group", "stream", Config{
+		Region:          "eu-west-1",
+		UseFIPSEndpoint: true,
+		Credentials:     credentials.NewStaticCredentials("AKID", "SECRET", ""),
+	})
+	if err == nil {
+		t.Error("expected an error for a region without a FIPS endpoint")
+	}
+}
+
+func TestCheckEndpoint(t *testing.T) {
+	cases := []struct {
+		region    string
+		fips      bool
+		dualStack bool
+		ok        bool
+	}{
+		{"eu-west-1", false, false, true},
+		{"us-east-1", true, false, true},
+		{"us-gov-west-1", true, false, true},
+		{"eu-west-1", true, false, false},
+		{"eu-west-1", false, true, true},
+		{"cn-north-1", false, true, false},
+		{"us-east-1", true, true, false},
+	}
+
+	for _, c := range cases {
+		err := checkEndpoint(c.region, c.fips, c.dualStack)
+		if c.ok && err != nil {
+			t.Errorf("%s fips=%v dualstack=%v: unexpected error: %v", c.region, c.fips, c.dualStack, err)
+		} else if !c.ok && err == nil {
+			t.Errorf("%s fips=%v dualstack=%v: expected an error", c.region, c.fips, c.dualStack)
+		}
+	}
+}
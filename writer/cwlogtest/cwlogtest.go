@@ -0,0 +1,279 @@
+// Package cwlogtest provides a fake CloudWatch Logs client and input helpers
+// for testing code built on the writer package
+package cwlogtest
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+)
+
+// Client is a fake CloudWatch Logs client that records the log events it
+// receives. It implements the operations used by a LogWriter; calling any
+// other operation panics. The zero value accepts every request.
+//
+// Fields configuring the client should be set before it is used. The
+// recorded requests may be read once the writer using the client is closed,
+// or at any time through the methods that return copies of them.
+type Client struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	sync.Mutex
+
+	// Events holds copies of the events received by successful PutLogEvents
+	// calls, in the order they were received
+	Events []*cloudwatchlogs.InputLogEvent
+
+	// Batches holds the number of events in each successful PutLogEvents call
+	Batches []int
+
+	// Tokens holds the sequence token sent with each PutLogEvents call,
+	// including calls that failed
+	Tokens []*string
+
+	// Rejected, if set, is returned as the RejectedLogEventsInfo of every
+	// successful PutLogEvents call
+	Rejected *cloudwatchlogs.RejectedLogEventsInfo
+
+	// NilToken causes PutLogEvents to return no NextSequenceToken
+	NilToken bool
+
+	// PutHook, if set, is called at the start of each PutLogEvents call. If
+	// it returns an error, the call fails with that error. It may block, e.g.
+	// until ctx is done.
+	PutHook func(ctx context.Context) error
+
+	// NoGroup and NoStream simulate a missing log group and log stream. They
+	// are cleared when the resource is created
+	NoGroup  bool
+	NoStream bool
+
+	// AbortCreates is the number of create calls that fail with
+	// OperationAbortedException, as if another writer were creating the
+	// same resource. The resource is created anyway
+	AbortCreates int
+
+	// DescribeErr, if set, is returned by every DescribeLogStreams call
+	DescribeErr error
+
+	// CreatedGroups, CreatedStreams, and Retention record the inputs of each
+	// call to create a log group or stream or set a retention policy
+	CreatedGroups  []*cloudwatchlogs.CreateLogGroupInput
+	CreatedStreams []*cloudwatchlogs.CreateLogStreamInput
+	Retention      []*cloudwatchlogs.PutRetentionPolicyInput
+
+	seq   int
+	calls int
+}
+
+// NewClient returns a Client that accepts every request
+func NewClient() *Client {
+	return &Client{}
+}
+
+// CreateLogGroupWithContext implements cloudwatchlogsiface.CloudWatchLogsAPI
+func (c *Client) CreateLogGroupWithContext(_ aws.Context, input *cloudwatchlogs.CreateLogGroupInput, _ ...request.Option) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.CreatedGroups = append(c.CreatedGroups, input)
+	if c.NoGroup && c.AbortCreates > 0 {
+		c.AbortCreates--
+		c.NoGroup = false
+		return nil, awserr.New(cloudwatchlogs.ErrCodeOperationAbortedException, "conflicting operation", nil)
+	}
+	if !c.NoGroup {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceAlreadyExistsException, "group exists", nil)
+	}
+	c.NoGroup = false
+	return &cloudwatchlogs.CreateLogGroupOutput{}, nil
+}
+
+// CreateLogStreamWithContext implements cloudwatchlogsiface.CloudWatchLogsAPI
+func (c *Client) CreateLogStreamWithContext(_ aws.Context, input *cloudwatchlogs.CreateLogStreamInput, _ ...request.Option) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.CreatedStreams = append(c.CreatedStreams, input)
+	if c.NoGroup {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "group does not exist", nil)
+	}
+	if c.NoStream && c.AbortCreates > 0 {
+		c.AbortCreates--
+		c.NoStream = false
+		return nil, awserr.New(cloudwatchlogs.ErrCodeOperationAbortedException, "conflicting operation", nil)
+	}
+	if !c.NoStream {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceAlreadyExistsException, "stream exists", nil)
+	}
+	c.NoStream = false
+	return &cloudwatchlogs.CreateLogStreamOutput{}, nil
+}
+
+// DescribeLogStreamsWithContext implements
+// cloudwatchlogsiface.CloudWatchLogsAPI. It lists the requested stream unless
+// NoStream is set.
+func (c *Client) DescribeLogStreamsWithContext(_ aws.Context, input *cloudwatchlogs.DescribeLogStreamsInput, _ ...request.Option) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.DescribeErr != nil {
+		return nil, c.DescribeErr
+	}
+	if c.NoGroup {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "group does not exist", nil)
+	}
+
+	out := &cloudwatchlogs.DescribeLogStreamsOutput{}
+	if !c.NoStream {
+		out.LogStreams = []*cloudwatchlogs.LogStream{{LogStreamName: input.LogStreamNamePrefix}}
+	}
+	return out, nil
+}
+
+// PutRetentionPolicyWithContext implements cloudwatchlogsiface.CloudWatchLogsAPI
+func (c *Client) PutRetentionPolicyWithContext(_ aws.Context, input *cloudwatchlogs.PutRetentionPolicyInput, _ ...request.Option) (*cloudwatchlogs.PutRetentionPolicyOutput, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.Retention = append(c.Retention, input)
+	return &cloudwatchlogs.PutRetentionPolicyOutput{}, nil
+}
+
+// PutLogEvents implements cloudwatchlogsiface.CloudWatchLogsAPI
+func (c *Client) PutLogEvents(input *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	return c.PutLogEventsWithContext(context.Background(), input)
+}
+
+// PutLogEventsWithContext implements cloudwatchlogsiface.CloudWatchLogsAPI
+func (c *Client) PutLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.PutLogEventsInput, _ ...request.Option) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	c.Lock()
+	c.Tokens = append(c.Tokens, input.SequenceToken)
+	c.Unlock()
+
+	if c.PutHook != nil {
+		if err := c.PutHook(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.calls++
+	if c.NoStream {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "stream does not exist", nil)
+	}
+
+	// keep copies, so that the recorded events can't change if the caller
+	// reuses them
+	for _, e := range input.LogEvents {
+		c.Events = append(c.Events, &cloudwatchlogs.InputLogEvent{
+			Message:   aws.String(*e.Message),
+			Timestamp: aws.Int64(*e.Timestamp),
+		})
+	}
+	c.Batches = append(c.Batches, len(input.LogEvents))
+	c.seq++
+	out := &cloudwatchlogs.PutLogEventsOutput{
+		NextSequenceToken:     aws.String(strconv.Itoa(c.seq)),
+		RejectedLogEventsInfo: c.Rejected,
+	}
+	if c.NilToken {
+		out.NextSequenceToken = nil
+	}
+	return out, nil
+}
+
+// Calls returns the number of PutLogEvents calls that got past PutHook
+func (c *Client) Calls() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.calls
+}
+
+// Messages returns the messages of the events received so far
+func (c *Client) Messages() []string {
+	c.Lock()
+	defer c.Unlock()
+
+	messages := make([]string, len(c.Events))
+	for i, e := range c.Events {
+		messages[i] = *e.Message
+	}
+	return messages
+}
+
+// FailCalls returns a PutHook that fails the first n calls with err and lets
+// the rest through
+func FailCalls(n int, err error) func(ctx context.Context) error {
+	var (
+		mu    sync.Mutex
+		calls int
+	)
+	return func(context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls++
+		if calls <= n {
+			return err
+		}
+		return nil
+	}
+}
+
+// Throttled returns the error CloudWatch Logs returns when a request is
+// throttled
+func Throttled() error {
+	return awserr.New(cloudwatchlogs.ErrCodeThrottlingException, "rate exceeded", nil)
+}
+
+// NewClock returns a clock for writer.WithClock that starts at 1 and
+// advances by one millisecond each time it is read, so that every event has
+// a distinct, predictable timestamp
+func NewClock() func() int64 {
+	var (
+		mu  sync.Mutex
+		cnt int64
+	)
+	return func() int64 {
+		mu.Lock()
+		defer mu.Unlock()
+
+		cnt++
+		return cnt
+	}
+}
+
+// chunkReader returns one chunk of its data per Read
+type chunkReader struct {
+	chunks [][]byte
+}
+
+// NewChunkReader returns a reader that returns each chunk from a separate
+// call to Read, as a pipe or terminal might. Chunks larger than the buffer
+// passed to Read are returned over several calls.
+func NewChunkReader(chunks ...[]byte) io.Reader {
+	return &chunkReader{chunks: append([][]byte(nil), chunks...)}
+}
+
+// Read implements io.Reader
+func (r *chunkReader) Read(b []byte) (int, error) {
+	for len(r.chunks) > 0 && len(r.chunks[0]) == 0 {
+		r.chunks = r.chunks[1:]
+	}
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(b, r.chunks[0])
+	r.chunks[0] = r.chunks[0][n:]
+	return n, nil
+}
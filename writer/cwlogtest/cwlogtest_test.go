@@ -0,0 +1,58 @@
+package cwlogtest_test
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/kylemcc/cwlog/writer"
+	"github.com/kylemcc/cwlog/writer/cwlogtest"
+)
+
+func TestClient(t *testing.T) {
+	client := cwlogtest.NewClient()
+	client.NoGroup = true
+	client.NoStream = true
+	client.PutHook = cwlogtest.FailCalls(1, cwlogtest.Throttled())
+
+	w := writer.New("group", "stream", client, writer.WithClock(cwlogtest.NewClock()))
+	if _, err := w.Write([]byte("one\ntwo\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, want := client.Messages(), []string{"one", "two"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected messages: got=%q want=%q", got, want)
+	}
+	if *client.Events[0].Timestamp != 1 || *client.Events[1].Timestamp != 2 {
+		t.Errorf("unexpected timestamps: %d, %d", *client.Events[0].Timestamp, *client.Events[1].Timestamp)
+	}
+	if len(client.CreatedGroups) != 1 || len(client.CreatedStreams) != 2 {
+		t.Errorf("unexpected creates: groups=%d streams=%d", len(client.CreatedGroups), len(client.CreatedStreams))
+	}
+}
+
+func TestChunkReader(t *testing.T) {
+	r := cwlogtest.NewChunkReader([]byte("abc"), nil, []byte("defgh"))
+
+	var reads []string
+	buf := make([]byte, 4)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			break
+		}
+		reads = append(reads, string(buf[:n]))
+	}
+
+	if want := []string{"abc", "defg", "h"}; !reflect.DeepEqual(reads, want) {
+		t.Errorf("unexpected reads: got=%q want=%q", reads, want)
+	}
+
+	r = cwlogtest.NewChunkReader([]byte("x\n"), []byte("y\n"))
+	if b, err := ioutil.ReadAll(r); err != nil || string(b) != "x\ny\n" {
+		t.Errorf("unexpected result: %q, %v", b, err)
+	}
+}
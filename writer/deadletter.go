@@ -0,0 +1,45 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// deadLetterEvent is the form in which an undelivered event is written to
+// the dead letter file
+type deadLetterEvent struct {
+	Message   string `json:"message"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// writeDeadLetters appends events to the dead letter file, one JSON object
+// per line, so that they can be replayed later. Each call writes all of its
+// events at once, so writers sharing a file don't interleave their lines. The
+// caller must hold the lock.
+func (w *LogWriter) writeDeadLetters(events []*cloudwatchlogs.InputLogEvent) error {
+	if w.deadLetterFile == "" || len(events) == 0 {
+		return nil
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	for _, e := range events {
+		if err := enc.Encode(deadLetterEvent{Message: *e.Message, Timestamp: *e.Timestamp}); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(w.deadLetterFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
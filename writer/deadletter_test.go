@@ -0,0 +1,70 @@
+package writer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+func TestWriterDeadLetterFile(t *testing.T) {
+	now = mockNow()
+
+	dir, err := ioutil.TempDir("", "cwlog")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "undelivered.jsonl")
+
+	errFail := awserr.New(cloudwatchlogs.ErrCodeAccessDeniedException, "denied", nil)
+	logsClient := newLogsCLientTest()
+	logsClient.PutHook = func(context.Context) error { return errFail }
+
+	w := New("group", "stream", logsClient, WithFlushInterval(time.Hour), WithMaxBatchEvents(2), WithDeadLetterFile(path))
+
+	// the first batch is dropped by the failed flush, and the rest are left
+	// in the buffer when Close gives up
+	if _, err := w.Write([]byte("one\ntwo\n\nthree <&>\nfour\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != errFail {
+		t.Errorf("unexpected error: got=%v want=%v", err, errFail)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+
+	var events []deadLetterEvent
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e deadLetterEvent
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatalf("invalid line %q: %v", s.Text(), err)
+		}
+		events = append(events, e)
+	}
+
+	expected := []string{"one", "two", "\u0000", "three <&>", "four"}
+	if len(events) != len(expected) {
+		t.Fatalf("unexpected number of events: got=%d want=%d: %v", len(events), len(expected), events)
+	}
+	for i, e := range events {
+		if e.Message != expected[i] {
+			t.Errorf("event %d: unexpected message: got=%q want=%q", i, e.Message, expected[i])
+		}
+		if e.Timestamp <= 0 || (i > 0 && e.Timestamp < events[i-1].Timestamp) {
+			t.Errorf("event %d: unexpected timestamp: %d", i, e.Timestamp)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package writer
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// emfMessageField is the field of an EMF event holding the original line
+const emfMessageField = "message"
+
+// EMFMetric describes a metric extracted from a numeric field of JSON log
+// lines by WithEMF
+type EMFMetric struct {
+	// Field is the top-level JSON field holding the metric's value. It is
+	// also used as the metric's name.
+	Field string
+
+	// Unit is the metric's unit, e.g. Milliseconds or Count. If empty,
+	// CloudWatch uses None.
+	Unit string
+}
+
+// emf wraps JSON log lines in the CloudWatch Embedded Metric Format so that
+// CloudWatch extracts metrics from them
+type emf struct {
+	namespace  string
+	metrics    []EMFMetric
+	dimensions []string
+}
+
+// emfMetadata is the _aws member of an EMF event
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+type emfDirective struct {
+	Namespace  string          `json:"Namespace"`
+	Dimensions [][]string      `json:"Dimensions"`
+	Metrics    []emfDefinition `json:"Metrics"`
+}
+
+type emfDefinition struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit,omitempty"`
+}
+
+// wrap returns line as an EMF event with timestamp ts, holding each metric
+// found in line, the line itself, and, if it is more than 0, the number of
+// times the line was repeated. Lines that aren't JSON objects or don't
+// contain any of the metrics are returned unchanged, and wrapped is false.
+// Like an enriched event, an EMF event is never split, so if it would be
+// larger than limit bytes, the embedded line is truncated until it fits.
+func (e *emf) wrap(line string, ts int64, repeated, limit int) (out string, wrapped bool) {
+	if !strings.HasPrefix(strings.TrimSpace(line), "{") {
+		return line, false
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &obj); err != nil {
+		return line, false
+	}
+
+	root := make(map[string]interface{})
+	directive := emfDirective{Namespace: e.namespace, Dimensions: [][]string{{}}}
+	for _, m := range e.metrics {
+		raw, ok := obj[m.Field]
+		if !ok {
+			continue
+		}
+		if _, err := strconv.ParseFloat(string(raw), 64); err != nil {
+			continue
+		}
+		root[m.Field] = raw
+		directive.Metrics = append(directive.Metrics, emfDefinition{Name: m.Field, Unit: m.Unit})
+	}
+	if len(directive.Metrics) == 0 {
+		return line, false
+	}
+
+	// dimension values must be strings, so others are left out
+	for _, d := range e.dimensions {
+		var s string
+		if raw, ok := obj[d]; ok && json.Unmarshal(raw, &s) == nil {
+			root[d] = raw
+			directive.Dimensions[0] = append(directive.Dimensions[0], d)
+		}
+	}
+
+	root["_aws"] = emfMetadata{Timestamp: ts, CloudWatchMetrics: []emfDirective{directive}}
+	if repeated > 0 {
+		root["repeated"] = repeated
+	}
+
+	out = fitJSON(limit, &line, func() string {
+		root[emfMessageField] = line
+		return encodeJSON(root)
+	})
+	return out, true
+}
@@ -0,0 +1,196 @@
+package writer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// validateEMF checks out against the Embedded Metric Format specification:
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+func validateEMF(t *testing.T, out string) map[string]interface{} {
+	t.Helper()
+
+	var root map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &root); err != nil {
+		t.Fatalf("event is not a JSON object: %v: %s", err, out)
+	}
+
+	meta, ok := root["_aws"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("_aws is missing or not an object: %s", out)
+	}
+	if ts, ok := meta["Timestamp"].(float64); !ok || ts < 0 || ts != float64(int64(ts)) {
+		t.Errorf("Timestamp is not a non-negative integer: %v", meta["Timestamp"])
+	}
+
+	directives, ok := meta["CloudWatchMetrics"].([]interface{})
+	if !ok || len(directives) == 0 {
+		t.Fatalf("CloudWatchMetrics is missing or empty: %s", out)
+	}
+	for _, d := range directives {
+		directive, ok := d.(map[string]interface{})
+		if !ok {
+			t.Fatalf("metric directive is not an object: %v", d)
+		}
+		if ns, ok := directive["Namespace"].(string); !ok || ns == "" || len(ns) > 1024 {
+			t.Errorf("invalid Namespace: %v", directive["Namespace"])
+		}
+
+		sets, ok := directive["Dimensions"].([]interface{})
+		if !ok {
+			t.Fatalf("Dimensions is missing or not an array: %v", directive["Dimensions"])
+		}
+		for _, s := range sets {
+			set, ok := s.([]interface{})
+			if !ok || len(set) > 30 {
+				t.Fatalf("invalid dimension set: %v", s)
+			}
+			for _, k := range set {
+				if _, ok := root[k.(string)].(string); !ok {
+					t.Errorf("dimension %v does not reference a string member", k)
+				}
+			}
+		}
+
+		metrics, ok := directive["Metrics"].([]interface{})
+		if !ok || len(metrics) == 0 || len(metrics) > 100 {
+			t.Fatalf("invalid Metrics: %v", directive["Metrics"])
+		}
+		for _, m := range metrics {
+			def, ok := m.(map[string]interface{})
+			if !ok {
+				t.Fatalf("metric definition is not an object: %v", m)
+			}
+			name, ok := def["Name"].(string)
+			if !ok || name == "" || len(name) > 1024 {
+				t.Fatalf("invalid metric Name: %v", def["Name"])
+			}
+			if _, ok := root[name].(float64); !ok {
+				t.Errorf("metric %s does not reference a numeric member", name)
+			}
+			if u, ok := def["Unit"]; ok {
+				if _, ok := u.(string); !ok {
+					t.Errorf("metric %s has a non-string Unit: %v", name, u)
+				}
+			}
+		}
+	}
+
+	return root
+}
+
+func TestEMFWrap(t *testing.T) {
+	e := &emf{
+		namespace:  "MyApp",
+		metrics:    []EMFMetric{{Field: "latency_ms", Unit: "Milliseconds"}, {Field: "bytes"}, {Field: "missing"}},
+		dimensions: []string{"route", "status"},
+	}
+
+	line := `{"level":"info","route":"/users","status":200,"latency_ms":12.5,"bytes":512,"msg":"<ok> & done"}`
+	out, _ := e.wrap(line, 1600000000000, 0, maxEventSize-eventSize)
+	root := validateEMF(t, out)
+
+	if root["latency_ms"] != 12.5 || root["bytes"] != float64(512) || root["route"] != "/users" {
+		t.Errorf("unexpected members: %s", out)
+	}
+	if _, ok := root["status"]; ok {
+		t.Errorf("a non-string dimension was included: %s", out)
+	}
+	if root[emfMessageField] != line {
+		t.Errorf("original line was not embedded: %v", root[emfMessageField])
+	}
+
+	meta := root["_aws"].(map[string]interface{})
+	if meta["Timestamp"] != float64(1600000000000) {
+		t.Errorf("unexpected timestamp: %v", meta["Timestamp"])
+	}
+
+	expected := `{"Namespace":"MyApp","Dimensions":[["route"]],"Metrics":[{"Name":"latency_ms","Unit":"Milliseconds"},{"Name":"bytes"}]}`
+	if got, _ := json.Marshal(meta["CloudWatchMetrics"].([]interface{})[0]); !jsonEqual(t, string(got), expected) {
+		t.Errorf("unexpected metric directive: got=%s want=%s", got, expected)
+	}
+}
+
+func TestEMFWrapUnchanged(t *testing.T) {
+	e := &emf{namespace: "MyApp", metrics: []EMFMetric{{Field: "latency_ms"}}}
+
+	for _, line := range []string{
+		"plain text",
+		`{"latency_ms":`,
+		`{"other":1}`,
+		`{"latency_ms":"12"}`,
+		`[1, 2, 3]`,
+	} {
+		if out, wrapped := e.wrap(line, 0, 0, maxEventSize-eventSize); out != line || wrapped {
+			t.Errorf("%q: line was modified: %s", line, out)
+		}
+	}
+}
+
+func TestEMFWrapLimit(t *testing.T) {
+	e := &emf{namespace: "MyApp", metrics: []EMFMetric{{Field: "n"}}}
+
+	line := `{"n":1,"msg":"` + strings.Repeat(`\"`, 150) + `"}`
+	out, _ := e.wrap(line, 0, 0, 400)
+	if len(out) > 400 {
+		t.Errorf("event exceeds the limit: %d bytes", len(out))
+	}
+
+	root := validateEMF(t, out)
+	msg, _ := root[emfMessageField].(string)
+	if !strings.HasSuffix(msg, truncatedMarker) || !strings.HasPrefix(line, strings.TrimSuffix(msg, truncatedMarker)) {
+		t.Errorf("message was not truncated: %q", msg)
+	}
+}
+
+func TestEMFWrapUnshrinkable(t *testing.T) {
+	e := &emf{namespace: strings.Repeat("MyApp", 50), metrics: []EMFMetric{{Field: "n"}}}
+
+	// the metadata alone is over the limit, so truncating the line can't help
+	if out, _ := e.wrap(`{"n":1}`, 0, 0, 100); len(out) > 100 {
+		t.Errorf("event exceeds the limit: %d bytes: %s", len(out), out)
+	}
+}
+
+func TestWriterEMF(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithEMF("MyApp", []EMFMetric{{Field: "count", Unit: "Count"}}))
+
+	if _, err := w.Write([]byte("{\"count\":3}\nnot json\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(logsClient.Events) != 2 {
+		t.Fatalf("unexpected number of events: %d", len(logsClient.Events))
+	}
+
+	root := validateEMF(t, *logsClient.Events[0].Message)
+	meta := root["_aws"].(map[string]interface{})
+	if int64(meta["Timestamp"].(float64)) != *logsClient.Events[0].Timestamp {
+		t.Errorf("EMF timestamp %v does not match the event's: %d", meta["Timestamp"], *logsClient.Events[0].Timestamp)
+	}
+	if *logsClient.Events[1].Message != "not json" {
+		t.Errorf("unexpected message: %q", *logsClient.Events[1].Message)
+	}
+}
+
+func jsonEqual(t *testing.T, a, b string) bool {
+	t.Helper()
+
+	var x, y interface{}
+	if err := json.Unmarshal([]byte(a), &x); err != nil {
+		t.Fatalf("invalid JSON: %v: %s", err, a)
+	}
+	if err := json.Unmarshal([]byte(b), &y); err != nil {
+		t.Fatalf("invalid JSON: %v: %s", err, b)
+	}
+	xb, _ := json.Marshal(x)
+	yb, _ := json.Marshal(y)
+	return string(xb) == string(yb)
+}
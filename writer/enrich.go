@@ -0,0 +1,55 @@
+package writer
+
+import (
+	"fmt"
+	"os"
+)
+
+// EnrichFormat determines how events are annotated with the host and process
+// they came from
+type EnrichFormat int
+
+const (
+	// EnrichJSON wraps each event in a JSON object of the form
+	// {"host":"web-3","pid":1234,"msg":"..."}
+	EnrichJSON EnrichFormat = iota + 1
+
+	// EnrichKeyValue prepends host=web-3 pid=1234 to each event
+	EnrichKeyValue
+)
+
+// enricher annotates events with the host and process they came from
+type enricher struct {
+	format EnrichFormat
+	host   string
+	pid    int
+}
+
+// envelope is the JSON form of an enriched event
+type envelope struct {
+	Host     string `json:"host"`
+	PID      int    `json:"pid"`
+	Msg      string `json:"msg"`
+	Repeated int    `json:"repeated,omitempty"`
+}
+
+func newEnricher(format EnrichFormat) *enricher {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &enricher{format: format, host: host, pid: os.Getpid()}
+}
+
+// wrap annotates msg. A JSON envelope also holds the number of times msg was
+// repeated, if it is more than 0. It is never split into several events, so
+// if it would be larger than limit bytes, msg is truncated until it fits.
+func (e *enricher) wrap(msg string, repeated, limit int) string {
+	if e.format == EnrichKeyValue {
+		return fmt.Sprintf("host=%s pid=%d %s", e.host, e.pid, msg)
+	}
+
+	return fitJSON(limit, &msg, func() string {
+		return encodeJSON(envelope{Host: e.host, PID: e.pid, Msg: msg, Repeated: repeated})
+	})
+}
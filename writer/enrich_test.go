@@ -0,0 +1,81 @@
+package writer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnricherJSON(t *testing.T) {
+	e := &enricher{format: EnrichJSON, host: "web-3", pid: 1234}
+
+	for _, msg := range []string{
+		"plain",
+		"",
+		`quotes " and \ backslashes`,
+		"<html> & ampersands",
+		"tabs\tand\nnewlines",
+		"unicode é ✓",
+	} {
+		out := e.wrap(msg, 0, maxEventSize-eventSize)
+
+		var env envelope
+		if err := json.Unmarshal([]byte(out), &env); err != nil {
+			t.Errorf("%q: envelope is not valid JSON: %v: %s", msg, err, out)
+			continue
+		}
+		if env.Host != "web-3" || env.PID != 1234 || env.Msg != msg {
+			t.Errorf("%q: unexpected envelope: %+v", msg, env)
+		}
+	}
+
+	if got, want := e.wrap("<ok>", 0, 100), `{"host":"web-3","pid":1234,"msg":"<ok>"}`; got != want {
+		t.Errorf("unexpected envelope: got=%s want=%s", got, want)
+	}
+}
+
+func TestEnricherJSONLimit(t *testing.T) {
+	e := &enricher{format: EnrichJSON, host: "web-3", pid: 1234}
+
+	// quotes double in size when escaped
+	for _, msg := range []string{strings.Repeat("x", 300), strings.Repeat(`"`, 300)} {
+		out := e.wrap(msg, 0, 200)
+		if len(out) > 200 {
+			t.Errorf("envelope exceeds the limit: %d bytes", len(out))
+		}
+
+		var env envelope
+		if err := json.Unmarshal([]byte(out), &env); err != nil {
+			t.Fatalf("envelope is not valid JSON: %v: %s", err, out)
+		}
+		if !strings.HasSuffix(env.Msg, truncatedMarker) || !strings.HasPrefix(msg, strings.TrimSuffix(env.Msg, truncatedMarker)) {
+			t.Errorf("message was not truncated: %q", env.Msg)
+		}
+	}
+}
+
+func TestEnricherJSONUnshrinkable(t *testing.T) {
+	e := &enricher{format: EnrichJSON, host: "web-3", pid: 1234}
+
+	// the envelope alone is over the limit, so truncating msg can't help
+	done := make(chan string, 1)
+	go func() { done <- e.wrap("message", 0, 20) }()
+
+	select {
+	case out := <-done:
+		if len(out) > 20 {
+			t.Errorf("envelope exceeds the limit: %d bytes: %s", len(out), out)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wrap did not return")
+	}
+}
+
+func TestEnricherKeyValue(t *testing.T) {
+	e := &enricher{format: EnrichKeyValue, host: "web-3", pid: 1234}
+
+	if got, want := e.wrap("hello", 0, 100), "host=web-3 pid=1234 hello"; got != want {
+		t.Errorf("unexpected result: got=%q want=%q", got, want)
+	}
+}
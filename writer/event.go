@@ -0,0 +1,136 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// truncatedMarker is appended to events that were cut short to fit within
+// the per-event size limit
+const truncatedMarker = "…[truncated]"
+
+// eventPool holds log events that have been sent or discarded, so they can be
+// reused rather than allocating new ones for every line
+var eventPool = sync.Pool{
+	New: func() interface{} {
+		return &cloudwatchlogs.InputLogEvent{Timestamp: new(int64)}
+	},
+}
+
+// newEvent returns a log event from the pool
+func newEvent(msg *string, ts int64) *cloudwatchlogs.InputLogEvent {
+	e := eventPool.Get().(*cloudwatchlogs.InputLogEvent)
+	if e.Timestamp == nil {
+		e.Timestamp = new(int64)
+	}
+	e.Message = msg
+	*e.Timestamp = ts
+	return e
+}
+
+// releaseEvents returns events to the pool. They must no longer be referenced
+// by the buffer or by a request in flight.
+func releaseEvents(events []*cloudwatchlogs.InputLogEvent) {
+	for _, e := range events {
+		e.Message = nil
+		eventPool.Put(e)
+	}
+}
+
+// splitMessage splits text into pieces of at most n bytes. Pieces are only
+// split on UTF-8 rune boundaries, so each piece remains valid UTF-8 if text is.
+func splitMessage(text string, n int) []string {
+	var pieces []string
+	for len(text) > n {
+		i := runeBoundary(text, n)
+		pieces = append(pieces, text[:i])
+		text = text[i:]
+	}
+	return append(pieces, text)
+}
+
+// truncateMessage shortens text to at most n bytes, including marker, which
+// is appended if text had to be shortened
+func truncateMessage(text string, n int, marker string) string {
+	if len(text) <= n {
+		return text
+	}
+	return text[:runeBoundary(text, n-len(marker))] + marker
+}
+
+// truncateLine shortens text to at most n bytes and appends a marker giving
+// the number of bytes that were dropped. The marker is not counted against n.
+func truncateLine(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+
+	i := runeBoundary(text, n)
+	if !utf8.RuneStart(text[i]) {
+		// n falls inside the first rune, so nothing can be kept
+		i = 0
+	}
+	return fmt.Sprintf("%s…[truncated %d bytes]", text[:i], len(text)-i)
+}
+
+// fitJSON returns the JSON object built by encode, which must embed *msg,
+// shortening *msg until the object is at most limit bytes. An object is never
+// split, since its pieces wouldn't be valid JSON, so if it is still too long
+// without any message, the object itself is truncated.
+func fitJSON(limit int, msg *string, encode func() string) string {
+	out := encode()
+	for len(out) > limit && *msg != "" {
+		// escaping only ever lengthens the message, so removing the excess
+		// from the raw message removes at least as much from the encoded one
+		n := len(*msg) - (len(out) - limit)
+		if n < len(truncatedMarker) {
+			n = len(truncatedMarker)
+		}
+		if n >= len(*msg) {
+			// too little is left to keep any of it along with the marker
+			*msg = ""
+		} else {
+			*msg = truncateMessage(*msg, n, truncatedMarker)
+		}
+		out = encode()
+	}
+
+	switch {
+	case len(out) <= limit:
+		return out
+	case limit >= len(truncatedMarker):
+		return truncateMessage(out, limit, truncatedMarker)
+	}
+	return out[:runeBoundary(out, limit)]
+}
+
+// encodeJSON returns v encoded as JSON, without a trailing newline. Messages
+// should arrive as written, so <, >, and & are left alone.
+func encodeJSON(v interface{}) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+
+	return string(bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+}
+
+// runeBoundary returns the largest index i <= n such that text[:i] does not
+// end in the middle of a multi-byte rune. If no such index is greater than
+// zero, n is returned so callers always make progress.
+func runeBoundary(text string, n int) int {
+	if n >= len(text) {
+		return len(text)
+	}
+	for i := n; i > 0; i-- {
+		if utf8.RuneStart(text[i]) {
+			return i
+		}
+	}
+	return n
+}
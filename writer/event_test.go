@@ -0,0 +1,71 @@
+package writer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTruncateLine(t *testing.T) {
+	cases := []struct {
+		name     string
+		text     string
+		n        int
+		expected string
+	}{
+		{"short", "hello", 10, "hello"},
+		{"exact", "hello", 5, "hello"},
+		{"ascii", "hello world", 5, "hello…[truncated 6 bytes]"},
+		{"rune boundary", "héllo", 2, "h…[truncated 5 bytes]"},
+		{"after rune", "héllo", 3, "hé…[truncated 3 bytes]"},
+		{"inside first rune", "✓ok", 2, "…[truncated 5 bytes]"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := truncateLine(c.text, c.n); got != c.expected {
+				t.Errorf("unexpected result: got=%q want=%q", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestFitJSON(t *testing.T) {
+	type object struct {
+		Host string `json:"host"`
+		Msg  string `json:"msg"`
+	}
+
+	cases := []struct {
+		name  string
+		host  string
+		msg   string
+		limit int
+		valid bool
+	}{
+		{"fits", "web-3", "<hello> & goodbye", 100, true},
+		{"long message", "web-3", strings.Repeat("x", 300), 100, true},
+		{"escaped message", "web-3", strings.Repeat(`"`, 300), 100, true},
+		{"message too short to truncate", "web-3", "hello", 25, true},
+		{"object too long", strings.Repeat("h", 100), "hello", 50, false},
+		{"limit under the marker", "web-3", "hello", 5, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			o := object{Host: c.host, Msg: c.msg}
+			out := fitJSON(c.limit, &o.Msg, func() string { return encodeJSON(o) })
+			if len(out) > c.limit {
+				t.Errorf("result exceeds the limit: %d bytes: %s", len(out), out)
+			}
+
+			var got object
+			if err := json.Unmarshal([]byte(out), &got); (err == nil) != c.valid {
+				t.Fatalf("unexpected validity: err=%v: %s", err, out)
+			}
+			if c.valid && !strings.HasPrefix(c.msg, strings.TrimSuffix(got.Msg, truncatedMarker)) {
+				t.Errorf("message was not kept or truncated: %q", got.Msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package writer
+
+import "time"
+
+// markActive records that the stream has seen activity, postponing the next
+// heartbeat. The caller must hold the lock.
+func (w *LogWriter) markActive() {
+	if w.heartbeatInterval > 0 {
+		w.lastActivity = w.now()
+	}
+}
+
+// heartbeat buffers a heartbeat event if nothing has been written or flushed
+// for at least the heartbeat interval. The event skips the line pipeline, so
+// that filters and the like can't hold it back or rewrite it
+func (w *LogWriter) heartbeat() {
+	if w.heartbeatInterval <= 0 {
+		return
+	}
+
+	w.Lock()
+	idle := len(w.buf) == 0 && time.Duration(w.now()-w.lastActivity)*time.Millisecond >= w.heartbeatInterval
+	w.Unlock()
+
+	if idle {
+		w.bufferEvent(w.heartbeatMessage, w.now(), true)
+	}
+}
@@ -0,0 +1,52 @@
+package writer
+
+import (
+	"encoding/json"
+	"regexp"
+)
+
+// jsonOutput wraps plain-text lines in JSON objects with separate timestamp,
+// level, and message fields, for consumers such as CloudWatch Logs Insights
+type jsonOutput struct {
+	// level, if set, finds the line's log level
+	level *regexp.Regexp
+}
+
+// jsonEvent is the JSON form of a plain-text line
+type jsonEvent struct {
+	Timestamp int64  `json:"@timestamp"`
+	Level     string `json:"level,omitempty"`
+	Message   string `json:"message"`
+	Repeated  int    `json:"repeated,omitempty"`
+}
+
+// wrap returns line as a JSON object with timestamp ts and, if it is more
+// than 0, the number of times the line was repeated. Lines that are already
+// valid JSON are returned unchanged unless they were repeated. Like an
+// enriched event, the object is never split, so if it would be larger than
+// limit bytes, the message is truncated until it fits.
+func (j *jsonOutput) wrap(line string, ts int64, repeated, limit int) string {
+	if repeated == 0 && json.Valid([]byte(line)) {
+		return line
+	}
+
+	e := jsonEvent{Timestamp: ts, Level: j.findLevel(line), Message: line, Repeated: repeated}
+	return fitJSON(limit, &e.Message, func() string { return encodeJSON(e) })
+}
+
+// findLevel returns the text matched by the level pattern, or by its first
+// capturing group if it has one
+func (j *jsonOutput) findLevel(line string) string {
+	if j.level == nil {
+		return ""
+	}
+
+	m := j.level.FindStringSubmatch(line)
+	switch {
+	case m == nil:
+		return ""
+	case len(m) > 1:
+		return m[1]
+	}
+	return m[0]
+}
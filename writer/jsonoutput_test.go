@@ -0,0 +1,113 @@
+package writer
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestJSONOutputWrap(t *testing.T) {
+	cases := []struct {
+		name     string
+		level    *regexp.Regexp
+		line     string
+		expected string
+	}{
+		{
+			name:     "plain text",
+			line:     "server started <ok> & ready",
+			expected: `{"@timestamp":1600000000000,"message":"server started <ok> & ready"}`,
+		},
+		{
+			name:     "empty line",
+			line:     "",
+			expected: `{"@timestamp":1600000000000,"message":""}`,
+		},
+		{
+			name:     "level",
+			level:    regexp.MustCompile(`ERROR|WARN|INFO`),
+			line:     "2020-09-13 WARN disk almost full",
+			expected: `{"@timestamp":1600000000000,"level":"WARN","message":"2020-09-13 WARN disk almost full"}`,
+		},
+		{
+			name:     "level group",
+			level:    regexp.MustCompile(`level=(\w+)`),
+			line:     `level=error msg="connection \"db\" lost"`,
+			expected: `{"@timestamp":1600000000000,"level":"error","message":"level=error msg=\"connection \\\"db\\\" lost\""}`,
+		},
+		{
+			name:     "no level",
+			level:    regexp.MustCompile(`ERROR|WARN|INFO`),
+			line:     "no level here",
+			expected: `{"@timestamp":1600000000000,"message":"no level here"}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			j := &jsonOutput{level: c.level}
+			if got := j.wrap(c.line, 1600000000000, 0, maxEventSize-eventSize); got != c.expected {
+				t.Errorf("unexpected output: got=%s want=%s", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestJSONOutputPassthrough(t *testing.T) {
+	j := &jsonOutput{level: regexp.MustCompile(`error`)}
+
+	for _, line := range []string{
+		`{"level":"error","msg":"already structured"}`,
+		` {"nested":{"a":[1,2,3]}} `,
+		`["an","array"]`,
+		`42`,
+	} {
+		if got := j.wrap(line, 0, 0, maxEventSize-eventSize); got != line {
+			t.Errorf("%q: line was modified: %s", line, got)
+		}
+	}
+}
+
+func TestJSONOutputLimit(t *testing.T) {
+	j := &jsonOutput{}
+
+	line := strings.Repeat(`"`, 300)
+	out := j.wrap(line, 0, 0, 400)
+	if len(out) > 400 {
+		t.Errorf("event exceeds the limit: %d bytes", len(out))
+	}
+	if !strings.HasSuffix(out, truncatedMarker+`"}`) {
+		t.Errorf("message was not truncated: %s", out)
+	}
+}
+
+func TestWriterJSONOutput(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithJSONOutput(regexp.MustCompile(`\[(\w+)\]`)), WithPrefix("web-3 "))
+
+	if _, err := w.Write([]byte("[INFO] started\n{\"msg\":\"structured\"}\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		`{"@timestamp":1,"level":"INFO","message":"web-3 [INFO] started"}`,
+		`web-3 {"msg":"structured"}`,
+	}
+	got := logsClient.Messages()
+	if len(got) != len(expected) {
+		t.Fatalf("unexpected messages: %q", got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("message %d did not match: got=%s want=%s", i, got[i], expected[i])
+		}
+	}
+	if ts := *logsClient.Events[0].Timestamp; ts != 1 {
+		t.Errorf("event timestamp does not match @timestamp: %d", ts)
+	}
+}
@@ -0,0 +1,129 @@
+package writer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limiter spaces out calls so that no more than a fixed number are made per
+// second. Callers block in wait until they are allowed to proceed; nothing is
+// dropped. A nil limiter never blocks.
+type limiter struct {
+	mu sync.Mutex
+
+	// interval is the minimum time between calls
+	interval time.Duration
+
+	// last is the time at which the most recent call was allowed to proceed
+	last time.Time
+
+	// now and sleep are fields so tests can substitute a fake clock
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// newLimiter returns a limiter allowing perSecond calls per second. If
+// perSecond is not positive, newLimiter returns nil, which disables limiting.
+func newLimiter(perSecond int) *limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	return &limiter{
+		interval: time.Second / time.Duration(perSecond),
+		now:      time.Now,
+		sleep:    sleep,
+	}
+}
+
+// wait blocks until the next call is allowed or ctx is done. Concurrent
+// callers are each given their own slot.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := l.now()
+	var d time.Duration
+	if next := l.last.Add(l.interval); now.Before(next) {
+		d = next.Sub(now)
+		now = next
+	}
+	l.last = now
+	l.mu.Unlock()
+
+	if d > 0 {
+		return l.sleep(ctx, d)
+	}
+	return nil
+}
+
+// byteLimiter is a token bucket that caps the number of bytes sent per
+// second. The bucket holds up to one second's worth of bytes; a batch larger
+// than what's left is let through once the bucket has refilled enough to pay
+// for it, so batches larger than the bucket are still sent, just more
+// slowly. Like limiter, it blocks rather than drops, and a nil byteLimiter
+// never blocks.
+type byteLimiter struct {
+	mu sync.Mutex
+
+	// rate is the number of bytes added to the bucket per second
+	rate float64
+
+	// tokens is the number of bytes available at time last. It is negative
+	// while callers are waiting for bytes they have already taken
+	tokens float64
+	last   time.Time
+
+	// now and sleep are fields so tests can substitute a fake clock
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// newByteLimiter returns a byteLimiter allowing perSecond bytes per second.
+// If perSecond is not positive, newByteLimiter returns nil, which disables
+// limiting.
+func newByteLimiter(perSecond int) *byteLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	return &byteLimiter{
+		rate:   float64(perSecond),
+		tokens: float64(perSecond),
+		now:    time.Now,
+		sleep:  sleep,
+	}
+}
+
+// wait takes n bytes from the bucket, blocking until they are available or
+// ctx is done
+func (l *byteLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if d > 0 {
+		return l.sleep(ctx, d)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package writer
+
+// Logger receives diagnostic messages about the writer's activity, such as
+// the size of each batch, failed attempts, and the creation of log groups and
+// streams. *log.Logger satisfies this interface.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// logf sends a diagnostic message to the writer's logger, if it has one
+func (w *LogWriter) logf(format string, v ...interface{}) {
+	if w.logger != nil {
+		w.logger.Printf(format, v...)
+	}
+}
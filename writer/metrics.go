@@ -0,0 +1,61 @@
+package writer
+
+import "time"
+
+// Metrics receives measurements of the writer's delivery of log events, e.g.
+// to export them to a monitoring system. Its methods are called with the
+// writer's lock held, so they must return quickly and must not call the
+// writer.
+type Metrics interface {
+	// IncSent counts events delivered to CloudWatch Logs
+	IncSent(n int)
+
+	// IncDropped counts events that will never be delivered, because a
+	// flush failed, CloudWatch Logs rejected them, or they were discarded
+	// to keep the buffer within its limit
+	IncDropped(n int)
+
+	// IncRetries counts retried PutLogEvents calls
+	IncRetries(n int)
+
+	// ObserveBatchBytes records the size of a batch sent to CloudWatch Logs,
+	// including per-event overhead
+	ObserveBatchBytes(n int)
+
+	// ObserveFlushLatency records how long sending a batch took, including
+	// any retries
+	ObserveFlushLatency(d time.Duration)
+}
+
+// recordSent counts n delivered events. The caller must hold the lock.
+func (w *LogWriter) recordSent(n int) {
+	w.stats.SentEvents += n
+	if w.metrics != nil && n > 0 {
+		w.metrics.IncSent(n)
+	}
+}
+
+// recordDropped counts n undeliverable events. The caller must hold the lock.
+func (w *LogWriter) recordDropped(n int) {
+	w.stats.DroppedEvents += n
+	if w.metrics != nil && n > 0 {
+		w.metrics.IncDropped(n)
+	}
+}
+
+// recordRetries counts n retried requests. The caller must hold the lock.
+func (w *LogWriter) recordRetries(n int) {
+	w.stats.RetryCount += n
+	if w.metrics != nil && n > 0 {
+		w.metrics.IncRetries(n)
+	}
+}
+
+// recordFlush records the size of a batch and how long it took to send. The
+// caller must hold the lock.
+func (w *LogWriter) recordFlush(size int, d time.Duration) {
+	if w.metrics != nil {
+		w.metrics.ObserveBatchBytes(size)
+		w.metrics.ObserveFlushLatency(d)
+	}
+}
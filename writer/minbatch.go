@@ -0,0 +1,40 @@
+package writer
+
+import "time"
+
+// defaultMaxFlushDelay is how long a batch below the minimum size is held if
+// WithMaxFlushDelay isn't used
+const defaultMaxFlushDelay = time.Minute
+
+// holdsBatches reports whether a minimum batch size is set
+func (w *LogWriter) holdsBatches() bool {
+	return w.minBatchEvents > 0 || w.minBatchBytes > 0
+}
+
+// holdBatch reports whether the periodic flush should be skipped because the
+// buffer is below the minimum batch size and its oldest event hasn't been
+// held for the maximum delay
+func (w *LogWriter) holdBatch() bool {
+	if !w.holdsBatches() {
+		return false
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if len(w.buf) == 0 {
+		return false
+	}
+	if w.minBatchEvents > 0 && len(w.buf) >= w.minBatchEvents {
+		return false
+	}
+	if w.minBatchBytes > 0 && w.bufSize >= w.minBatchBytes {
+		return false
+	}
+
+	delay := w.maxFlushDelay
+	if delay <= 0 {
+		delay = defaultMaxFlushDelay
+	}
+	return time.Duration(w.now()-w.bufferedSince)*time.Millisecond < delay
+}
@@ -0,0 +1,101 @@
+package writer
+
+import (
+	"strings"
+	"sync"
+)
+
+// Target describes a log stream written to by a MultiWriter
+type Target struct {
+	LogGroup  string
+	LogStream string
+	Client    Client
+
+	// Options configure the LogWriter for this target
+	Options []Option
+}
+
+// MultiWriter copies everything written to it to several log streams, each
+// with its own LogWriter. A failure writing to one stream does not stop the
+// others; errors from all of them are collected into a MultiError.
+type MultiWriter struct {
+	writers []*LogWriter
+}
+
+// NewMulti constructs and returns a MultiWriter that writes to each of the
+// given targets
+func NewMulti(targets ...Target) *MultiWriter {
+	m := MultiWriter{writers: make([]*LogWriter, len(targets))}
+	for i, t := range targets {
+		m.writers[i] = New(t.LogGroup, t.LogStream, t.Client, t.Options...)
+	}
+	return &m
+}
+
+// Writers returns the underlying writer for each target, in the order the
+// targets were given
+func (m *MultiWriter) Writers() []*LogWriter {
+	return m.writers
+}
+
+// Write implements io.Writer. data is written to every target, even if
+// writing to some of them fails. The targets that succeeded have taken all of
+// data, so its full length is returned along with any error, and a caller
+// that retried the rest of data would duplicate it in those streams.
+func (m *MultiWriter) Write(data []byte) (int, error) {
+	err := m.each(func(w *LogWriter) error {
+		_, err := w.Write(data)
+		return err
+	})
+	return len(data), err
+}
+
+// Flush writes any buffered log events for every target
+func (m *MultiWriter) Flush() error {
+	return m.each((*LogWriter).Flush)
+}
+
+// Close implements io.Closer. Every target's writer is closed, even if
+// closing some of them fails.
+func (m *MultiWriter) Close() error {
+	return m.each((*LogWriter).Close)
+}
+
+// each calls f for every writer concurrently, so that a slow target doesn't
+// hold up the others, and collects any errors
+func (m *MultiWriter) each(f func(*LogWriter) error) error {
+	errs := make([]error, len(m.writers))
+
+	var wg sync.WaitGroup
+	wg.Add(len(m.writers))
+	for i, w := range m.writers {
+		go func(i int, w *LogWriter) {
+			defer wg.Done()
+			errs[i] = f(w)
+		}(i, w)
+	}
+	wg.Wait()
+
+	var merr MultiError
+	for _, err := range errs {
+		if err != nil {
+			merr = append(merr, err)
+		}
+	}
+	if len(merr) > 0 {
+		return merr
+	}
+	return nil
+}
+
+// MultiError holds the errors returned by several writers
+type MultiError []error
+
+// Error implements error
+func (e MultiError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
@@ -0,0 +1,89 @@
+package writer
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// multiline joins lines that belong to a single logical event, such as the
+// lines of a stack trace, into one message. A line matching start begins a
+// new event; any other line is appended to the event in progress. Each event
+// is passed to an emit function while the lock is held, so that an event
+// expired by the flusher is never overtaken by the one after it.
+type multiline struct {
+	sync.Mutex
+
+	start *regexp.Regexp
+
+	// limit is the largest message the accumulator will build. A line that
+	// would push the event in progress past it begins a new event instead
+	limit int
+
+	buf     strings.Builder
+	pending bool
+
+	// added is set when a line is added, and cleared by expire
+	added bool
+}
+
+// add adds line to the event in progress. If line completes the previous
+// event, that event is passed to emit.
+func (m *multiline) add(line string, emit func(string)) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.pending && (m.start.MatchString(line) || m.buf.Len()+1+len(line) > m.limit) {
+		m.take(emit)
+	}
+
+	if m.pending {
+		m.buf.WriteByte('\n')
+	}
+	m.buf.WriteString(line)
+	m.pending = true
+	m.added = true
+}
+
+// expire passes the event in progress to emit if no line has been added since
+// the previous call, so that the last event isn't held indefinitely when the
+// input goes quiet without ending
+func (m *multiline) expire(emit func(string)) {
+	m.Lock()
+	defer m.Unlock()
+
+	if !m.added {
+		m.take(emit)
+	}
+	m.added = false
+}
+
+// flush passes the event in progress, if any, to emit once no more input can
+// arrive
+func (m *multiline) flush(emit func(string)) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.take(emit)
+}
+
+// take passes the event in progress, if any, to emit and resets the
+// accumulator. The caller must hold the lock.
+func (m *multiline) take(emit func(string)) {
+	if !m.pending {
+		return
+	}
+
+	event := m.buf.String()
+	m.buf.Reset()
+	m.pending = false
+	emit(event)
+}
+
+// expireMultiline buffers the event in progress if no line has been added to
+// it for a whole flush interval
+func (w *LogWriter) expireMultiline() {
+	if w.multiline != nil {
+		w.multiline.expire(w.appendEvent)
+	}
+}
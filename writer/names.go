@@ -0,0 +1,55 @@
+package writer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxNameLength is the maximum length of a log group or log stream name
+const maxNameLength = 512
+
+// ValidateLogGroupName checks name against the CloudWatch Logs naming rules
+// for log groups: 1-512 characters from a-z, A-Z, 0-9, and '_', '-', '/',
+// '.', and '#'. An invalid name is otherwise only reported by the first
+// request that uses it.
+//
+// https://docs.aws.amazon.com/AmazonCloudWatchLogs/latest/APIReference/API_CreateLogGroup.html
+func ValidateLogGroupName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("log group name must not be empty")
+	case len(name) > maxNameLength:
+		return fmt.Errorf("log group name %q is longer than %d characters", name, maxNameLength)
+	}
+
+	for _, c := range name {
+		if !validGroupChar(c) {
+			return fmt.Errorf("log group name %q contains %q; only letters, digits, and '_', '-', '/', '.', and '#' are allowed", name, c)
+		}
+	}
+	return nil
+}
+
+func validGroupChar(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.ContainsRune("_-/.#", c)
+}
+
+// ValidateLogStreamName checks name against the CloudWatch Logs naming rules
+// for log streams: 1-512 characters, none of which may be ':' or '*'.
+//
+// https://docs.aws.amazon.com/AmazonCloudWatchLogs/latest/APIReference/API_CreateLogStream.html
+func ValidateLogStreamName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("log stream name must not be empty")
+	case len(name) > maxNameLength:
+		return fmt.Errorf("log stream name %q is longer than %d characters", name, maxNameLength)
+	case strings.ContainsAny(name, ":*"):
+		return fmt.Errorf("log stream name %q must not contain ':' or '*'", name)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package writer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateLogGroupName(t *testing.T) {
+	for _, name := range []string{"app", "/aws/lambda/my-func_1", "team.app#prod", strings.Repeat("g", 512)} {
+		if err := ValidateLogGroupName(name); err != nil {
+			t.Errorf("%q: unexpected error: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"", strings.Repeat("g", 513), "my app", "app:prod", "app*", "app\x00", "appé"} {
+		if err := ValidateLogGroupName(name); err == nil {
+			t.Errorf("%q: expected an error", name)
+		}
+	}
+}
+
+func TestValidateLogStreamName(t *testing.T) {
+	for _, name := range []string{"stream", "host 1/app#prod", "i-0abc [main]", strings.Repeat("s", 512)} {
+		if err := ValidateLogStreamName(name); err != nil {
+			t.Errorf("%q: unexpected error: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"", strings.Repeat("s", 513), "host:app", "app*"} {
+		if err := ValidateLogStreamName(name); err == nil {
+			t.Errorf("%q: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,594 @@
+package writer
+
+import (
+	"bufio"
+	"regexp"
+	"time"
+)
+
+// Option configures optional behavior of a LogWriter
+type Option func(*LogWriter)
+
+// WithFlushInterval sets how often buffered log events are flushed to
+// CloudWatch Logs. The default is 2 seconds.
+func WithFlushInterval(d time.Duration) Option {
+	return func(w *LogWriter) {
+		if d > 0 {
+			w.flushInterval = d
+		}
+	}
+}
+
+// WithMaxRetries sets the maximum number of times a CloudWatch Logs operation
+// will be attempted before giving up. The default is 5.
+func WithMaxRetries(n int) Option {
+	return func(w *LogWriter) {
+		if n > 0 {
+			w.backoff.attempts = n
+		}
+	}
+}
+
+// WithRetryDeadline bounds the total time spent retrying a failed request.
+// Once waiting for another attempt would pass the deadline, the last error is
+// returned even if attempts remain. By default, only the number of attempts
+// is limited.
+func WithRetryDeadline(d time.Duration) Option {
+	return func(w *LogWriter) {
+		if d > 0 {
+			w.backoff.deadline = d
+		}
+	}
+}
+
+// WithMaxBatchBytes sets the maximum size in bytes of a single batch sent
+// to CloudWatch Logs. Values larger than the CloudWatch Logs limit of
+// 1,048,576 bytes are clamped to that limit.
+func WithMaxBatchBytes(n int) Option {
+	return func(w *LogWriter) {
+		if n > maxSize {
+			n = maxSize
+		}
+		if n > 0 {
+			w.maxBatchBytes = n
+		}
+	}
+}
+
+// WithMaxBatchEvents sets the maximum number of events in a single batch sent
+// to CloudWatch Logs. Values larger than the CloudWatch Logs limit of 10,000
+// events are clamped to that limit.
+func WithMaxBatchEvents(n int) Option {
+	return func(w *LogWriter) {
+		if n > maxEvents {
+			n = maxEvents
+		}
+		if n > 0 {
+			w.maxBatchEvents = n
+		}
+	}
+}
+
+// WithMinBatchEvents makes the periodic flush wait until at least n events
+// are buffered, so that a quiet stream is sent in fewer, larger requests. A
+// batch below the minimum is still sent once its oldest event has been held
+// for the delay set by WithMaxFlushDelay. If WithMinBatchBytes is also used,
+// reaching either minimum is enough. Close and explicit flushes send
+// everything regardless.
+func WithMinBatchEvents(n int) Option {
+	return func(w *LogWriter) {
+		if n > 0 {
+			w.minBatchEvents = n
+		}
+	}
+}
+
+// WithMinBatchBytes makes the periodic flush wait until at least n bytes of
+// events, including per-event overhead, are buffered. It is otherwise like
+// WithMinBatchEvents.
+func WithMinBatchBytes(n int) Option {
+	return func(w *LogWriter) {
+		if n > 0 {
+			w.minBatchBytes = n
+		}
+	}
+}
+
+// WithMaxFlushDelay sets the longest an event is held waiting for the minimum
+// batch size set by WithMinBatchEvents or WithMinBatchBytes. It is checked
+// each flush interval, so an event may be held up to one flush interval
+// longer. The default is 1 minute.
+func WithMaxFlushDelay(d time.Duration) Option {
+	return func(w *LogWriter) {
+		if d > 0 {
+			w.maxFlushDelay = d
+		}
+	}
+}
+
+// WithRequestRate sets the maximum number of PutLogEvents calls made per
+// second. Flushes block until they are allowed to proceed. The default is 5,
+// which is the CloudWatch Logs quota for a single log stream. A value of zero
+// disables rate limiting.
+func WithRequestRate(perSecond int) Option {
+	return func(w *LogWriter) {
+		w.limiter = newLimiter(perSecond)
+	}
+}
+
+// WithMaxThroughput sets the maximum number of bytes sent to CloudWatch Logs
+// per second, e.g. to avoid saturating a small instance's network during a
+// large backfill. Flushes block until their batch is allowed through; nothing
+// is dropped. Bursts of up to one second's worth of bytes are sent
+// immediately. A value of zero, the default, disables the limit.
+func WithMaxThroughput(bytesPerSecond int) Option {
+	return func(w *LogWriter) {
+		w.byteLimiter = newByteLimiter(bytesPerSecond)
+	}
+}
+
+// WithTruncateLargeEvents causes lines larger than the CloudWatch Logs limit
+// of 256KB per event to be truncated and marked with "…[truncated]". By
+// default, such lines are split into multiple contiguous events.
+func WithTruncateLargeEvents() Option {
+	return func(w *LogWriter) {
+		w.truncate = true
+	}
+}
+
+// WithNeverSplitLines guarantees that each line, or each record produced by
+// the split function, is sent as exactly one event, for consumers such as JSON
+// parsers that can't handle part of a line. A line larger than the CloudWatch
+// Logs limit of 256KB per event is truncated, as with WithTruncateLargeEvents,
+// instead of being split. A split function that cuts lines itself, such as
+// ScanChunks, isn't affected.
+func WithNeverSplitLines(enabled bool) Option {
+	return func(w *LogWriter) {
+		w.neverSplit = enabled
+	}
+}
+
+// WithErrorCooldown sets how long the writer waits after a failed flush before
+// attempting to send log events again. By default, a failed flush stops the
+// writer from sending any more events until Reset is called.
+func WithErrorCooldown(d time.Duration) Option {
+	return func(w *LogWriter) {
+		w.errCooldown = d
+	}
+}
+
+// WithCircuitBreaker stops the writer from attempting flushes for cooldown
+// after failures consecutive flushes have failed, so that an extended outage
+// doesn't cost every flush its full set of retries. Flushes fail with
+// ErrCircuitOpen in the meantime, which isn't passed to the error handler,
+// and events are buffered up to the limit set by WithMaxBufferBytes. Once the
+// cooldown has elapsed, a single flush probes CloudWatch Logs: if it
+// succeeds, flushing resumes, and if it fails, the cooldown starts again.
+// Close also fails while the breaker is open.
+//
+// A failed flush doesn't stop a writer with a circuit breaker, so Reset and
+// WithErrorCooldown have no effect. A failures value of zero, the default,
+// disables the breaker.
+func WithCircuitBreaker(failures int, cooldown time.Duration) Option {
+	return func(w *LogWriter) {
+		w.breaker = newBreaker(failures, cooldown)
+	}
+}
+
+// WithErrorHandler registers a function that is called whenever a background
+// flush fails. Flushes happen on a separate goroutine, so without a handler
+// these errors are not visible until Close. The handler is called from the
+// flushing goroutine and should not block.
+func WithErrorHandler(f func(error)) Option {
+	return func(w *LogWriter) {
+		w.errorHandler = f
+	}
+}
+
+// WithDeadLetterFile sets a file to which log events are appended if they
+// can't be delivered: batches dropped after a failed flush, and whatever is
+// left in the buffer when Close fails or times out. Each event is written as
+// a JSON object with message and timestamp fields on a line of its own, so
+// that it can be replayed later. Events still being sent when
+// CloseWithTimeout gives up are only written if that send fails.
+func WithDeadLetterFile(path string) Option {
+	return func(w *LogWriter) {
+		w.deadLetterFile = path
+	}
+}
+
+// WithOnFlush registers a function that is called after each successful
+// PutLogEvents call with the number of events in the batch, their size in
+// bytes including per-event overhead, and the sequence token CloudWatch Logs
+// returned, if any. Events in the batch that CloudWatch Logs rejected are
+// included, since they will never be sent. It can be used to checkpoint
+// progress, e.g. a file offset, once events have been delivered.
+//
+// f is never called concurrently, and the flush waits for it to return. With
+// WithFlushConcurrency, batches may be confirmed in a different order than
+// their events were written.
+func WithOnFlush(f func(events, bytes int, token string)) Option {
+	return func(w *LogWriter) {
+		w.onFlush = f
+	}
+}
+
+// retentionDays is the set of retention periods accepted by CloudWatch Logs
+//
+// https://docs.aws.amazon.com/AmazonCloudWatchLogs/latest/APIReference/API_PutRetentionPolicy.html
+var retentionDays = []int{
+	1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731,
+	1096, 1827, 2192, 2557, 2922, 3288, 3653,
+}
+
+// ValidRetentionDays reports whether days is a retention period accepted by
+// CloudWatch Logs
+func ValidRetentionDays(days int) bool {
+	for _, d := range retentionDays {
+		if d == days {
+			return true
+		}
+	}
+	return false
+}
+
+// WithRetentionDays sets the retention policy, in days, applied to the log
+// group if the writer has to create it. Pre-existing log groups are never
+// modified. days should be one of the values accepted by CloudWatch Logs;
+// see ValidRetentionDays.
+func WithRetentionDays(days int) Option {
+	return func(w *LogWriter) {
+		w.retentionDays = days
+	}
+}
+
+// WithTags sets the tags applied to the log group if the writer has to create
+// it. Pre-existing log groups are never modified.
+func WithTags(tags map[string]*string) Option {
+	return func(w *LogWriter) {
+		w.tags = tags
+	}
+}
+
+// WithKMSKeyID sets the ARN of the KMS key used to encrypt the log group if
+// the writer has to create it. Pre-existing log groups are never modified.
+func WithKMSKeyID(id string) Option {
+	return func(w *LogWriter) {
+		w.kmsKeyID = id
+	}
+}
+
+// WithTimestampFormat causes the writer to parse a timestamp in the given
+// time layout (see the time package) from the beginning of each line and use
+// it as the event's timestamp. Lines that don't begin with a matching
+// timestamp are stamped with the current time. The line itself is sent
+// unmodified. Timestamps without a zone are read in local time, and those
+// without a year are placed in the most recent year that doesn't put them
+// more than a day in the future.
+func WithTimestampFormat(layout string) Option {
+	return func(w *LogWriter) {
+		w.timestampParsers = append(w.timestampParsers, leadingTimestamp(layout, time.Local, time.Now))
+	}
+}
+
+// WithJSONTimestampField causes the writer to read each event's timestamp
+// from the named top-level field of lines that are JSON objects. The field
+// may hold an RFC3339 string or a number of milliseconds since the epoch.
+// Lines that aren't JSON or lack the field are stamped with the current time.
+// The line itself is sent unmodified.
+func WithJSONTimestampField(field string) Option {
+	return func(w *LogWriter) {
+		w.timestampParsers = append(w.timestampParsers, jsonTimestamp(field))
+	}
+}
+
+// WithTimestampColumn causes the writer to split each line on sep and read
+// the event's timestamp from the column at index column, counting from 1. The
+// column may hold a number of milliseconds since the epoch or an RFC3339
+// timestamp, and it is removed from the message, along with a separator. A
+// line with too few columns, or whose column doesn't hold a timestamp, is
+// stamped with the current time and sent unmodified. The timestamp column
+// takes precedence over WithTimestampFormat and WithJSONTimestampField.
+func WithTimestampColumn(column int, sep string) Option {
+	return func(w *LogWriter) {
+		if column > 0 && sep != "" {
+			w.timestampColumn = &timestampColumn{column: column - 1, sep: sep}
+		}
+	}
+}
+
+// WithMultilinePattern causes lines that don't match start to be appended,
+// separated by a newline, to the preceding event rather than sent as events of
+// their own. start should match the first line of each logical event, e.g. a
+// leading timestamp, so that stack traces and other multi-line output are
+// kept together. An event is held until the next one begins, the input ends,
+// or no line has been added to it for a whole flush interval, and one that
+// would exceed the per-event size limit is sent early.
+func WithMultilinePattern(start *regexp.Regexp) Option {
+	return func(w *LogWriter) {
+		w.multiline = &multiline{start: start, limit: maxEventSize - eventSize}
+	}
+}
+
+// WithCompressRepeated collapses runs of identical consecutive messages, such
+// as those from a retry loop, into a single event of the form "(repeated 1423
+// times) message", stamped with the time of the first. Each message is held
+// until a different one arrives or window elapses, which is checked at each
+// periodic flush, so a run of repeats is sent at least once per window. A
+// window that isn't positive disables collapsing.
+func WithCompressRepeated(window time.Duration) Option {
+	return func(w *LogWriter) {
+		if window > 0 {
+			w.repeats = &repeats{window: window.Milliseconds()}
+		}
+	}
+}
+
+// WithSplitFunc sets the function used to split input into records, each of
+// which is sent as a single event. The default is bufio.ScanLines. See
+// ScanDelimiter, ScanJSON, and ScanChunks for input that isn't line-oriented.
+func WithSplitFunc(split bufio.SplitFunc) Option {
+	return func(w *LogWriter) {
+		if split != nil {
+			w.split = split
+		}
+	}
+}
+
+// WithMaxBufferBytes limits the size of the events buffered by the writer,
+// including per-event overhead. When an event doesn't fit, the writer's
+// OverflowPolicy decides what happens; see WithOverflowPolicy. By default the
+// buffer is unbounded, so if CloudWatch Logs is unreachable for long enough
+// it grows until the process runs out of memory.
+func WithMaxBufferBytes(n int) Option {
+	return func(w *LogWriter) {
+		if n > 0 {
+			w.maxBufferBytes = n
+		}
+	}
+}
+
+// WithOverflowPolicy sets what the writer does when the buffer limit set by
+// WithMaxBufferBytes is reached. The default is DropOldest.
+func WithOverflowPolicy(p OverflowPolicy) Option {
+	return func(w *LogWriter) {
+		w.overflow = p
+	}
+}
+
+// WithBackpressure stops the writer from reading input once high bytes of
+// events are buffered, until flushes have drained the buffer to low bytes.
+// Since input is delivered through a pipe, Write blocks in the meantime,
+// slowing the producer to the rate at which events can be sent. Unlike the
+// Block overflow policy, reading doesn't resume as soon as there is room for
+// one more event, so the producer is woken less often. low is clamped to the
+// range [0, high).
+func WithBackpressure(high, low int) Option {
+	return func(w *LogWriter) {
+		if high <= 0 {
+			return
+		}
+		if low >= high {
+			low = high - 1
+		}
+		if low < 0 {
+			low = 0
+		}
+		w.highWater, w.lowWater = high, low
+	}
+}
+
+// WithHeartbeat causes the writer to send message as an event whenever the
+// stream has been idle for at least interval, i.e. no events have been written
+// or flushed. This keeps the stream's last ingestion time fresh for monitors
+// that alarm on silence. Idleness is checked each flush interval, so the
+// heartbeat may be sent up to one flush interval late. The message is sent as
+// is, without the filters, redaction, prefix, or other changes applied to
+// written lines.
+func WithHeartbeat(interval time.Duration, message string) Option {
+	return func(w *LogWriter) {
+		w.heartbeatInterval = interval
+		w.heartbeatMessage = message
+	}
+}
+
+// WithKeepCarriageReturn keeps the carriage return at the end of a record
+// written to the writer that ends in CRLF, as output produced on Windows does.
+// By default it is removed, since it is almost never wanted in an event. It
+// should be set with a split function such as ScanChunks, whose records may
+// end in a carriage return that is part of the data. bufio.ScanLines, the
+// default split function, removes the carriage return itself, so this has no
+// effect with it. Events given to AddEvent and WriteEvent are never changed.
+func WithKeepCarriageReturn() Option {
+	return func(w *LogWriter) {
+		w.keepCR = true
+	}
+}
+
+// WithStripANSI causes ANSI escape sequences, such as those used to color
+// terminal output, to be removed from each line before it is sent.
+func WithStripANSI() Option {
+	return func(w *LogWriter) {
+		w.stripANSI = true
+	}
+}
+
+// WithTrimSpace controls whether leading and trailing whitespace is removed
+// from each line before it is sent. Lines left empty by trimming are dropped.
+// By default, lines are sent as they are, and an empty line is sent as a
+// single NUL character, since CloudWatch Logs doesn't accept empty events.
+func WithTrimSpace(enabled bool) Option {
+	return func(w *LogWriter) {
+		w.trimSpace = enabled
+	}
+}
+
+// WithInclude causes only lines matching re to be sent. Other lines are
+// discarded.
+func WithInclude(re *regexp.Regexp) Option {
+	return func(w *LogWriter) {
+		w.include = re
+	}
+}
+
+// WithExclude causes lines matching re to be discarded. If WithInclude is
+// also used, a line must match its pattern and not this one to be sent.
+func WithExclude(re *regexp.Regexp) Option {
+	return func(w *LogWriter) {
+		w.exclude = re
+	}
+}
+
+// WithRedactor causes text matching re to be replaced with "***" in every
+// line before it is buffered. It may be given more than once. See
+// AWSAccessKeyPattern and EmailPattern for common patterns.
+func WithRedactor(re *regexp.Regexp) Option {
+	return func(w *LogWriter) {
+		w.redactors = append(w.redactors, re)
+	}
+}
+
+// WithMaxLineBytes causes lines longer than n bytes to be cut off at n bytes
+// and marked with "…[truncated N bytes]", where N is the number of bytes
+// dropped. Lines are only cut between UTF-8 characters. If the result is
+// still larger than the per-event limit, it is split or truncated as usual.
+func WithMaxLineBytes(n int) Option {
+	return func(w *LogWriter) {
+		if n > 0 {
+			w.maxLineBytes = n
+		}
+	}
+}
+
+// WithPrefix causes s to be prepended to every line, e.g. to identify the
+// host or environment the line came from.
+func WithPrefix(s string) Option {
+	return func(w *LogWriter) {
+		w.prefix = s
+	}
+}
+
+// WithSuffix causes s to be appended to every line.
+func WithSuffix(s string) Option {
+	return func(w *LogWriter) {
+		w.suffix = s
+	}
+}
+
+// WithEnrichment annotates every event with the hostname and process ID of
+// the writer, in the given format. They are looked up once, when the writer
+// is created. JSON envelopes are never split across events; a message too
+// large to fit in one is truncated instead.
+func WithEnrichment(format EnrichFormat) Option {
+	return func(w *LogWriter) {
+		w.enricher = newEnricher(format)
+	}
+}
+
+// WithEMF wraps JSON log lines containing any of metrics in the CloudWatch
+// Embedded Metric Format, so that CloudWatch extracts them as metrics in
+// namespace. The named dimensions are attached to the metrics when they hold
+// string values. The original line is kept in the event's message field, and
+// lines that aren't JSON objects or contain none of the metrics are sent
+// unchanged. Prefixes, suffixes, and enrichment are applied to the envelope,
+// so they should not be combined with this option.
+func WithEMF(namespace string, metrics []EMFMetric, dimensions ...string) Option {
+	return func(w *LogWriter) {
+		w.emf = &emf{namespace: namespace, metrics: metrics, dimensions: dimensions}
+	}
+}
+
+// WithJSONOutput wraps each line that isn't already valid JSON in an object
+// of the form {"@timestamp":1600000000000,"level":"ERROR","message":"..."},
+// so that its fields can be queried separately. The timestamp is the event's,
+// in milliseconds. If level is not nil, the level field holds the text it
+// matches in the line, or the text matched by its first capturing group if it
+// has one; otherwise the field is left out. The prefix and suffix are part of
+// the message, and like enriched events, the objects are never split. JSON
+// lines are sent as they are, apart from the prefix and suffix.
+func WithJSONOutput(level *regexp.Regexp) Option {
+	return func(w *LogWriter) {
+		w.jsonOutput = &jsonOutput{level: level}
+	}
+}
+
+// WithSequenceTokens controls whether the writer tracks and sends the sequence
+// token returned by each PutLogEvents call. CloudWatch Logs no longer requires
+// sequence tokens, so by default they are not sent, and the errors used to
+// resynchronize them are not expected. Enable them for endpoints that still
+// require them.
+func WithSequenceTokens(enabled bool) Option {
+	return func(w *LogWriter) {
+		w.sequenceTokens = enabled
+	}
+}
+
+// WithAutoCreate controls whether the writer creates the log group and log
+// stream if they don't exist. It does by default. When disabled, writing to a
+// missing log group or log stream fails with an error saying so, and no
+// CreateLogGroup or CreateLogStream calls are made.
+func WithAutoCreate(enabled bool) Option {
+	return func(w *LogWriter) {
+		w.noCreate = !enabled
+	}
+}
+
+// WithFlushConcurrency allows up to n PutLogEvents calls to be in flight at
+// once, which speeds up sending a large backlog, e.g. when closing the writer
+// after an outage. Requests made with sequence tokens must be sent in order,
+// so this has no effect if WithSequenceTokens is enabled. The default is 1.
+func WithFlushConcurrency(n int) Option {
+	return func(w *LogWriter) {
+		w.flushConcurrency = n
+	}
+}
+
+// WithClock sets the function used to timestamp events that don't carry their
+// own timestamp. It returns the current time in milliseconds since the epoch.
+// By default, the system clock is used.
+func WithClock(clock func() int64) Option {
+	return func(w *LogWriter) {
+		w.clock = clock
+	}
+}
+
+// WithTokenStore causes the writer to load its initial sequence token from
+// store and save the latest token after each successful flush. If the loaded
+// token is stale, it is replaced the first time CloudWatch Logs rejects it.
+// Errors saving the token are reported to the error handler. It has no effect
+// unless WithSequenceTokens is enabled.
+func WithTokenStore(store TokenStore) Option {
+	return func(w *LogWriter) {
+		w.tokenStore = store
+	}
+}
+
+// WithInitialSequenceToken sets the sequence token sent with the first
+// PutLogEvents call, for callers that already know the stream's current token,
+// e.g. from DescribeLogStreams. It takes precedence over a token loaded by
+// WithTokenStore. If the token is stale, it is replaced the first time
+// CloudWatch Logs rejects it. It has no effect unless WithSequenceTokens is
+// enabled.
+func WithInitialSequenceToken(token string) Option {
+	return func(w *LogWriter) {
+		w.sequenceToken = token
+	}
+}
+
+// WithLogger sends diagnostic messages about the writer's activity to l. By
+// default, the writer logs nothing.
+func WithLogger(l Logger) Option {
+	return func(w *LogWriter) {
+		w.logger = l
+	}
+}
+
+// WithMetrics reports the writer's delivery of log events to m, such as the
+// number of events sent and dropped and the size and latency of each batch.
+// The prommetrics module provides an implementation that exports them to
+// Prometheus.
+func WithMetrics(m Metrics) Option {
+	return func(w *LogWriter) {
+		w.metrics = m
+	}
+}
@@ -0,0 +1,83 @@
+package writer
+
+// OverflowPolicy determines what a LogWriter does when its buffer is full
+type OverflowPolicy int
+
+const (
+	// DropOldest discards the oldest buffered events to make room for new
+	// ones. Discarded events are counted in Stats.DroppedEvents. This is the
+	// default policy
+	DropOldest OverflowPolicy = iota
+
+	// Block holds up new events until a flush makes room for them. Since
+	// input is delivered through a pipe, Write blocks as well, applying
+	// backpressure to the producer
+	Block
+)
+
+// String implements fmt.Stringer
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop-oldest"
+	case Block:
+		return "block"
+	}
+	return "unknown"
+}
+
+// waitForSpace blocks until size more bytes fit in the buffer, or the writer
+// is stopped. The caller must hold the lock. The buffer is allowed to grow
+// past the limit when it is empty, so that an event larger than the limit
+// can still be sent.
+func (w *LogWriter) waitForSpace(size int) {
+	for w.maxBufferBytes > 0 && len(w.buf) > 0 && w.bufSize+size > w.maxBufferBytes && !w.stopped() {
+		w.triggerFlush()
+		w.bufCond.Wait()
+	}
+}
+
+// waitForDrain blocks while the buffer is at or above the high-water mark set
+// by WithBackpressure, until it drains to the low-water mark or the writer is
+// stopped
+func (w *LogWriter) waitForDrain() {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.highWater <= 0 || w.bufSize < w.highWater {
+		return
+	}
+	for w.bufSize > w.lowWater && !w.stopped() {
+		w.triggerFlush()
+		w.bufCond.Wait()
+	}
+}
+
+// dropOldest discards events from the front of the buffer until it is within
+// the limit. The newest event is always kept. The caller must hold the lock.
+func (w *LogWriter) dropOldest() {
+	var n int
+	for w.maxBufferBytes > 0 && w.bufSize > w.maxBufferBytes && n < len(w.buf)-1 {
+		w.bufSize -= len(*w.buf[n].Message) + eventSize
+		n++
+	}
+
+	releaseEvents(w.buf[:n])
+	for i := 0; i < n; i++ {
+		w.buf[i] = nil
+	}
+	w.buf = w.buf[n:]
+	w.recordDropped(n)
+}
+
+// stopped reports whether the writer has been closed or its context cancelled
+func (w *LogWriter) stopped() bool {
+	select {
+	case <-w.closed:
+		return true
+	case <-w.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,76 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+var (
+	// ErrLogGroupNotFound is returned by Preflight if the log group doesn't
+	// exist and creating it is disabled
+	ErrLogGroupNotFound = errors.New("log group does not exist")
+
+	// ErrLogStreamNotFound is returned by Preflight if the log stream doesn't
+	// exist and creating it is disabled
+	ErrLogStreamNotFound = errors.New("log stream does not exist")
+
+	// ErrAccessDenied is returned by Preflight if the caller isn't allowed to
+	// describe or create the log group or log stream
+	ErrAccessDenied = errors.New("access denied")
+)
+
+// Preflight checks that the log group and log stream exist and that the
+// caller may use them, so that a misconfigured writer fails as soon as it
+// starts instead of on its first flush. It looks the stream up with
+// DescribeLogStreams, and if the stream or its group is missing, creates it
+// now unless creating them is disabled with WithAutoCreate. The error wraps
+// ErrLogGroupNotFound, ErrLogStreamNotFound, or ErrAccessDenied if one of those
+// is the cause. Permission to call PutLogEvents can't be checked without
+// sending an event, so a flush may still be denied.
+func (w *LogWriter) Preflight(ctx context.Context) error {
+	w.logf("checking log stream %s/%s", w.logGroup, w.logStream)
+	out, err := w.logsClient.DescribeLogStreamsWithContext(ctx, &cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        &w.logGroup,
+		LogStreamNamePrefix: &w.logStream,
+		Limit:               aws.Int64(1),
+	})
+
+	// streams are listed by name, so if the stream exists it comes first
+	var groupMissing bool
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
+		groupMissing = true
+	} else if err != nil {
+		return preflightError(err)
+	} else if out != nil && len(out.LogStreams) > 0 && aws.StringValue(out.LogStreams[0].LogStreamName) == w.logStream {
+		return nil
+	}
+
+	if w.noCreate && groupMissing {
+		return fmt.Errorf("%w: %s", ErrLogGroupNotFound, w.logGroup)
+	} else if w.noCreate {
+		return fmt.Errorf("%w: %s/%s", ErrLogStreamNotFound, w.logGroup, w.logStream)
+	}
+
+	// errIgnore means the log group had to be created first
+	err = w.createLogStream(ctx)
+	if err == errIgnore {
+		err = w.createLogStream(ctx)
+	}
+	if err == errIgnore {
+		return fmt.Errorf("%w: %s", ErrLogGroupNotFound, w.logGroup)
+	}
+	return preflightError(err)
+}
+
+// preflightError wraps err with ErrAccessDenied if the request was denied
+func preflightError(err error) error {
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudwatchlogs.ErrCodeAccessDeniedException {
+		return fmt.Errorf("%w: %v", ErrAccessDenied, err)
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+func TestWriterPreflight(t *testing.T) {
+	errDenied := awserr.New(cloudwatchlogs.ErrCodeAccessDeniedException, "not authorized", nil)
+	errUnavailable := awserr.New(cloudwatchlogs.ErrCodeServiceUnavailableException, "unavailable", nil)
+
+	cases := []struct {
+		name        string
+		noGroup     bool
+		noStream    bool
+		noCreate    bool
+		describeErr error
+		expected    error
+		groups      int
+		streams     int
+	}{
+		{name: "exists"},
+		{name: "missing stream", noStream: true, streams: 1},
+		{name: "missing group", noGroup: true, noStream: true, groups: 1, streams: 2},
+		{name: "missing stream without create", noStream: true, noCreate: true, expected: ErrLogStreamNotFound},
+		{name: "missing group without create", noGroup: true, noStream: true, noCreate: true, expected: ErrLogGroupNotFound},
+		{name: "access denied", describeErr: errDenied, expected: ErrAccessDenied},
+		{name: "other error", describeErr: errUnavailable, expected: errUnavailable},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			logsClient := newLogsCLientTest()
+			logsClient.NoGroup = c.noGroup
+			logsClient.NoStream = c.noStream
+			logsClient.DescribeErr = c.describeErr
+
+			w := New("group", "stream", logsClient, WithAutoCreate(!c.noCreate))
+			defer w.Close()
+
+			err := w.Preflight(context.Background())
+			if !errors.Is(err, c.expected) || (c.expected == nil) != (err == nil) {
+				t.Errorf("unexpected error: got=%v want=%v", err, c.expected)
+			}
+			if len(logsClient.CreatedGroups) != c.groups || len(logsClient.CreatedStreams) != c.streams {
+				t.Errorf("unexpected creates: groups=%d streams=%d want groups=%d streams=%d",
+					len(logsClient.CreatedGroups), len(logsClient.CreatedStreams), c.groups, c.streams)
+			}
+		})
+	}
+}
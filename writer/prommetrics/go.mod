@@ -0,0 +1,8 @@
+module github.com/kylemcc/cwlog/writer/prommetrics
+
+go 1.14
+
+require (
+	github.com/kylemcc/cwlog v0.1.2
+	github.com/prometheus/client_golang v1.11.1
+)
@@ -0,0 +1,105 @@
+// Package prommetrics exports the delivery metrics of a writer.LogWriter to
+// Prometheus.
+//
+// It is a separate module, so that the writer package doesn't depend on
+// Prometheus.
+package prommetrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector implements writer.Metrics by updating Prometheus metrics. It is
+// also a prometheus.Collector, so it can be registered to export them:
+//
+//	c := prommetrics.New(prometheus.Labels{"stream": "app"})
+//	prometheus.MustRegister(c)
+//	w := writer.New(group, stream, client, writer.WithMetrics(c))
+type Collector struct {
+	sent         prometheus.Counter
+	dropped      prometheus.Counter
+	retries      prometheus.Counter
+	batchBytes   prometheus.Histogram
+	flushLatency prometheus.Histogram
+}
+
+// New returns a Collector whose metrics carry labels, which may be nil. When
+// several writers are registered with the same registry, labels tell them
+// apart.
+func New(labels prometheus.Labels) *Collector {
+	return &Collector{
+		sent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "cwlog_sent_events_total",
+			Help:        "Log events delivered to CloudWatch Logs.",
+			ConstLabels: labels,
+		}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "cwlog_dropped_events_total",
+			Help:        "Log events that will never be delivered to CloudWatch Logs.",
+			ConstLabels: labels,
+		}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "cwlog_retries_total",
+			Help:        "Retried PutLogEvents calls.",
+			ConstLabels: labels,
+		}),
+		batchBytes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "cwlog_batch_bytes",
+			Help:        "Size of each batch of log events sent to CloudWatch Logs.",
+			ConstLabels: labels,
+			// 1KiB to the 1MiB batch limit
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 6),
+		}),
+		flushLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "cwlog_flush_duration_seconds",
+			Help:        "Time taken to send each batch of log events, including retries.",
+			ConstLabels: labels,
+			Buckets:     prometheus.DefBuckets,
+		}),
+	}
+}
+
+// IncSent implements writer.Metrics
+func (c *Collector) IncSent(n int) {
+	c.sent.Add(float64(n))
+}
+
+// IncDropped implements writer.Metrics
+func (c *Collector) IncDropped(n int) {
+	c.dropped.Add(float64(n))
+}
+
+// IncRetries implements writer.Metrics
+func (c *Collector) IncRetries(n int) {
+	c.retries.Add(float64(n))
+}
+
+// ObserveBatchBytes implements writer.Metrics
+func (c *Collector) ObserveBatchBytes(n int) {
+	c.batchBytes.Observe(float64(n))
+}
+
+// ObserveFlushLatency implements writer.Metrics
+func (c *Collector) ObserveFlushLatency(d time.Duration) {
+	c.flushLatency.Observe(d.Seconds())
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics() {
+		m.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.metrics() {
+		m.Collect(ch)
+	}
+}
+
+func (c *Collector) metrics() []prometheus.Collector {
+	return []prometheus.Collector{c.sent, c.dropped, c.retries, c.batchBytes, c.flushLatency}
+}
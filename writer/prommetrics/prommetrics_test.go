@@ -0,0 +1,52 @@
+package prommetrics_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/kylemcc/cwlog/writer"
+	"github.com/kylemcc/cwlog/writer/prommetrics"
+)
+
+// the collector must satisfy the writer's interface
+var _ writer.Metrics = (*prommetrics.Collector)(nil)
+
+func TestCollector(t *testing.T) {
+	c := prommetrics.New(prometheus.Labels{"stream": "app"})
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c.IncSent(3)
+	c.IncDropped(1)
+	c.IncRetries(2)
+	c.ObserveBatchBytes(2048)
+	c.ObserveFlushLatency(20 * time.Millisecond)
+
+	expected := `
+# HELP cwlog_sent_events_total Log events delivered to CloudWatch Logs.
+# TYPE cwlog_sent_events_total counter
+cwlog_sent_events_total{stream="app"} 3
+# HELP cwlog_dropped_events_total Log events that will never be delivered to CloudWatch Logs.
+# TYPE cwlog_dropped_events_total counter
+cwlog_dropped_events_total{stream="app"} 1
+# HELP cwlog_retries_total Retried PutLogEvents calls.
+# TYPE cwlog_retries_total counter
+cwlog_retries_total{stream="app"} 2
+`
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"cwlog_sent_events_total", "cwlog_dropped_events_total", "cwlog_retries_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if n := testutil.CollectAndCount(c, "cwlog_batch_bytes", "cwlog_flush_duration_seconds"); n != 2 {
+		t.Errorf("unexpected number of histograms: %d", n)
+	}
+}
@@ -0,0 +1,23 @@
+package writer
+
+import "regexp"
+
+// redactedMask replaces text matched by a redaction pattern
+const redactedMask = "***"
+
+var (
+	// AWSAccessKeyPattern matches AWS access key IDs
+	AWSAccessKeyPattern = regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)
+
+	// EmailPattern matches email addresses
+	EmailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+)
+
+// redact replaces every match of the writer's redaction patterns in text
+// with redactedMask
+func (w *LogWriter) redact(text string) string {
+	for _, re := range w.redactors {
+		text = re.ReplaceAllLiteralString(text, redactedMask)
+	}
+	return text
+}
@@ -0,0 +1,88 @@
+package writer
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// RejectedEventsError is returned when CloudWatch Logs accepts a batch of log
+// events but declines to store some of them. The rest of the batch was stored,
+// so the writer keeps running. Flush returns one for each batch with rejected
+// events, the error handler receives one for each background flush, and Close
+// returns one covering every batch sent by the writer.
+type RejectedEventsError struct {
+	// RejectedEvents holds the number of rejected events across all batches
+	RejectedEvents
+
+	// Batches describes each batch with rejected events, in the order they
+	// were sent
+	Batches []RejectedBatch
+}
+
+// RejectedBatch describes which events in a batch were rejected. Rejected
+// events are always at the start or the end of a batch.
+type RejectedBatch struct {
+	// Size is the number of events in the batch
+	Size int
+
+	// TooOldEnd is the index of the first event that wasn't too old
+	TooOldEnd int
+
+	// TooNewStart is the index of the first event that was too new, or Size
+	// if none were
+	TooNewStart int
+
+	// ExpiredEnd is the index of the first event that wasn't expired
+	ExpiredEnd int
+}
+
+func (e *RejectedEventsError) Error() string {
+	return fmt.Sprintf("CloudWatch Logs rejected %d log events (too old: %d, too new: %d, expired: %d)",
+		e.Total(), e.TooOld, e.TooNew, e.Expired)
+}
+
+// add merges the rejected events from other into e
+func (e *RejectedEventsError) add(other *RejectedEventsError) {
+	e.TooOld += other.TooOld
+	e.TooNew += other.TooNew
+	e.Expired += other.Expired
+	e.Batches = append(e.Batches, other.Batches...)
+}
+
+// newRejectedBatch describes the rejected events in a batch of n events
+func newRejectedBatch(info *cloudwatchlogs.RejectedLogEventsInfo, n int) RejectedBatch {
+	b := RejectedBatch{Size: n, TooNewStart: n}
+	if info.TooOldLogEventEndIndex != nil {
+		b.TooOldEnd = int(*info.TooOldLogEventEndIndex)
+	}
+	if info.TooNewLogEventStartIndex != nil {
+		b.TooNewStart = int(*info.TooNewLogEventStartIndex)
+	}
+	if info.ExpiredLogEventEndIndex != nil {
+		b.ExpiredEnd = int(*info.ExpiredLogEventEndIndex)
+	}
+	return b
+}
+
+// counts returns the number of events in the batch rejected for each reason
+func (b RejectedBatch) counts() RejectedEvents {
+	return RejectedEvents{
+		TooOld:  b.TooOldEnd,
+		TooNew:  b.Size - b.TooNewStart,
+		Expired: b.ExpiredEnd,
+	}
+}
+
+// distinct returns the number of distinct events in the batch that were
+// rejected, since an event may be both too old and expired
+func (b RejectedBatch) distinct() int {
+	head, tail := b.TooOldEnd, b.TooNewStart
+	if b.ExpiredEnd > head {
+		head = b.ExpiredEnd
+	}
+	if head >= tail {
+		return b.Size
+	}
+	return head + b.Size - tail
+}
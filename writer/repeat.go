@@ -0,0 +1,123 @@
+package writer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// repeats collapses runs of identical consecutive messages into a single
+// event annotated with the number of times the message was repeated, like
+// uniq -c. Each message is held until a different one arrives or window
+// elapses, so that a run is never held indefinitely. Messages are compared
+// before they are wrapped in JSON or prefixed, so the count can be added in
+// whichever form the event is sent.
+type repeats struct {
+	sync.Mutex
+
+	// window is how long, in milliseconds, a message may be held
+	window int64
+
+	held  run
+	since int64
+}
+
+// run is a message, the timestamp of its first occurrence, and the number of
+// times it occurred in a row
+type run struct {
+	msg   string
+	ts    int64
+	count int
+}
+
+// add records text, with timestamp ts, at time now. If text ends the run of
+// the held message, or the held message has been held for the whole window,
+// the held run is returned, and text is held in its place. Otherwise ok is
+// false.
+func (r *repeats) add(text string, ts, now int64) (prev run, ok bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.held.count > 0 && text == r.held.msg && now-r.since < r.window {
+		r.held.count++
+		return run{}, false
+	}
+
+	prev, ok = r.take()
+	r.held, r.since = run{msg: text, ts: ts, count: 1}, now
+	return prev, ok
+}
+
+// expire returns the held run if it has been held for the whole window by now
+func (r *repeats) expire(now int64) (run, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.held.count == 0 || now-r.since < r.window {
+		return run{}, false
+	}
+	return r.take()
+}
+
+// flush returns the held run, if any
+func (r *repeats) flush() (run, bool) {
+	r.Lock()
+	defer r.Unlock()
+	return r.take()
+}
+
+// take returns the held run and clears it. The caller must hold the lock.
+func (r *repeats) take() (run, bool) {
+	if r.held.count == 0 {
+		return run{}, false
+	}
+
+	held := r.held
+	r.held = run{}
+	return held, true
+}
+
+// annotateRepeated prefixes a plain-text message with the number of times it
+// was repeated
+func annotateRepeated(msg string, count int) string {
+	return fmt.Sprintf("(repeated %d times) %s", count, msg)
+}
+
+// withRepeatedField adds a repeated field holding count to obj, if it is a
+// JSON object. The field is inserted as text, so that the object's other
+// fields keep their order and formatting.
+func withRepeatedField(obj string, count int) (string, bool) {
+	trimmed := strings.TrimSpace(obj)
+	if !strings.HasPrefix(trimmed, "{") || !json.Valid([]byte(trimmed)) {
+		return obj, false
+	}
+
+	field := fmt.Sprintf(`"repeated":%d`, count)
+	rest := strings.TrimSpace(trimmed[1:])
+	if !strings.HasPrefix(rest, "}") {
+		field += ","
+	}
+	return "{" + field + rest, true
+}
+
+// expireRepeats buffers the held run if it has been held for the whole
+// window. It is called on each tick of the periodic flush.
+func (w *LogWriter) expireRepeats() {
+	if w.repeats == nil {
+		return
+	}
+	if r, ok := w.repeats.expire(w.now()); ok {
+		w.wrapEvent(r.msg, r.ts, true, r.count)
+	}
+}
+
+// flushRepeats buffers the held run, if any, once no more input can arrive
+func (w *LogWriter) flushRepeats() {
+	if w.repeats == nil {
+		return
+	}
+	if r, ok := w.repeats.flush(); ok {
+		w.wrapEvent(r.msg, r.ts, true, r.count)
+	}
+}
@@ -0,0 +1,190 @@
+package writer
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+func TestRepeats(t *testing.T) {
+	r := &repeats{window: 100}
+
+	if _, ok := r.add("a", 1, 10); ok {
+		t.Fatal("a run was returned before anything was held")
+	}
+	for now := int64(11); now < 20; now++ {
+		if _, ok := r.add("a", now, now); ok {
+			t.Fatal("a repeated message ended the run")
+		}
+	}
+
+	got, ok := r.add("b", 20, 20)
+	if expected := (run{msg: "a", ts: 1, count: 10}); !ok || got != expected {
+		t.Errorf("unexpected run: got=%+v ok=%v want=%+v", got, ok, expected)
+	}
+
+	// the window is measured from the first message of the run
+	if _, ok := r.expire(119); ok {
+		t.Error("run expired before the window elapsed")
+	}
+	got, ok = r.expire(120)
+	if expected := (run{msg: "b", ts: 20, count: 1}); !ok || got != expected {
+		t.Errorf("unexpected run: got=%+v ok=%v want=%+v", got, ok, expected)
+	}
+	if _, ok := r.flush(); ok {
+		t.Error("an expired run was returned twice")
+	}
+
+	// a run held for the whole window is sent, and a new one begins
+	r.add("c", 200, 200)
+	r.add("c", 250, 250)
+	got, ok = r.add("c", 300, 300)
+	if expected := (run{msg: "c", ts: 200, count: 2}); !ok || got != expected {
+		t.Errorf("unexpected run: got=%+v ok=%v want=%+v", got, ok, expected)
+	}
+	got, ok = r.flush()
+	if expected := (run{msg: "c", ts: 300, count: 1}); !ok || got != expected {
+		t.Errorf("unexpected run: got=%+v ok=%v want=%+v", got, ok, expected)
+	}
+}
+
+func TestWithRepeatedField(t *testing.T) {
+	cases := []struct {
+		obj      string
+		expected string
+		ok       bool
+	}{
+		{obj: `{"a":1}`, expected: `{"repeated":3,"a":1}`, ok: true},
+		{obj: ` { "a" : [1, 2] } `, expected: `{"repeated":3,"a" : [1, 2] }`, ok: true},
+		{obj: `{}`, expected: `{"repeated":3}`, ok: true},
+		{obj: `{ }`, expected: `{"repeated":3}`, ok: true},
+		{obj: `[1,2]`, expected: `[1,2]`},
+		{obj: `42`, expected: `42`},
+		{obj: `{"a":`, expected: `{"a":`},
+	}
+
+	for _, c := range cases {
+		got, ok := withRepeatedField(c.obj, 3)
+		if got != c.expected || ok != c.ok {
+			t.Errorf("%q: got=%q ok=%v want=%q ok=%v", c.obj, got, ok, c.expected, c.ok)
+		}
+	}
+}
+
+func TestWriterCompressRepeated(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithCompressRepeated(time.Minute), WithFlushInterval(time.Hour))
+
+	input := strings.Repeat("retrying\n", 1423) + "connected\nconnected\nready\n"
+	if _, err := w.Write([]byte(input)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// each event has the timestamp of the first message of its run
+	expected := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("(repeated 1423 times) retrying"), Timestamp: aws.Int64(1)},
+		{Message: aws.String("(repeated 2 times) connected"), Timestamp: aws.Int64(1424)},
+		{Message: aws.String("ready"), Timestamp: aws.Int64(1426)},
+	}
+	if !reflect.DeepEqual(expected, logsClient.Events) {
+		t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+	}
+}
+
+func TestWriterCompressRepeatedExpire(t *testing.T) {
+	clock := int64(1000)
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient,
+		WithCompressRepeated(time.Second),
+		WithFlushInterval(time.Hour),
+		WithClock(func() int64 { return clock }),
+	)
+	defer w.Close()
+
+	w.appendEvent("tick")
+	w.appendEvent("tick")
+	w.expireRepeats()
+	if n := w.PendingEvents(); n != 0 {
+		t.Fatalf("a run was sent before the window elapsed: %d events", n)
+	}
+
+	clock += 1000
+	w.expireRepeats()
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"(repeated 2 times) tick"}
+	if got := logsClient.Messages(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("unexpected messages: got=%q want=%q", got, expected)
+	}
+}
+
+func TestWriterCompressRepeatedJSON(t *testing.T) {
+	input := strings.Repeat("retrying\n", 3) +
+		strings.Repeat(`{"level":"info","msg":"connected"}`+"\n", 2) +
+		strings.Repeat("42\n", 2) +
+		strings.Repeat(`{"latency_ms":12}`+"\n", 2) +
+		strings.Repeat("x", maxEventSize) + "\n" + strings.Repeat("x", maxEventSize) + "\n" +
+		"ready\n"
+
+	cases := []struct {
+		name     string
+		opts     []Option
+		expected []int
+	}{
+		{name: "json output", opts: []Option{WithJSONOutput(nil)}, expected: []int{3, 2, 2, 2, 2, 0}},
+		{name: "enrich", opts: []Option{WithEnrichment(EnrichJSON)}, expected: []int{3, 2, 2, 2, 2, 0}},
+		{
+			name:     "emf",
+			opts:     []Option{WithJSONOutput(nil), WithEMF("MyApp", []EMFMetric{{Field: "latency_ms"}})},
+			expected: []int{3, 2, 2, 2, 2, 0},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+			logsClient := newLogsCLientTest()
+			opts := append([]Option{WithCompressRepeated(time.Minute), WithFlushInterval(time.Hour)}, c.opts...)
+			w := New("group", "stream", logsClient, opts...)
+
+			if _, err := w.Write([]byte(input)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// every event is a single JSON object holding the count, and none
+			// was split
+			messages := logsClient.Messages()
+			if len(messages) != len(c.expected) {
+				t.Fatalf("unexpected number of events: got=%d want=%d", len(messages), len(c.expected))
+			}
+			for i, m := range messages {
+				var obj map[string]interface{}
+				if err := json.Unmarshal([]byte(m), &obj); err != nil {
+					t.Errorf("event %d is not a JSON object: %v: %.100s", i, err, m)
+					continue
+				}
+				if len(m) > maxEventSize-eventSize {
+					t.Errorf("event %d exceeds the limit: %d bytes", i, len(m))
+				}
+				if got, _ := obj["repeated"].(float64); int(got) != c.expected[i] {
+					t.Errorf("event %d: unexpected count: got=%v want=%d: %.100s", i, obj["repeated"], c.expected[i], m)
+				}
+			}
+		})
+	}
+}
@@ -1,15 +1,47 @@
 package writer
 
 import (
+	"context"
 	"errors"
+	"math/rand"
 	"time"
 )
 
+const (
+	// baseDelay is the default delay before the first retry. The delay doubles
+	// with each subsequent attempt.
+	baseDelay = 100 * time.Millisecond
+
+	// throttleBaseDelay is the default delay before the first retry of a
+	// throttled request. Throttling indicates the account or stream is over its
+	// quota, so backing off more aggressively gives it time to recover.
+	throttleBaseDelay = time.Second
+
+	// maxDelay is the default upper bound on the delay between attempts
+	maxDelay = 5 * time.Second
+
+	// maxImmediateRetries is the default limit on the number of times an
+	// operation is retried immediately, without counting as an attempt.
+	// Recovering a sequence token or creating a missing log group and stream
+	// take only a few.
+	maxImmediateRetries = 10
+)
+
 var (
 	// errIgnore signals to retry that the returned error should
 	// be ignored, the error count not incremented, and a retry
 	// should be attempted immediately
 	errIgnore = errors.New("ignore")
+
+	// errDeadline is returned by retry if its deadline passes while the
+	// operation is being retried immediately, so there is no other error
+	// to return
+	errDeadline = errors.New("retry deadline exceeded")
+
+	// errNoProgress is returned by retry if the operation was retried
+	// immediately too many times, e.g. because a log stream is still reported
+	// missing after it was created
+	errNoProgress = errors.New("too many immediate retries without progress")
 )
 
 type unrecoverableError struct {
@@ -24,15 +56,109 @@ func noRetry(err error) error {
 	}
 }
 
-func retry(f func() error) error {
+type throttledError struct {
+	error
+}
+
+// throttled returns an error that signals to retry that the request was
+// throttled and that it should wait longer than usual before trying again
+func throttled(err error) error {
+	return &throttledError{
+		err,
+	}
+}
+
+// backoff controls how many times retry attempts an operation and how long
+// it waits between attempts. The zero-value is not usable. newBackoff should
+// be used to construct a backoff with the default settings.
+type backoff struct {
+	// attempts is the max number of times an operation will be attempted
+	attempts int
+
+	// base is the delay before the first retry
+	base time.Duration
+
+	// throttleBase is the delay before the first retry of a throttled request
+	throttleBase time.Duration
+
+	// cap is the upper bound on the delay between attempts
+	cap time.Duration
+
+	// immediate is the max number of times an operation is retried
+	// immediately after it returns errIgnore
+	immediate int
+
+	// deadline, if non-zero, bounds the total time spent retrying. No retry
+	// is attempted if waiting for it would pass the deadline
+	deadline time.Duration
+
+	// sleep waits for d to elapse or for ctx to be done, whichever comes first.
+	// It's a field so tests can avoid real sleeps.
+	sleep func(ctx context.Context, d time.Duration) error
+
+	// jitter returns a random duration in the range [0, d]
+	jitter func(d time.Duration) time.Duration
+}
+
+func newBackoff(attempts int) backoff {
+	return backoff{
+		attempts:     attempts,
+		base:         baseDelay,
+		throttleBase: throttleBaseDelay,
+		cap:          maxDelay,
+		immediate:    maxImmediateRetries,
+		sleep:        sleep,
+		jitter:       jitter,
+	}
+}
+
+// delay returns how long to wait before retry number n (starting at 1) after
+// err. The delay grows exponentially from base (or throttleBase, if err was
+// throttled) up to cap, and full jitter is applied so that many writers
+// retrying at once are spread out.
+func (b backoff) delay(n int, err error) time.Duration {
+	base := b.base
+	if _, ok := err.(*throttledError); ok {
+		base = b.throttleBase
+	}
+
+	d := b.cap
+	if shift := uint(n - 1); shift < 32 {
+		if exp := base << shift; exp > 0 && exp < b.cap {
+			d = exp
+		}
+	}
+	return b.jitter(d)
+}
+
+// retry calls f until it succeeds, returns an unrecoverable error, has been
+// attempted b.attempts times or retried immediately b.immediate times, or
+// b.deadline would be exceeded. If ctx is done while waiting between
+// attempts, the last error returned by f is returned.
+func (b backoff) retry(ctx context.Context, f func() error) error {
 	var (
-		cnt int
-		err error
+		cnt     int
+		ignored int
+		err     error
+		end     time.Time
 	)
 
-	for cnt < maxRetries {
-		if cnt > 0 && err != errIgnore {
-			time.Sleep(time.Duration(cnt) * 100 * time.Millisecond)
+	if b.deadline > 0 {
+		end = time.Now().Add(b.deadline)
+	}
+
+	for cnt < b.attempts {
+		if cnt > 0 || err == errIgnore {
+			var d time.Duration
+			if err != errIgnore {
+				d = b.delay(cnt, err)
+			}
+			if !end.IsZero() && time.Now().Add(d).After(end) {
+				break
+			}
+			if d > 0 && b.sleep(ctx, d) != nil {
+				break
+			}
 		}
 
 		if err = f(); err == nil {
@@ -43,8 +169,31 @@ func retry(f func() error) error {
 
 		if err != errIgnore {
 			cnt++
+		} else if ignored++; ignored > b.immediate {
+			return errNoProgress
 		}
 	}
 
+	if t, ok := err.(*throttledError); ok {
+		return t.error
+	} else if err == errIgnore {
+		return errDeadline
+	}
 	return err
 }
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func jitter(d time.Duration) time.Duration {
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
@@ -0,0 +1,181 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testBackoff returns a backoff that records the delays it would have slept
+// for instead of sleeping, and applies no jitter
+func testBackoff(attempts int, delays *[]time.Duration) backoff {
+	b := newBackoff(attempts)
+	b.sleep = func(_ context.Context, d time.Duration) error {
+		*delays = append(*delays, d)
+		return nil
+	}
+	b.jitter = func(d time.Duration) time.Duration { return d }
+	return b
+}
+
+func TestRetryBackoff(t *testing.T) {
+	var delays []time.Duration
+	b := testBackoff(8, &delays)
+	b.cap = time.Second
+
+	errFail := errors.New("fail")
+	var calls int
+	err := b.retry(context.Background(), func() error {
+		calls++
+		return errFail
+	})
+
+	if err != errFail {
+		t.Errorf("unexpected error: got=%v want=%v", err, errFail)
+	}
+	if calls != 8 {
+		t.Errorf("unexpected number of attempts: got=%d want=8", calls)
+	}
+
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+		time.Second,
+	}
+	if !reflect.DeepEqual(expected, delays) {
+		t.Errorf("unexpected delays: got=%v want=%v", delays, expected)
+	}
+}
+
+func TestRetryIgnore(t *testing.T) {
+	var delays []time.Duration
+	b := testBackoff(2, &delays)
+
+	var calls int
+	err := b.retry(context.Background(), func() error {
+		calls++
+		if calls < 4 {
+			return errIgnore
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if calls != 4 {
+		t.Errorf("unexpected number of attempts: got=%d want=4", calls)
+	}
+	if len(delays) != 0 {
+		t.Errorf("ignored errors should not sleep: got=%v", delays)
+	}
+}
+
+func TestRetryIgnoreLimit(t *testing.T) {
+	var delays []time.Duration
+	b := testBackoff(2, &delays)
+
+	var calls int
+	err := b.retry(context.Background(), func() error {
+		calls++
+		return errIgnore
+	})
+
+	if err != errNoProgress {
+		t.Errorf("unexpected error: got=%v want=%v", err, errNoProgress)
+	}
+	if calls != maxImmediateRetries+1 {
+		t.Errorf("unexpected number of attempts: got=%d want=%d", calls, maxImmediateRetries+1)
+	}
+}
+
+func TestRetryNoRetry(t *testing.T) {
+	var delays []time.Duration
+	b := testBackoff(5, &delays)
+
+	errFatal := errors.New("fatal")
+	var calls int
+	err := b.retry(context.Background(), func() error {
+		calls++
+		return noRetry(errFatal)
+	})
+
+	if err != errFatal {
+		t.Errorf("unexpected error: got=%v want=%v", err, errFatal)
+	}
+	if calls != 1 || len(delays) != 0 {
+		t.Errorf("unrecoverable error was retried: calls=%d delays=%v", calls, delays)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := jitter(time.Second); d < 0 || d > time.Second {
+			t.Fatalf("jitter out of range: %v", d)
+		}
+	}
+}
+
+func TestRetryThrottled(t *testing.T) {
+	var delays []time.Duration
+	b := testBackoff(3, &delays)
+
+	errThrottle := errors.New("throttled")
+	err := b.retry(context.Background(), func() error {
+		return throttled(errThrottle)
+	})
+
+	if err != errThrottle {
+		t.Errorf("unexpected error: got=%v want=%v", err, errThrottle)
+	}
+
+	expected := []time.Duration{time.Second, 2 * time.Second}
+	if !reflect.DeepEqual(expected, delays) {
+		t.Errorf("unexpected delays: got=%v want=%v", delays, expected)
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	b := newBackoff(1000)
+	b.base = 10 * time.Millisecond
+	b.deadline = 100 * time.Millisecond
+
+	errFail := errors.New("fail")
+	var calls int
+	start := time.Now()
+	err := b.retry(context.Background(), func() error {
+		calls++
+		return errFail
+	})
+
+	if err != errFail {
+		t.Errorf("unexpected error: got=%v want=%v", err, errFail)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("retry did not stop at the deadline: took %v", elapsed)
+	}
+	if calls < 2 || calls >= 1000 {
+		t.Errorf("unexpected number of attempts: %d", calls)
+	}
+}
+
+func TestRetryDeadlineIgnore(t *testing.T) {
+	b := newBackoff(1)
+	b.deadline = 10 * time.Millisecond
+	b.immediate = 1000
+
+	err := b.retry(context.Background(), func() error {
+		time.Sleep(time.Millisecond)
+		return errIgnore
+	})
+
+	if err != errDeadline {
+		t.Errorf("unexpected error: got=%v want=%v", err, errDeadline)
+	}
+}
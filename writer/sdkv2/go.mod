@@ -0,0 +1,19 @@
+module github.com/kylemcc/cwlog/writer/sdkv2
+
+// the v2 SDK requires go 1.24
+go 1.24
+
+require (
+	github.com/aws/aws-sdk-go v1.55.5
+	github.com/aws/aws-sdk-go-v2 v1.47.1
+	github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs v1.88.1
+	github.com/aws/smithy-go v1.28.2
+	github.com/kylemcc/cwlog v0.1.2
+)
+
+require (
+	github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream v1.7.20 // indirect
+	github.com/aws/aws-sdk-go-v2/internal/configsources v1.5.4 // indirect
+	github.com/aws/aws-sdk-go-v2/internal/endpoints/v2 v2.8.4 // indirect
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+)
@@ -0,0 +1,214 @@
+// Package sdkv2 adapts a CloudWatch Logs client from version 2 of the AWS SDK
+// for Go to the writer package, so that programs already configured for v2
+// can use a LogWriter:
+//
+//	cfg, err := config.LoadDefaultConfig(ctx)
+//	...
+//	w := writer.New(group, stream, sdkv2.NewClient(cloudwatchlogs.NewFromConfig(cfg)))
+//
+// It is a separate module, so that the writer package doesn't depend on v2.
+package sdkv2
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	v1 "github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/smithy-go"
+	"github.com/kylemcc/cwlog/writer"
+)
+
+// API is the subset of the v2 CloudWatch Logs client used by a LogWriter.
+// *cloudwatchlogs.Client implements it.
+type API interface {
+	PutLogEvents(ctx context.Context, params *cloudwatchlogs.PutLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.PutLogEventsOutput, error)
+	CreateLogGroup(ctx context.Context, params *cloudwatchlogs.CreateLogGroupInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.CreateLogGroupOutput, error)
+	CreateLogStream(ctx context.Context, params *cloudwatchlogs.CreateLogStreamInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.CreateLogStreamOutput, error)
+	PutRetentionPolicy(ctx context.Context, params *cloudwatchlogs.PutRetentionPolicyInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.PutRetentionPolicyOutput, error)
+	DescribeLogStreams(ctx context.Context, params *cloudwatchlogs.DescribeLogStreamsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DescribeLogStreamsOutput, error)
+}
+
+// client implements the operations used by a LogWriter by translating their
+// inputs, outputs, and errors to and from v2. Calling any other operation
+// panics.
+type client struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	api API
+}
+
+// NewClient returns a writer.Client that sends requests with api. Errors are
+// translated so that the writer retries and recovers from them as it would
+// with a v1 client.
+func NewClient(api API) writer.Client {
+	return &client{api: api}
+}
+
+// PutLogEventsWithContext implements cloudwatchlogsiface.CloudWatchLogsAPI
+func (c *client) PutLogEventsWithContext(ctx aws.Context, input *v1.PutLogEventsInput, _ ...request.Option) (*v1.PutLogEventsOutput, error) {
+	in := &cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  input.LogGroupName,
+		LogStreamName: input.LogStreamName,
+		SequenceToken: input.SequenceToken,
+		LogEvents:     make([]types.InputLogEvent, len(input.LogEvents)),
+	}
+	for i, e := range input.LogEvents {
+		in.LogEvents[i] = types.InputLogEvent{Message: e.Message, Timestamp: e.Timestamp}
+	}
+
+	out, err := c.api.PutLogEvents(ctx, in)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	resp := &v1.PutLogEventsOutput{NextSequenceToken: out.NextSequenceToken}
+	if info := out.RejectedLogEventsInfo; info != nil {
+		resp.RejectedLogEventsInfo = &v1.RejectedLogEventsInfo{
+			ExpiredLogEventEndIndex:  int64Ptr(info.ExpiredLogEventEndIndex),
+			TooNewLogEventStartIndex: int64Ptr(info.TooNewLogEventStartIndex),
+			TooOldLogEventEndIndex:   int64Ptr(info.TooOldLogEventEndIndex),
+		}
+	}
+	return resp, nil
+}
+
+// CreateLogGroupWithContext implements cloudwatchlogsiface.CloudWatchLogsAPI
+func (c *client) CreateLogGroupWithContext(ctx aws.Context, input *v1.CreateLogGroupInput, _ ...request.Option) (*v1.CreateLogGroupOutput, error) {
+	in := &cloudwatchlogs.CreateLogGroupInput{
+		LogGroupName:  input.LogGroupName,
+		KmsKeyId:      input.KmsKeyId,
+		LogGroupClass: types.LogGroupClass(aws.StringValue(input.LogGroupClass)),
+	}
+	if len(input.Tags) > 0 {
+		in.Tags = aws.StringValueMap(input.Tags)
+	}
+
+	if _, err := c.api.CreateLogGroup(ctx, in); err != nil {
+		return nil, convertError(err)
+	}
+	return &v1.CreateLogGroupOutput{}, nil
+}
+
+// CreateLogStreamWithContext implements cloudwatchlogsiface.CloudWatchLogsAPI
+func (c *client) CreateLogStreamWithContext(ctx aws.Context, input *v1.CreateLogStreamInput, _ ...request.Option) (*v1.CreateLogStreamOutput, error) {
+	in := &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  input.LogGroupName,
+		LogStreamName: input.LogStreamName,
+	}
+
+	if _, err := c.api.CreateLogStream(ctx, in); err != nil {
+		return nil, convertError(err)
+	}
+	return &v1.CreateLogStreamOutput{}, nil
+}
+
+// PutRetentionPolicyWithContext implements cloudwatchlogsiface.CloudWatchLogsAPI
+func (c *client) PutRetentionPolicyWithContext(ctx aws.Context, input *v1.PutRetentionPolicyInput, _ ...request.Option) (*v1.PutRetentionPolicyOutput, error) {
+	in := &cloudwatchlogs.PutRetentionPolicyInput{LogGroupName: input.LogGroupName}
+	if input.RetentionInDays != nil {
+		days := int32(*input.RetentionInDays)
+		in.RetentionInDays = &days
+	}
+
+	if _, err := c.api.PutRetentionPolicy(ctx, in); err != nil {
+		return nil, convertError(err)
+	}
+	return &v1.PutRetentionPolicyOutput{}, nil
+}
+
+// DescribeLogStreamsWithContext implements cloudwatchlogsiface.CloudWatchLogsAPI
+func (c *client) DescribeLogStreamsWithContext(ctx aws.Context, input *v1.DescribeLogStreamsInput, _ ...request.Option) (*v1.DescribeLogStreamsOutput, error) {
+	in := &cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        input.LogGroupName,
+		LogGroupIdentifier:  input.LogGroupIdentifier,
+		LogStreamNamePrefix: input.LogStreamNamePrefix,
+		Descending:          input.Descending,
+		NextToken:           input.NextToken,
+		OrderBy:             types.OrderBy(aws.StringValue(input.OrderBy)),
+	}
+	if input.Limit != nil {
+		limit := int32(*input.Limit)
+		in.Limit = &limit
+	}
+
+	out, err := c.api.DescribeLogStreams(ctx, in)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	resp := &v1.DescribeLogStreamsOutput{NextToken: out.NextToken}
+	for _, s := range out.LogStreams {
+		resp.LogStreams = append(resp.LogStreams, &v1.LogStream{
+			Arn:                 s.Arn,
+			CreationTime:        s.CreationTime,
+			FirstEventTimestamp: s.FirstEventTimestamp,
+			LastEventTimestamp:  s.LastEventTimestamp,
+			LastIngestionTime:   s.LastIngestionTime,
+			LogStreamName:       s.LogStreamName,
+			UploadSequenceToken: s.UploadSequenceToken,
+		})
+	}
+	return resp, nil
+}
+
+// convertError translates an error returned by v2 into the form a v1 client
+// would have returned, since the writer decides how to handle errors by their
+// v1 codes, types, and status codes. Errors that didn't come from the service
+// at all, such as network failures, become v1 request errors wrapping the
+// original.
+func convertError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return awserr.New(request.ErrCodeRequestError, "send request failed", err)
+	}
+
+	var (
+		status    int
+		requestID string
+		se        interface{ HTTPStatusCode() int }
+		re        interface{ ServiceRequestID() string }
+	)
+	if errors.As(err, &se) {
+		status = se.HTTPStatusCode()
+	}
+	if errors.As(err, &re) {
+		requestID = re.ServiceRequestID()
+	}
+	msg := aws.String(apiErr.ErrorMessage())
+
+	// the writer recovers the sequence token from these, so they keep their
+	// v1 types. Their response metadata can only be set through the SDK's
+	// private protocol package, so they carry no status code or request ID
+	var (
+		tokenErr    *types.InvalidSequenceTokenException
+		acceptedErr *types.DataAlreadyAcceptedException
+	)
+	switch {
+	case errors.As(err, &tokenErr):
+		return &v1.InvalidSequenceTokenException{Message_: msg, ExpectedSequenceToken: tokenErr.ExpectedSequenceToken}
+	case errors.As(err, &acceptedErr):
+		return &v1.DataAlreadyAcceptedException{Message_: msg, ExpectedSequenceToken: acceptedErr.ExpectedSequenceToken}
+	}
+
+	aerr := awserr.New(apiErr.ErrorCode(), apiErr.ErrorMessage(), err)
+	if status == 0 {
+		return aerr
+	}
+	return awserr.NewRequestFailure(aerr, status, requestID)
+}
+
+func int64Ptr(n *int32) *int64 {
+	if n == nil {
+		return nil
+	}
+	return aws.Int64(int64(*n))
+}
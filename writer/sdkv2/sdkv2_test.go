@@ -0,0 +1,305 @@
+package sdkv2
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	v1 "github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/kylemcc/cwlog/writer"
+	"github.com/kylemcc/cwlog/writer/cwlogtest"
+)
+
+// fakeAPI is a v2 counterpart of cwlogtest.Client
+type fakeAPI struct {
+	sync.Mutex
+
+	events  []types.InputLogEvent
+	batches []int
+	tokens  []*string
+
+	// putErrs are returned by the first PutLogEvents calls, in order
+	putErrs []error
+
+	noGroup   bool
+	noStream  bool
+	groups    []*cloudwatchlogs.CreateLogGroupInput
+	streams   []*cloudwatchlogs.CreateLogStreamInput
+	retention []*cloudwatchlogs.PutRetentionPolicyInput
+
+	seq int
+}
+
+func (f *fakeAPI) PutLogEvents(_ context.Context, in *cloudwatchlogs.PutLogEventsInput, _ ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.tokens = append(f.tokens, in.SequenceToken)
+	if len(f.putErrs) > 0 {
+		err := f.putErrs[0]
+		f.putErrs = f.putErrs[1:]
+		return nil, err
+	}
+	if f.noStream {
+		return nil, apiError(http.StatusBadRequest, &types.ResourceNotFoundException{Message: aws.String("stream does not exist")})
+	}
+
+	f.events = append(f.events, in.LogEvents...)
+	f.batches = append(f.batches, len(in.LogEvents))
+	f.seq++
+	return &cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String(strconv.Itoa(f.seq))}, nil
+}
+
+func (f *fakeAPI) CreateLogGroup(_ context.Context, in *cloudwatchlogs.CreateLogGroupInput, _ ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.groups = append(f.groups, in)
+	if !f.noGroup {
+		return nil, apiError(http.StatusBadRequest, &types.ResourceAlreadyExistsException{Message: aws.String("group exists")})
+	}
+	f.noGroup = false
+	return &cloudwatchlogs.CreateLogGroupOutput{}, nil
+}
+
+func (f *fakeAPI) CreateLogStream(_ context.Context, in *cloudwatchlogs.CreateLogStreamInput, _ ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.streams = append(f.streams, in)
+	if f.noGroup {
+		return nil, apiError(http.StatusBadRequest, &types.ResourceNotFoundException{Message: aws.String("group does not exist")})
+	}
+	if !f.noStream {
+		return nil, apiError(http.StatusBadRequest, &types.ResourceAlreadyExistsException{Message: aws.String("stream exists")})
+	}
+	f.noStream = false
+	return &cloudwatchlogs.CreateLogStreamOutput{}, nil
+}
+
+func (f *fakeAPI) DescribeLogStreams(_ context.Context, in *cloudwatchlogs.DescribeLogStreamsInput, _ ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.noGroup {
+		return nil, apiError(http.StatusBadRequest, &types.ResourceNotFoundException{Message: aws.String("group does not exist")})
+	}
+	out := &cloudwatchlogs.DescribeLogStreamsOutput{}
+	if !f.noStream {
+		out.LogStreams = []types.LogStream{{LogStreamName: in.LogStreamNamePrefix}}
+	}
+	return out, nil
+}
+
+func (f *fakeAPI) PutRetentionPolicy(_ context.Context, in *cloudwatchlogs.PutRetentionPolicyInput, _ ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.PutRetentionPolicyOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.retention = append(f.retention, in)
+	return &cloudwatchlogs.PutRetentionPolicyOutput{}, nil
+}
+
+// apiError wraps err the way the v2 SDK returns a service error
+func apiError(status int, err error) error {
+	return &smithy.OperationError{
+		ServiceID:     "CloudWatch Logs",
+		OperationName: "PutLogEvents",
+		Err: &awshttp.ResponseError{
+			ResponseError: &smithyhttp.ResponseError{
+				Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+				Err:      err,
+			},
+			RequestID: "request-id",
+		},
+	}
+}
+
+func TestClientParity(t *testing.T) {
+	input := "first\nsecond\n" + strings.Repeat("x", 100) + "\nlast\n"
+	opts := func() []writer.Option {
+		return []writer.Option{
+			writer.WithClock(cwlogtest.NewClock()),
+			writer.WithMaxBatchEvents(2),
+			writer.WithSequenceTokens(true),
+			writer.WithFlushInterval(time.Hour),
+		}
+	}
+
+	v1Client := cwlogtest.NewClient()
+	w := writer.New("group", "stream", v1Client, opts()...)
+	if _, err := w.Write([]byte(input)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	api := &fakeAPI{}
+	w = writer.New("group", "stream", NewClient(api), opts()...)
+	if _, err := w.Write([]byte(input)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := make([]types.InputLogEvent, len(v1Client.Events))
+	for i, e := range v1Client.Events {
+		expected[i] = types.InputLogEvent{Message: e.Message, Timestamp: e.Timestamp}
+	}
+	if !reflect.DeepEqual(expected, api.events) {
+		t.Errorf("log events did not match: got=%v want=%v", api.events, expected)
+	}
+	if !reflect.DeepEqual(v1Client.Batches, api.batches) {
+		t.Errorf("batches did not match: got=%v want=%v", api.batches, v1Client.Batches)
+	}
+	if got, want := aws.ToStringSlice(api.tokens), aws.ToStringSlice(v1Client.Tokens); !reflect.DeepEqual(got, want) {
+		t.Errorf("sequence tokens did not match: got=%v want=%v", got, want)
+	}
+}
+
+func TestClientCreatesResources(t *testing.T) {
+	api := &fakeAPI{noGroup: true, noStream: true}
+	w := writer.New("group", "stream", NewClient(api),
+		writer.WithClock(cwlogtest.NewClock()),
+		writer.WithRetentionDays(30),
+		writer.WithTags(map[string]*string{"team": aws.String("infra")}),
+	)
+
+	if _, err := w.Write([]byte("test input\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(api.events) != 1 || aws.ToString(api.events[0].Message) != "test input" {
+		t.Errorf("unexpected log events: %v", api.events)
+	}
+	if len(api.groups) != 1 || !reflect.DeepEqual(api.groups[0].Tags, map[string]string{"team": "infra"}) {
+		t.Errorf("unexpected log group inputs: %v", api.groups)
+	}
+	if len(api.streams) != 2 {
+		t.Errorf("unexpected number of log stream creates: %d", len(api.streams))
+	}
+	if len(api.retention) != 1 || aws.ToInt32(api.retention[0].RetentionInDays) != 30 {
+		t.Errorf("unexpected retention policy inputs: %v", api.retention)
+	}
+}
+
+func TestClientPreflight(t *testing.T) {
+	api := &fakeAPI{noStream: true}
+	w := writer.New("group", "stream", NewClient(api), writer.WithAutoCreate(false))
+	defer w.Close()
+
+	if err := w.Preflight(context.Background()); !errors.Is(err, writer.ErrLogStreamNotFound) {
+		t.Errorf("unexpected error: got=%v want=%v", err, writer.ErrLogStreamNotFound)
+	}
+
+	api.noStream = false
+	if err := w.Preflight(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestClientInvalidSequenceToken(t *testing.T) {
+	api := &fakeAPI{putErrs: []error{
+		apiError(http.StatusBadRequest, &types.InvalidSequenceTokenException{
+			Message:               aws.String("invalid token"),
+			ExpectedSequenceToken: aws.String("expected"),
+		}),
+	}}
+	w := writer.New("group", "stream", NewClient(api), writer.WithSequenceTokens(true), writer.WithFlushInterval(time.Hour))
+	defer w.Close()
+
+	if err := w.WriteEvent("event", time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"", "expected"}
+	if got := aws.ToStringSlice(api.tokens); !reflect.DeepEqual(expected, got) {
+		t.Errorf("unexpected sequence tokens: got=%q want=%q", got, expected)
+	}
+}
+
+func TestClientTerminalError(t *testing.T) {
+	api := &fakeAPI{putErrs: []error{
+		apiError(http.StatusBadRequest, &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "denied"}),
+	}}
+	w := writer.New("group", "stream", NewClient(api), writer.WithMaxRetries(3), writer.WithFlushInterval(time.Hour))
+	defer w.Close()
+
+	if err := w.WriteEvent("event", time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := w.Flush()
+	rf, ok := err.(awserr.RequestFailure)
+	if !ok || rf.Code() != v1.ErrCodeAccessDeniedException || rf.StatusCode() != http.StatusBadRequest {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(api.tokens) != 1 {
+		t.Errorf("a terminal error was retried: %d calls", len(api.tokens))
+	}
+}
+
+func TestConvertError(t *testing.T) {
+	netErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	t.Run("throttled", func(t *testing.T) {
+		err := convertError(apiError(http.StatusBadRequest, &types.ThrottlingException{Message: aws.String("rate exceeded")}))
+		if !request.IsErrorThrottle(err) {
+			t.Errorf("not a throttling error: %v", err)
+		}
+	})
+
+	t.Run("server error", func(t *testing.T) {
+		err := convertError(apiError(http.StatusServiceUnavailable, &types.ServiceUnavailableException{Message: aws.String("unavailable")}))
+		rf, ok := err.(awserr.RequestFailure)
+		if !ok || rf.StatusCode() != http.StatusServiceUnavailable || rf.RequestID() != "request-id" || rf.Code() != v1.ErrCodeServiceUnavailableException {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("already accepted", func(t *testing.T) {
+		err := convertError(apiError(http.StatusBadRequest, &types.DataAlreadyAcceptedException{ExpectedSequenceToken: aws.String("next")}))
+		e, ok := err.(*v1.DataAlreadyAcceptedException)
+		if !ok || aws.ToString(e.ExpectedSequenceToken) != "next" || e.Code() != v1.ErrCodeDataAlreadyAcceptedException {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("network", func(t *testing.T) {
+		err := convertError(&smithy.OperationError{Err: &smithyhttp.RequestSendError{Err: netErr}})
+		aerr, ok := err.(awserr.Error)
+		var nerr net.Error
+		if !ok || aerr.Code() != request.ErrCodeRequestError || !errors.As(aerr.OrigErr(), &nerr) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		err := convertError(&smithy.OperationError{Err: context.Canceled})
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != request.CanceledErrorCode {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package writer
+
+import (
+	"bufio"
+	"reflect"
+	"unicode/utf8"
+)
+
+// ScanDelimiter returns a bufio.SplitFunc that splits input into records
+// separated by delim, e.g. 0 for NUL-delimited input. The delimiter is not
+// included in the records, and a final record without one is still returned.
+func ScanDelimiter(delim byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		for i, c := range data {
+			if c == delim {
+				return i + 1, data[:i], nil
+			}
+		}
+
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// ScanChunks returns a bufio.SplitFunc that splits input into records of size
+// bytes, for output that isn't line-oriented, such as binary-ish data or very
+// long unstructured text. Nothing is removed from the input, including
+// newlines. size is capped so that each record fits in a single CloudWatch
+// Logs event. A record is cut short rather than end in the middle of a UTF-8
+// character, and the final record may be shorter.
+func ScanChunks(size int) bufio.SplitFunc {
+	if size <= 0 || size > maxEventSize-eventSize {
+		size = maxEventSize - eventSize
+	}
+
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if len(data) < size {
+			if atEOF && len(data) > 0 {
+				return len(data), data, nil
+			}
+			return 0, nil, nil
+		}
+
+		// back up to the start of a character split by the cut, if that
+		// leaves anything. Invalid input is cut where it falls
+		n := size
+		for i := size - 1; i >= 0 && i >= size-utf8.UTFMax; i-- {
+			if utf8.RuneStart(data[i]) {
+				if i > 0 && !utf8.FullRune(data[i:size]) {
+					n = i
+				}
+				break
+			}
+		}
+		return n, data[:n], nil
+	}
+}
+
+// ScanJSON is a bufio.SplitFunc that splits a stream of concatenated JSON
+// values, such as {"a":1}{"a":2}, into one record per value. Whitespace
+// between values, including newlines, is discarded. The values are not
+// validated; objects and arrays are delimited by matching their brackets.
+func ScanJSON(data []byte, atEOF bool) (int, []byte, error) {
+	start := 0
+	for start < len(data) && isJSONSpace(data[start]) {
+		start++
+	}
+	if start == len(data) {
+		return len(data), nil, nil
+	}
+
+	var (
+		depth    int
+		inString bool
+		escaped  bool
+	)
+	for i := start; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			if depth--; depth <= 0 {
+				return i + 1, data[start : i+1], nil
+			}
+		case depth == 0 && isJSONSpace(c):
+			// the end of a bare number, string, or literal
+			return i, data[start:i], nil
+		}
+	}
+
+	if atEOF {
+		return len(data), data[start:], nil
+	}
+
+	// discard the leading whitespace and wait for the rest of the value
+	return start, nil, nil
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isScanLines reports whether split is bufio.ScanLines, which removes the
+// carriage return of a CRLF line ending itself
+func isScanLines(split bufio.SplitFunc) bool {
+	return reflect.ValueOf(split).Pointer() == reflect.ValueOf(bufio.ScanLines).Pointer()
+}
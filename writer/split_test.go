@@ -0,0 +1,115 @@
+package writer
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func scanAll(t *testing.T, input string, split bufio.SplitFunc) []string {
+	t.Helper()
+
+	// a tiny buffer exercises records that span several reads
+	sc := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(input)))
+	sc.Buffer(make([]byte, 4), maxLineSize)
+	sc.Split(split)
+
+	var records []string
+	for sc.Scan() {
+		records = append(records, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return records
+}
+
+func TestScanDelimiter(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"empty", "", nil},
+		{"trailing delimiter", "one\x00two\x00", []string{"one", "two"}},
+		{"no trailing delimiter", "one\x00two", []string{"one", "two"}},
+		{"empty record", "one\x00\x00two", []string{"one", "", "two"}},
+		{"newlines kept", "one\nline\x00two", []string{"one\nline", "two"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := scanAll(t, c.input, ScanDelimiter(0))
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("unexpected records: got=%q want=%q", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestScanJSON(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"empty", "", nil},
+		{"whitespace", " \n ", nil},
+		{"concatenated", `{"a":1}{"a":2}`, []string{`{"a":1}`, `{"a":2}`}},
+		{"separated", "{\"a\":1}\n\n {\"a\":2}\n", []string{`{"a":1}`, `{"a":2}`}},
+		{"nested", `{"a":{"b":[1,{"c":2}]}}[3]`, []string{`{"a":{"b":[1,{"c":2}]}}`, `[3]`}},
+		{"brackets in strings", `{"a":"}{\"]"}{"b":"\\"}`, []string{`{"a":"}{\"]"}`, `{"b":"\\"}`}},
+		{"pretty printed", "{\n  \"a\": 1\n}\n{\n  \"a\": 2\n}", []string{"{\n  \"a\": 1\n}", "{\n  \"a\": 2\n}"}},
+		{"scalars", `1 "two three" true`, []string{`1`, `"two three"`, `true`}},
+		{"unterminated", `{"a":1}{"a":`, []string{`{"a":1}`, `{"a":`}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := scanAll(t, c.input, ScanJSON)
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("unexpected records: got=%q want=%q", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestScanChunks(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"empty", "", nil},
+		{"exact", "abcdefabcdef", []string{"abcdef", "abcdef"}},
+		{"short final chunk", "abcdefgh", []string{"abcdef", "gh"}},
+		{"newlines kept", "ab\ncd\nef\n", []string{"ab\ncd\n", "ef\n"}},
+		{"split character", "abcdeé", []string{"abcde", "é"}},
+		{"whole character", "abcdéf", []string{"abcdé", "f"}},
+		{"split 4 byte character", "abc😀", []string{"abc", "😀"}},
+		{"invalid UTF-8", "abcd\xff\xffxy", []string{"abcd\xff\xff", "xy"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := scanAll(t, c.input, ScanChunks(6))
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("unexpected records: got=%q want=%q", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestScanChunksLimit(t *testing.T) {
+	max := maxEventSize - eventSize
+	input := strings.Repeat("x", max+10)
+
+	for _, size := range []int{0, maxEventSize, 10 * maxEventSize} {
+		got := scanAll(t, input, ScanChunks(size))
+		if len(got) != 2 || len(got[0]) != max || len(got[1]) != 10 {
+			t.Errorf("size %d: chunks were not capped at %d bytes", size, max)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package writer
+
+// Stats is a snapshot of a LogWriter's activity
+type Stats struct {
+	// BufferedEvents is the number of events waiting to be sent
+	BufferedEvents int
+
+	// BufferedBytes is the size of the events waiting to be sent, including
+	// per-event overhead
+	BufferedBytes int
+
+	// SentEvents is the number of events successfully delivered to CloudWatch
+	// Logs
+	SentEvents int
+
+	// SentBytes is the size of the batches successfully sent, including
+	// per-event overhead
+	SentBytes int
+
+	// SentBatches is the number of successful PutLogEvents calls
+	SentBatches int
+
+	// DroppedEvents is the number of events that will never be delivered,
+	// because a flush failed, CloudWatch Logs rejected them, or they were
+	// discarded to keep the buffer within its limit
+	DroppedEvents int
+
+	// RetryCount is the number of times a PutLogEvents call was retried
+	RetryCount int
+}
+
+// Stats returns a snapshot of the writer's activity
+func (w *LogWriter) Stats() Stats {
+	w.Lock()
+	defer w.Unlock()
+
+	s := w.stats
+	s.BufferedEvents = len(w.buf)
+	s.BufferedBytes = w.bufSize
+	return s
+}
+
+// PendingEvents returns the number of events buffered and waiting to be sent.
+// Events being sent by a flush in progress aren't included.
+func (w *LogWriter) PendingEvents() int {
+	w.Lock()
+	defer w.Unlock()
+	return len(w.buf)
+}
+
+// PendingBytes returns the size of the events buffered and waiting to be
+// sent, including per-event overhead
+func (w *LogWriter) PendingBytes() int {
+	w.Lock()
+	defer w.Unlock()
+	return w.bufSize
+}
+
+// WrittenCount returns the number of events successfully delivered to
+// CloudWatch Logs. Called after Close, it is the total for the writer's
+// lifetime.
+func (w *LogWriter) WrittenCount() int {
+	w.Lock()
+	defer w.Unlock()
+	return w.stats.SentEvents
+}
@@ -0,0 +1,145 @@
+package writer
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// timestampParser extracts a timestamp from a line of input. If the line does
+// not contain a timestamp, ok is false.
+type timestampParser func(line string) (ts time.Time, ok bool)
+
+// leadingTimestamp returns a timestampParser that parses a timestamp in the
+// given time layout from the beginning of a line. The timestamp is assumed to
+// span the same number of whitespace-separated fields as the layout itself.
+//
+// Timestamps without a zone are in loc. Timestamps without a year are given
+// the current year according to now, or the previous year if that would put
+// them more than a day in the future, as when December's lines are read in
+// January.
+func leadingTimestamp(layout string, loc *time.Location, now func() time.Time) timestampParser {
+	fields := len(strings.Fields(layout))
+
+	return func(line string) (time.Time, bool) {
+		end := fieldsEnd(line, fields)
+		if end < 0 {
+			return time.Time{}, false
+		}
+
+		t, err := time.ParseInLocation(layout, line[:end], loc)
+		if err != nil {
+			return time.Time{}, false
+		}
+
+		// layouts such as syslog's omit the year
+		if t.Year() == 0 {
+			ref := now()
+			t = t.AddDate(ref.Year(), 0, 0)
+			if t.After(ref.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+		}
+		return t, true
+	}
+}
+
+// jsonTimestamp returns a timestampParser that reads a timestamp from the
+// named top-level field of a JSON object. The field may hold an RFC3339
+// string or a number of milliseconds since the epoch.
+func jsonTimestamp(field string) timestampParser {
+	return func(line string) (time.Time, bool) {
+		if !strings.HasPrefix(strings.TrimSpace(line), "{") {
+			return time.Time{}, false
+		}
+
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			return time.Time{}, false
+		}
+
+		raw, ok := obj[field]
+		if !ok {
+			return time.Time{}, false
+		}
+
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			t, err := time.Parse(time.RFC3339, s)
+			return t, err == nil
+		}
+
+		ms, err := strconv.ParseFloat(string(raw), 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(0, int64(ms)*int64(time.Millisecond)), true
+	}
+}
+
+// timestampColumn reads each event's timestamp from a column of lines split
+// by a separator, removing the column from the message
+type timestampColumn struct {
+	// column is the index of the column holding the timestamp, from 0
+	column int
+	sep    string
+}
+
+// extract returns line without the timestamp column and the timestamp it
+// holds, in milliseconds since the epoch. The column may hold a number of
+// milliseconds or an RFC3339 timestamp. If line has too few columns or the
+// column doesn't hold a timestamp, line is returned unchanged and ok is false.
+func (c *timestampColumn) extract(line string) (msg string, ts int64, ok bool) {
+	// a line without a separator is all message
+	cols := strings.SplitN(line, c.sep, c.column+2)
+	if len(cols) < 2 || len(cols) <= c.column {
+		return line, 0, false
+	}
+
+	raw := strings.TrimSpace(cols[c.column])
+	if ms, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		ts = ms
+	} else if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		ts = t.UnixNano() / int64(time.Millisecond)
+	} else {
+		return line, 0, false
+	}
+
+	msg = strings.Join(append(cols[:c.column:c.column], cols[c.column+1:]...), c.sep)
+	return msg, ts, true
+}
+
+// fieldsEnd returns the index just past the end of the nth
+// whitespace-separated field in s, or -1 if s has fewer than n fields
+func fieldsEnd(s string, n int) int {
+	inField := false
+	for i, r := range s {
+		switch {
+		case unicode.IsSpace(r) && inField:
+			inField = false
+			if n--; n == 0 {
+				return i
+			}
+		case !unicode.IsSpace(r):
+			inField = true
+		}
+	}
+
+	if inField && n == 1 {
+		return len(s)
+	}
+	return -1
+}
+
+// parseTimestamp returns the timestamp found in line by the writer's
+// timestamp parsers, in milliseconds since the epoch
+func (w *LogWriter) parseTimestamp(line string) (int64, bool) {
+	for _, p := range w.timestampParsers {
+		if t, ok := p(line); ok {
+			return t.UnixNano() / int64(time.Millisecond), true
+		}
+	}
+	return 0, false
+}
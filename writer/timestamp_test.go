@@ -0,0 +1,241 @@
+package writer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+func TestLeadingTimestamp(t *testing.T) {
+	loc := time.FixedZone("EST", -5*60*60)
+	summer := time.Date(2021, 7, 1, 12, 0, 0, 0, loc)
+	newYear := time.Date(2021, 1, 1, 0, 10, 0, 0, loc)
+
+	cases := []struct {
+		name     string
+		layout   string
+		line     string
+		now      time.Time
+		expected time.Time
+		ok       bool
+	}{
+		{
+			"rfc3339",
+			time.RFC3339,
+			"2020-06-01T12:30:45Z GET /index.html",
+			summer,
+			time.Date(2020, 6, 1, 12, 30, 45, 0, time.UTC),
+			true,
+		},
+		{
+			"rfc3339 fractional",
+			time.RFC3339,
+			"2020-06-01T12:30:45.123-04:00 GET /index.html",
+			summer,
+			time.Date(2020, 6, 1, 16, 30, 45, 123000000, time.UTC),
+			true,
+		},
+		{
+			"syslog",
+			time.Stamp,
+			"Jun  1 12:30:45 web-3 sshd[123]: accepted",
+			summer,
+			time.Date(2021, 6, 1, 12, 30, 45, 0, loc),
+			true,
+		},
+		{
+			"syslog two digit day",
+			time.Stamp,
+			"Jun 11 12:30:45 web-3 sshd[123]: accepted",
+			summer,
+			time.Date(2021, 6, 11, 12, 30, 45, 0, loc),
+			true,
+		},
+		{
+			"syslog from last year",
+			time.Stamp,
+			"Dec 31 23:59:59 web-3 sshd[123]: accepted",
+			newYear,
+			time.Date(2020, 12, 31, 23, 59, 59, 0, loc),
+			true,
+		},
+		{
+			"syslog less than a day ahead",
+			time.Stamp,
+			"Jan  2 00:05:00 web-3 sshd[123]: accepted",
+			newYear,
+			time.Date(2021, 1, 2, 0, 5, 0, 0, loc),
+			true,
+		},
+		{
+			"timestamp only",
+			"2006-01-02 15:04:05",
+			"2020-06-01 12:30:45",
+			summer,
+			time.Date(2020, 6, 1, 12, 30, 45, 0, loc),
+			true,
+		},
+		{
+			"no timestamp",
+			time.RFC3339,
+			"GET /index.html",
+			summer,
+			time.Time{},
+			false,
+		},
+		{
+			"too few fields",
+			time.Stamp,
+			"Jun 1",
+			summer,
+			time.Time{},
+			false,
+		},
+		{
+			"empty line",
+			time.RFC3339,
+			"",
+			summer,
+			time.Time{},
+			false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now := func() time.Time { return c.now }
+			got, ok := leadingTimestamp(c.layout, loc, now)(c.line)
+			if ok != c.ok {
+				t.Fatalf("unexpected result: got ok=%v want ok=%v", ok, c.ok)
+			}
+			if ok && !got.Equal(c.expected) {
+				t.Errorf("unexpected timestamp: got=%v want=%v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestJSONTimestamp(t *testing.T) {
+	cases := []struct {
+		name     string
+		line     string
+		expected time.Time
+		ok       bool
+	}{
+		{
+			"rfc3339",
+			`{"time":"2020-06-01T12:30:45.5Z","msg":"hello"}`,
+			time.Date(2020, 6, 1, 12, 30, 45, 500000000, time.UTC),
+			true,
+		},
+		{
+			"epoch millis",
+			`{"msg":"hello","time":1591014645123}`,
+			time.Date(2020, 6, 1, 12, 30, 45, 123000000, time.UTC),
+			true,
+		},
+		{
+			"missing field",
+			`{"msg":"hello"}`,
+			time.Time{},
+			false,
+		},
+		{
+			"invalid timestamp",
+			`{"time":"yesterday"}`,
+			time.Time{},
+			false,
+		},
+		{
+			"wrong type",
+			`{"time":true}`,
+			time.Time{},
+			false,
+		},
+		{
+			"not json",
+			`time=2020-06-01T12:30:45Z msg=hello`,
+			time.Time{},
+			false,
+		},
+		{
+			"malformed json",
+			`{"time":"2020-06-01T12:30:45Z"`,
+			time.Time{},
+			false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ok := jsonTimestamp("time")(c.line)
+			if ok != c.ok {
+				t.Fatalf("unexpected result: got ok=%v want ok=%v", ok, c.ok)
+			}
+			if ok && !got.Equal(c.expected) {
+				t.Errorf("unexpected timestamp: got=%v want=%v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestTimestampColumn(t *testing.T) {
+	cases := []struct {
+		name   string
+		column int
+		sep    string
+		line   string
+		msg    string
+		ts     int64
+		ok     bool
+	}{
+		{"tab millis", 0, "\t", "1600000000123\tGET /index.html", "GET /index.html", 1600000000123, true},
+		{"tab rfc3339", 0, "\t", "2020-09-13T12:26:40.5Z\tGET /index.html", "GET /index.html", 1600000000500, true},
+		{"tab middle column", 1, "\t", "web-3\t1600000000000\tGET\t/index.html", "web-3\tGET\t/index.html", 1600000000000, true},
+		{"tab last column", 1, "\t", "GET /index.html\t1600000000000", "GET /index.html", 1600000000000, true},
+		{"space millis", 0, " ", "1600000000000 GET /index.html", "GET /index.html", 1600000000000, true},
+		{"space middle column", 1, " ", "web-3 2020-09-13T12:26:40Z GET /index.html", "web-3 GET /index.html", 1600000000000, true},
+		{"too few columns", 2, "\t", "1600000000000\tGET /index.html", "1600000000000\tGET /index.html", 0, false},
+		{"no separator", 0, "\t", "1600000000000", "1600000000000", 0, false},
+		{"malformed tab", 0, "\t", "yesterday\tGET /index.html", "yesterday\tGET /index.html", 0, false},
+		{"malformed space", 0, " ", "GET /index.html 1600000000000", "GET /index.html 1600000000000", 0, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			col := &timestampColumn{column: c.column, sep: c.sep}
+			msg, ts, ok := col.extract(c.line)
+			if msg != c.msg || ts != c.ts || ok != c.ok {
+				t.Errorf("unexpected result: got=(%q, %d, %v) want=(%q, %d, %v)", msg, ts, ok, c.msg, c.ts, c.ok)
+			}
+		})
+	}
+}
+
+func TestWriterTimestampColumn(t *testing.T) {
+	// a second before the parsed timestamp, so that both events fit in a
+	// batch's 24 hours
+	now = func() int64 { return 1599999999000 }
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithTimestampColumn(1, "\t"))
+
+	if _, err := w.Write([]byte("1600000000000\tfirst\nno timestamp\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// events are sent in timestamp order
+	expected := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("no timestamp"), Timestamp: aws.Int64(1599999999000)},
+		{Message: aws.String("first"), Timestamp: aws.Int64(1600000000000)},
+	}
+	if !reflect.DeepEqual(expected, logsClient.Events) {
+		t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+	}
+}
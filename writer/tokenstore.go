@@ -0,0 +1,52 @@
+package writer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TokenStore saves the sequence token for a log stream between runs, so that
+// a new writer can continue where the last one left off without first having
+// its token rejected
+type TokenStore interface {
+	// Load returns the last saved token. An empty token means none is known.
+	Load() (string, error)
+
+	// Save records token as the latest token for the stream
+	Save(token string) error
+}
+
+// FileTokenStore is a TokenStore that keeps the token in a file
+type FileTokenStore string
+
+// Load implements TokenStore. A missing file is not an error.
+func (f FileTokenStore) Load() (string, error) {
+	b, err := ioutil.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Save implements TokenStore. The file is replaced atomically, so a crash
+// while saving never leaves a partial token behind.
+func (f FileTokenStore) Save(token string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(string(f)), filepath.Base(string(f))+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(token + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), string(f))
+}
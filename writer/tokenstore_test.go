@@ -0,0 +1,42 @@
+package writer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileTokenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cwlog")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := FileTokenStore(filepath.Join(dir, "token"))
+
+	// nothing has been saved yet
+	if token, err := store.Load(); err != nil || token != "" {
+		t.Errorf("unexpected result loading a missing token: token=%q err=%v", token, err)
+	}
+
+	for _, want := range []string{"49590302675938458", "49590302675938459"} {
+		if err := store.Save(want); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, err := store.Load()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != want {
+			t.Errorf("unexpected token: got=%q want=%q", got, want)
+		}
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("temporary files were left behind: %d files", len(files))
+	}
+}
@@ -3,12 +3,23 @@ package writer
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
 )
@@ -28,21 +39,67 @@ const (
 	// https://docs.aws.amazon.com/AmazonCloudWatchLogs/latest/APIReference/API_PutLogEvents.html
 	maxEvents = 10_000
 
+	// maxEventSize is the maximum size of a single log event, including the
+	// 26 bytes of per-event overhead.
+	//
+	// https://docs.aws.amazon.com/AmazonCloudWatchLogs/latest/APIReference/API_PutLogEvents.html
+	maxEventSize = 262_144
+
+	// maxLineSize is the longest line the scanner will accept. Lines longer than
+	// a single event are split or truncated by appendEvent.
+	maxLineSize = maxSize
+
 	// eventSize is the static size of each event object excluding the message text. This is used
 	// to calculate the size of each log batch.
 	eventSize = 26
 
-	// maxRetries is the max number of times a cloudwatch operation will be attempted
-	// before giving up
+	// maxBatchSpan is the longest time a single batch may span, from its
+	// earliest event to its latest.
+	//
+	// https://docs.aws.amazon.com/AmazonCloudWatchLogs/latest/APIReference/API_PutLogEvents.html
+	maxBatchSpan = int64(24 * time.Hour / time.Millisecond)
+
+	// maxRetries is the default max number of times a cloudwatch operation will be
+	// attempted before giving up
 	maxRetries = 5
+
+	// flushInterval is the default interval at which buffered events are flushed
+	flushInterval = 2 * time.Second
+
+	// maxRequestRate is the default max number of PutLogEvents calls per second.
+	// CloudWatch Logs allows 5 requests per second per log stream.
+	//
+	// https://docs.aws.amazon.com/AmazonCloudWatch/latest/logs/cloudwatch_limits_cwl.html
+	maxRequestRate = 5
 )
 
+// EventOverhead is the number of bytes CloudWatch Logs counts for each event
+// in addition to its message when enforcing the batch and event size limits
+const EventOverhead = eventSize
+
+// MaxEventSize is the largest event CloudWatch Logs accepts, in bytes,
+// including EventOverhead
+const MaxEventSize = maxEventSize
+
+// errFlushStalled is returned by Close if the buffer stops draining while the
+// remaining events are being flushed
+var errFlushStalled = errors.New("buffered log events could not be flushed")
+
+// errNoResponse is returned for a PutLogEvents call that reported neither an
+// error nor a response. It is retried like any other failure
+var errNoResponse = errors.New("PutLogEvents returned no response")
+
 // now returns the current timestamp. it's a variable here so we can swap it out for testing
 var now = func() int64 {
 	return time.Now().UnixNano() / 1000000
 }
 
-// Client is a CloudWatch Logs client
+// newTicker returns the ticker for periodic flushes. It's a variable so tests
+// can tick it by hand
+var newTicker = time.NewTicker
+
+// Client is a CloudWatch Logs client. A single Client may be shared by
+// multiple LogWriters, e.g. to write to several log streams at once.
 type Client cloudwatchlogsiface.CloudWatchLogsAPI
 
 // LogWriter provides an io.Writer interface to CloudWatch Logs
@@ -52,6 +109,16 @@ type Client cloudwatchlogsiface.CloudWatchLogsAPI
 type LogWriter struct {
 	sync.Mutex
 
+	// flushSem limits the number of concurrent calls to Flush. It has room for
+	// flushConcurrency flushes, or just one if sequence tokens are in use, in
+	// which case it also guards sequenceToken
+	flushSem         chan struct{}
+	flushConcurrency int
+
+	// ctx bounds the lifetime of the writer. When it is cancelled, in-flight
+	// requests are aborted and the internal goroutines exit
+	ctx context.Context
+
 	// the log group to which the log stream belongs
 	logGroup string
 
@@ -63,21 +130,184 @@ type LogWriter struct {
 
 	bufSize int
 
-	// ticker is used to periodically flush the buffer
+	// inflight is the number of events drained from buf by flushes that
+	// haven't finished
+	inflight int
+
+	// maxBufferBytes, if non-zero, limits bufSize. overflow determines what
+	// happens when an event doesn't fit
+	maxBufferBytes int
+	overflow       OverflowPolicy
+
+	// highWater and lowWater, if set, pause reading input once bufSize
+	// reaches highWater until it drains to lowWater
+	highWater int
+	lowWater  int
+
+	// bufCond is signalled when events are removed from the buffer or the
+	// writer stops. Writers waiting for room in the buffer wait on it
+	bufCond *sync.Cond
+
+	// ticker is used to periodically flush the buffer. It is replaced by
+	// SetFlushInterval, so it is guarded by the lock
 	ticker *time.Ticker
 
+	// flushInterval is the period of ticker
+	flushInterval time.Duration
+
+	// tickerChanged signals periodicFlush that ticker has been replaced
+	tickerChanged chan struct{}
+
+	// minBatchEvents and minBatchBytes, if set, hold the periodic flush
+	// until the buffer reaches either size or its oldest event has been held
+	// for maxFlushDelay. bufferedSince is the time, in milliseconds, at which
+	// an event was added to the empty buffer
+	minBatchEvents int
+	minBatchBytes  int
+	maxFlushDelay  time.Duration
+	bufferedSince  int64
+
+	// backoff controls how failed cloudwatch operations are retried
+	backoff backoff
+
+	// limiter spaces out PutLogEvents calls to stay under the per-stream quota
+	limiter *limiter
+
+	// byteLimiter, if set, caps the number of bytes sent per second
+	byteLimiter *byteLimiter
+
+	// maxBatchBytes and maxBatchEvents are the max size and number of events
+	// of a single PutLogEvents batch
+	maxBatchBytes  int
+	maxBatchEvents int
+
+	// clock, if set, is used instead of the package's now to timestamp events
+	clock func() int64
+
+	// heartbeatInterval, if non-zero, is how long the stream may be idle
+	// before heartbeatMessage is sent. lastActivity is the time, in
+	// milliseconds, at which an event was last buffered or flushed
+	heartbeatInterval time.Duration
+	heartbeatMessage  string
+	lastActivity      int64
+
+	// timestampParsers are used in order to find a timestamp in each line. If
+	// none finds one, the event is stamped with the current time
+	timestampParsers []timestampParser
+
+	// timestampColumn, if set, reads the timestamp from a column of each line
+	// before the timestampParsers are tried
+	timestampColumn *timestampColumn
+
+	// split splits the input into records, each of which becomes an event
+	split bufio.SplitFunc
+
+	// multiline, if set, joins continuation lines onto the preceding event
+	multiline *multiline
+
+	// repeats, if set, collapses runs of identical consecutive messages
+	repeats *repeats
+
+	// retentionDays, if non-zero, is the retention policy applied to a log
+	// group created by the writer
+	retentionDays int
+
+	// tags and kmsKeyID are applied to a log group created by the writer
+	tags     map[string]*string
+	kmsKeyID string
+
+	// include and exclude, if set, filter the lines that are sent. A line is
+	// sent only if it matches include and does not match exclude
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+
+	// redactors match text that is masked before each line is buffered
+	redactors []*regexp.Regexp
+
+	// keepCR controls whether a carriage return at the end of a record read
+	// from the pipe, left by CRLF line endings, is kept
+	keepCR bool
+
+	// stripANSI controls whether ANSI escape sequences are removed from each
+	// line
+	stripANSI bool
+
+	// trimSpace controls whether leading and trailing whitespace is removed
+	// from each line, dropping lines that are left empty
+	trimSpace bool
+
+	// maxLineBytes, if non-zero, is the length at which each line is cut off
+	// and marked with the number of bytes dropped
+	maxLineBytes int
+
+	// prefix and suffix are added to each line
+	prefix string
+	suffix string
+
+	// enricher, if set, annotates each event with its host and process
+	enricher *enricher
+
+	// emf, if set, wraps JSON events in the Embedded Metric Format
+	emf *emf
+
+	// jsonOutput, if set, wraps plain-text events in JSON objects
+	jsonOutput *jsonOutput
+
+	// truncate controls whether messages larger than the per-event limit are
+	// truncated rather than split into multiple events
+	truncate bool
+
+	// neverSplit guarantees that a line is never split into multiple events.
+	// Lines over the per-event limit are truncated instead
+	neverSplit bool
+
 	// scanErr will receieve the return value of the internal scanner
 	scanErr chan error
 
 	// flushErr holds any error encountered while attempting to write
 	// logs to CloudWatch Logs. If the writer encounters an error,
 	// and exhausts retry attepmts, it will not continue trying to write logs
+	// until Reset is called or errCooldown has elapsed
 	flushErr error
 
-	// close will receive a message when the writer is closed
-	closed chan struct{}
+	// errorHandler, if set, is called with any error returned by a flush
+	// performed in the background
+	errorHandler func(error)
+
+	// onFlush, if set, is called after each successful PutLogEvents call.
+	// onFlushMu keeps concurrent flushes from calling it at the same time
+	onFlush   func(events, bytes int, token string)
+	onFlushMu sync.Mutex
+
+	// deadLetterFile, if set, is the file to which events that couldn't be
+	// delivered are written
+	deadLetterFile string
+
+	// flushErrAt is the time at which flushErr was set
+	flushErrAt time.Time
+
+	// errCooldown is how long flushErr is held before another flush is
+	// attempted. If zero, flushErr is held until Reset is called
+	errCooldown time.Duration
 
-	// signalFlush will receive a message when the writer wants to trigger a Flush operation
+	// breaker, if set, stops flushes from being attempted after repeated
+	// failures
+	breaker *breaker
+
+	// closed is closed when the writer is closed. stopOnce guards closing it
+	closed   chan struct{}
+	stopOnce sync.Once
+
+	// closeOnce ensures the writer is only shut down once. closeErr is the
+	// result, which later calls to Close return
+	closeOnce sync.Once
+	closeErr  error
+
+	// flusherDone is closed when periodicFlush returns
+	flusherDone chan struct{}
+
+	// signalFlush will receive a message when the writer wants to trigger a Flush operation.
+	// It has a buffer of one so that repeated signals are coalesced into a single flush
 	signalFlush chan struct{}
 
 	// pw and pr (io.Pipe) are used to pipe input delivered to Write to the internal
@@ -87,66 +317,337 @@ type LogWriter struct {
 
 	// sequenceToken is token returned by cloudwatch logs after a PutLogEvents request. This
 	// token is required on all calls to PutLogEvents except the first call to a newly created
-	// log stream.
-	sequenceToken string
+	// log stream. It is only used if sequenceTokens is true; CloudWatch Logs no longer
+	// requires it and ignores it if sent.
+	sequenceToken  string
+	sequenceTokens bool
+
+	// noCreate stops the writer from creating a missing log group or stream
+	noCreate bool
+
+	// streamCreated is set to 1 once the writer has created the log stream or
+	// found that it already exists, and cleared by the next successful
+	// PutLogEvents call. While it is set, a missing stream is assumed not to
+	// be visible yet, so the request is retried without creating it again.
+	// It's accessed atomically, since concurrent flushes may set it.
+	streamCreated int32
+
+	// tokenStore, if set, persists sequenceToken between runs
+	tokenStore TokenStore
+
+	// rejected holds a running count of events that CloudWatch Logs accepted
+	// the request for but declined to store
+	rejected RejectedEvents
+
+	// rejectedErr accumulates the rejected events from every batch, to be
+	// returned by Close
+	rejectedErr *RejectedEventsError
+
+	// logger, if set, receives diagnostic messages
+	logger Logger
+
+	// metrics, if set, receives measurements of the writer's delivery
+	metrics Metrics
+
+	// stats holds running counts of the writer's activity. The buffer counts
+	// are computed when a snapshot is taken
+	stats Stats
 
 	logsClient cloudwatchlogsiface.CloudWatchLogsAPI
+
+	// reuseEvents controls whether events are returned to the pool once they
+	// have been sent. Only clients that don't hold on to them allow it
+	reuseEvents bool
+}
+
+// RejectedEvents holds counts of log events that were rejected by CloudWatch
+// Logs. These events are reported in the PutLogEvents response rather than
+// failing the request, so they are otherwise dropped silently.
+type RejectedEvents struct {
+	// TooOld is the number of events older than the 14-day ingestion limit
+	TooOld int
+
+	// TooNew is the number of events more than 2 hours in the future
+	TooNew int
+
+	// Expired is the number of events older than the log group's retention
+	// period
+	Expired int
 }
 
-// New constructs and returns a new LogWriter
-func New(logGroup, logStream string, client Client) *LogWriter {
+// Total returns the total number of rejected events
+func (r RejectedEvents) Total() int {
+	return r.TooOld + r.TooNew + r.Expired
+}
+
+// New constructs and returns a new LogWriter. Options may be passed to
+// override the writer's default behavior.
+func New(logGroup, logStream string, client Client, opts ...Option) *LogWriter {
+	return NewWithContext(context.Background(), logGroup, logStream, client, opts...)
+}
+
+// NewWithContext constructs and returns a new LogWriter bound to ctx. When ctx
+// is cancelled, any in-flight request to CloudWatch Logs is aborted, the writer
+// stops accepting input, and Close returns promptly.
+func NewWithContext(ctx context.Context, logGroup, logStream string, client Client, opts ...Option) *LogWriter {
 	pr, pw := io.Pipe()
 
 	b := LogWriter{
-		logGroup:    logGroup,
-		logStream:   logStream,
-		pw:          pw,
-		pr:          pr,
-		ticker:      time.NewTicker(2 * time.Second),
-		scanErr:     make(chan error),
-		closed:      make(chan struct{}),
-		signalFlush: make(chan struct{}),
-		logsClient:  client,
+		ctx:            ctx,
+		logGroup:       logGroup,
+		logStream:      logStream,
+		pw:             pw,
+		pr:             pr,
+		flushInterval:  flushInterval,
+		backoff:        newBackoff(maxRetries),
+		limiter:        newLimiter(maxRequestRate),
+		maxBatchBytes:  maxSize,
+		maxBatchEvents: maxEvents,
+		split:          bufio.ScanLines,
+		scanErr:        make(chan error),
+		closed:         make(chan struct{}),
+		flusherDone:    make(chan struct{}),
+		signalFlush:    make(chan struct{}, 1),
+		tickerChanged:  make(chan struct{}, 1),
+		logsClient:     client,
+	}
+
+	// the SDK's client is done with the events passed to PutLogEvents once it
+	// returns. Other clients may keep them, e.g. to record or forward them
+	// later, so their events are never reused
+	_, b.reuseEvents = client.(*cloudwatchlogs.CloudWatchLogs)
+
+	b.bufCond = sync.NewCond(&b.Mutex)
+
+	for _, opt := range opts {
+		opt(&b)
+	}
+
+	// a token given by WithInitialSequenceToken is used instead of the stored
+	// one
+	if b.sequenceTokens && b.tokenStore != nil && b.sequenceToken == "" {
+		// a missing or unreadable token is recovered from the first time
+		// CloudWatch Logs rejects a request without it
+		if token, err := b.tokenStore.Load(); err == nil {
+			b.sequenceToken = token
+		}
+	}
+
+	n := 1
+	if !b.sequenceTokens && b.flushConcurrency > 1 {
+		n = b.flushConcurrency
 	}
+	b.flushSem = make(chan struct{}, n)
+
+	b.ticker = newTicker(b.flushInterval)
+	b.markActive()
 
 	go b.start()
 
 	return &b
 }
 
-// Write implements io.Writer
+// Write implements io.Writer. Log events are sent asynchronously, so a
+// successful Write doesn't mean they were delivered. Once a flush has failed
+// and the writer has stopped sending events, Write returns that error rather
+// than buffering data that will never be sent. A writer with an error
+// cooldown is expected to recover, so its Write keeps accepting data.
 func (w *LogWriter) Write(data []byte) (int, error) {
+	if err := w.stickyErr(); err != nil {
+		return 0, err
+	}
 	return w.pw.Write(data)
 }
 
+// WriteEvent buffers msg as a single event with timestamp ts, for callers
+// that already know when each event happened. It bypasses the scanner that
+// splits written data, so msg isn't split into lines, combined with others by
+// WithMultilinePattern, or searched for a timestamp. Otherwise msg is handled
+// like a written line: it may be filtered, redacted, or wrapped, and a
+// message over the CloudWatch Logs limit is split or truncated. WriteEvent
+// may be called alongside Write, but not once Close has been called.
+func (w *LogWriter) WriteEvent(msg string, ts time.Time) error {
+	return w.addEvent(msg, ts.UnixNano()/int64(time.Millisecond), true)
+}
+
+// AddEvent buffers msg as a single event, for producers that already have
+// discrete records rather than a stream of bytes. Like WriteEvent, it
+// bypasses the scanner, but msg's timestamp is found as a written line's
+// would be. Events added from one goroutine are buffered in the order they
+// were added. Written data is split into lines by a separate goroutine, so
+// an event added after a call to Write may be buffered before that Write's
+// lines; a caller that needs a strict order should use only one of the two.
+func (w *LogWriter) AddEvent(msg string) error {
+	return w.addEvent(msg, 0, false)
+}
+
+// addEvent buffers msg, unless the writer can't accept it. If ok is set, ts is
+// its timestamp.
+func (w *LogWriter) addEvent(msg string, ts int64, ok bool) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	if w.stopped() {
+		return io.ErrClosedPipe
+	}
+	if err := w.stickyErr(); err != nil {
+		return err
+	}
+
+	w.appendEventAt(msg, ts, ok)
+
+	// apply backpressure, as Write does
+	w.waitForDrain()
+	return nil
+}
+
+// stickyErr returns the error of a failed flush that stops the writer until
+// Reset is called
+func (w *LogWriter) stickyErr() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.errCooldown > 0 || w.breaker != nil {
+		return nil
+	}
+	return w.flushErr
+}
+
 // Close implements io.Closer. This method will stop the writer and flush
-// any buffered log events
+// any buffered log events. If no other error occurred but CloudWatch Logs
+// rejected any events, a *RejectedEventsError covering every batch is returned.
+// Calling Close again returns the result of the first call.
 func (w *LogWriter) Close() error {
+	return w.closeContext(context.Background())
+}
+
+// CloseWithTimeout closes the writer like Close, but gives up sending the
+// remaining log events after d, so that a process shutting down can stay
+// within its grace period. It returns the number of events that were not
+// delivered, which is zero if err is nil. Events that a background flush was
+// still sending when d elapsed are counted, although they may yet arrive. If
+// d is not positive, it waits as long as Close would.
+func (w *LogWriter) CloseWithTimeout(d time.Duration) (int, error) {
+	ctx := context.Background()
+	if d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	err := w.closeContext(ctx)
+	if ctx.Err() != nil {
+		err = fmt.Errorf("gave up sending log events after %v: %w", d, ctx.Err())
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	return len(w.buf) + w.inflight, err
+}
+
+// closeContext shuts the writer down the first time it is called, and
+// returns the result of that on every call
+func (w *LogWriter) closeContext(ctx context.Context) error {
+	w.closeOnce.Do(func() {
+		w.closeErr = w.shutdown(ctx)
+	})
+	return w.closeErr
+}
+
+// shutdown stops the writer and flushes the buffer, giving up if ctx is done
+func (w *LogWriter) shutdown(ctx context.Context) error {
 	w.pw.Close()
 	w.stop()
 
-	if err := <-w.scanErr; err != nil {
+	// a pending flush signal may have been picked up just as the writer
+	// stopped. Let that flush finish so nothing is sent after Close returns
+	select {
+	case <-w.flusherDone:
+	case <-ctx.Done():
+	}
+
+	err := <-w.scanErr
+
+	// no more input can arrive to extend a run of repeated messages
+	w.flushRepeats()
+
+	if err == nil {
+		err = w.flushAll(ctx)
+	}
+	if err != nil {
+		// the buffer is kept so that CloseWithTimeout can count it
+		w.Lock()
+		derr := w.writeDeadLetters(w.buf)
+		w.Unlock()
+		if derr != nil {
+			return fmt.Errorf("%w; unable to write undelivered events to %s: %v", err, w.deadLetterFile, derr)
+		}
 		return err
 	}
 
-	return w.flushAll()
+	w.Lock()
+	defer w.Unlock()
+	if w.rejectedErr != nil {
+		return w.rejectedErr
+	}
+	return nil
 }
 
 // Flush writes any buffered log events to CloudWatch Logs
 func (w *LogWriter) Flush() error {
-	if w.flushErr != nil {
-		return w.flushErr
+	return w.FlushContext(context.Background())
+}
+
+// FlushContext writes the next batch of buffered log events to CloudWatch
+// Logs, giving up if ctx or the writer's own context is done. If ctx is done
+// first, the batch is returned to the buffer to be sent by a later flush, so
+// it may be delivered twice if CloudWatch Logs had already accepted it.
+func (w *LogWriter) FlushContext(ctx context.Context) error {
+	ctx, cancel := w.flushContext(ctx)
+	defer cancel()
 
+	// when sequence tokens are in use, flushes are serialized so that each
+	// request carries the token returned by the one before it. The buffer lock is only held while
+	// draining the buffer and recording the result, so Write is not blocked
+	// by a slow or retrying request
+	select {
+	case w.flushSem <- struct{}{}:
+		defer func() { <-w.flushSem }()
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 
 	w.Lock()
-	defer w.Unlock()
+	if w.breaker != nil {
+		// the breaker decides when to try again after a failure, so
+		// flushErr doesn't stop the writer
+		if len(w.buf) > 0 && w.breaker.open() {
+			defer w.Unlock()
+			return fmt.Errorf("%w after %d failed flushes: %v", ErrCircuitOpen, w.breaker.failures, w.flushErr)
+		}
+	} else if w.flushErr != nil {
+		if w.errCooldown <= 0 || time.Since(w.flushErrAt) < w.errCooldown {
+			defer w.Unlock()
+			return w.flushErr
+		}
+
+		// the cooldown has elapsed. Try again, and if this attempt succeeds
+		// the writer has recovered
+		w.flushErr = nil
+	}
 
 	if len(w.buf) == 0 {
+		w.Unlock()
 		return nil
 	}
 
+	size := w.bufSize
 	events := w.drainBuffer()
+	size -= w.bufSize
+	w.inflight += len(events)
+	w.Unlock()
+
+	w.logf("flushing %d events (%d bytes) to %s/%s", len(events), size, w.logGroup, w.logStream)
 
 	input := &cloudwatchlogs.PutLogEventsInput{
 		LogEvents:     events,
@@ -154,85 +655,425 @@ func (w *LogWriter) Flush() error {
 		LogStreamName: &w.logStream,
 	}
 
-	err := retry(func() error {
-		if w.sequenceToken != "" {
+	var (
+		attempts int
+		rejected *cloudwatchlogs.RejectedLogEventsInfo
+		token    string
+
+		// maybeSent is set once an attempt fails in a way that doesn't rule
+		// out CloudWatch Logs having accepted the batch, such as a timeout
+		maybeSent bool
+	)
+	start := time.Now()
+	err := w.backoff.retry(ctx, func() error {
+		attempts++
+
+		if w.sequenceTokens && w.sequenceToken != "" {
 			input.SetSequenceToken(w.sequenceToken)
 		}
 
-		resp, err := w.logsClient.PutLogEvents(input)
+		if err := w.limiter.wait(ctx); err != nil {
+			return noRetry(err)
+		}
+
+		// a retried batch is paid for once, since only delivered bytes are
+		// ingested
+		if attempts == 1 {
+			if err := w.byteLimiter.wait(ctx, size); err != nil {
+				return noRetry(err)
+			}
+		}
+
+		resp, err := w.logsClient.PutLogEventsWithContext(ctx, input)
 		if err != nil {
-			return w.handleError(err)
+			w.logf("PutLogEvents attempt %d failed: %v", attempts, err)
+			if ctx.Err() != nil {
+				return noRetry(err)
+			}
+
+			herr := w.handleError(ctx, err, maybeSent)
+			if _, throttled := herr.(*throttledError); herr != nil && herr != errIgnore && !throttled {
+				maybeSent = true
+			}
+			return herr
+		}
+		if resp == nil {
+			// the batch may or may not have been accepted
+			w.logf("PutLogEvents attempt %d failed: %v", attempts, errNoResponse)
+			maybeSent = true
+			return errNoResponse
 		}
 
-		w.sequenceToken = *resp.NextSequenceToken
+		// the stream is known to exist now. If it's reported missing again,
+		// it has been deleted and must be recreated
+		atomic.StoreInt32(&w.streamCreated, 0)
+
+		// a missing token leaves the current one in place. If it turns out to
+		// be stale, the next request recovers the right one
+		if w.sequenceTokens && resp.NextSequenceToken != nil {
+			w.sequenceToken = *resp.NextSequenceToken
+			w.logf("next sequence token: %s", w.sequenceToken)
+		}
+		rejected = resp.RejectedLogEventsInfo
+		token = aws.StringValue(resp.NextSequenceToken)
 		return nil
 	})
+	elapsed := time.Since(start)
+
+	if err == nil && w.sequenceTokens && w.tokenStore != nil {
+		if serr := w.tokenStore.Save(w.sequenceToken); serr != nil && w.errorHandler != nil {
+			w.errorHandler(serr)
+		}
+	}
+
+	if err == nil && w.onFlush != nil {
+		w.onFlushMu.Lock()
+		w.onFlush(len(events), size, token)
+		w.onFlushMu.Unlock()
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	w.inflight -= len(events)
+	if attempts > 1 {
+		w.recordRetries(attempts - 1)
+	}
+	if err != nil && ctx.Err() != nil && w.ctx.Err() == nil {
+		// only the caller gave up, so the writer hasn't failed. Put the batch
+		// back for the next flush
+		w.requeue(events)
+		w.breaker.abandon()
+		return err
+	}
+	w.recordFlush(size, elapsed)
+
+	var rerr *RejectedEventsError
+	if err != nil {
+		w.recordDropped(len(events))
+		if derr := w.writeDeadLetters(events); derr != nil && w.errorHandler != nil {
+			w.errorHandler(fmt.Errorf("unable to write undelivered events to %s: %w", w.deadLetterFile, derr))
+		}
+	} else {
+		var n int
+		if rerr = w.recordRejected(rejected, len(events)); rerr != nil {
+			n = rerr.Batches[0].distinct()
+		}
+		w.recordSent(len(events) - n)
+		w.stats.SentBytes += size
+		w.stats.SentBatches++
+		w.recordDropped(n)
+		w.markActive()
+	}
+
+	// the request is finished with the events, so they can be reused if the
+	// client is known not to hold on to them
+	if w.reuseEvents {
+		releaseEvents(events)
+	}
 
 	w.flushErr = err
+	w.flushErrAt = time.Now()
+	if w.breaker.record(err) {
+		if err != nil {
+			w.logf("circuit breaker opened after %d failed flushes; retrying in %v", w.breaker.failures, w.breaker.cooldown)
+		} else {
+			w.logf("circuit breaker closed")
+		}
+	}
+
+	// rejected events don't stop the writer, so they aren't held in flushErr
+	if rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+// flushContext returns a context that is done when either ctx or the
+// writer's context is done
+func (w *LogWriter) flushContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx.Done() == nil {
+		return w.ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-w.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// requeue returns events to the front of the buffer. The caller must hold the
+// lock.
+func (w *LogWriter) requeue(events []*cloudwatchlogs.InputLogEvent) {
+	for _, e := range events {
+		w.bufSize += len(*e.Message) + eventSize
+	}
+	w.buf = append(events, w.buf...)
+}
+
+// Reset clears any error encountered by a previous Flush, allowing the writer
+// to resume sending log events. Events that were being sent when the error
+// occurred are not resent.
+func (w *LogWriter) Reset() {
+	w.Lock()
+	defer w.Unlock()
+	w.flushErr = nil
+}
+
+// SetFlushInterval changes how often buffered log events are flushed, e.g. to
+// send them sooner during an incident. The next periodic flush happens d after
+// the call. Intervals that aren't positive are ignored, as are calls once the
+// writer is closed.
+func (w *LogWriter) SetFlushInterval(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.stopped() {
+		return
+	}
+	w.ticker.Stop()
+	w.ticker = newTicker(d)
+	w.flushInterval = d
+
+	select {
+	case w.tickerChanged <- struct{}{}:
+	default:
+	}
+}
+
+// Rejected returns the number of events rejected by CloudWatch Logs so far
+func (w *LogWriter) Rejected() RejectedEvents {
+	w.Lock()
+	defer w.Unlock()
+	return w.rejected
+}
+
+// recordRejected adds the events described by info to the running count of
+// rejected events. n is the number of events in the batch. It returns an error
+// describing the rejected events, or nil if there were none. The caller must
+// hold the lock.
+func (w *LogWriter) recordRejected(info *cloudwatchlogs.RejectedLogEventsInfo, n int) *RejectedEventsError {
+	if info == nil {
+		return nil
+	}
+
+	b := newRejectedBatch(info, n)
+	err := &RejectedEventsError{RejectedEvents: b.counts(), Batches: []RejectedBatch{b}}
+	if err.Total() == 0 {
+		return nil
+	}
+
+	w.rejected.TooOld += err.TooOld
+	w.rejected.TooNew += err.TooNew
+	w.rejected.Expired += err.Expired
+
+	if w.rejectedErr == nil {
+		w.rejectedErr = &RejectedEventsError{}
+	}
+	w.rejectedErr.add(err)
 	return err
 }
 
-func (w *LogWriter) handleError(err error) error {
+// terminalErrors are the codes of errors that retrying won't fix, such as
+// missing permissions, invalid credentials, or a malformed request. They fail
+// the flush immediately. Throttling, server, and network errors are retried.
+var terminalErrors = map[string]bool{
+	cloudwatchlogs.ErrCodeAccessDeniedException:       true,
+	cloudwatchlogs.ErrCodeInvalidOperationException:   true,
+	cloudwatchlogs.ErrCodeInvalidParameterException:   true,
+	cloudwatchlogs.ErrCodeUnrecognizedClientException: true,
+	cloudwatchlogs.ErrCodeValidationException:         true,
+
+	// errors common to all AWS services
+	"AccessDenied":               true,
+	"IncompleteSignature":        true,
+	"InvalidClientTokenId":       true,
+	"InvalidSignatureException":  true,
+	"MissingAuthenticationToken": true,
+	"NoCredentialProviders":      true,
+	"NotAuthorized":              true,
+	"OptInRequired":              true,
+}
+
+// transientError reports whether err is a server error or a failure to
+// reach CloudWatch Logs at all, such as a reset connection or a failed DNS
+// lookup. These say nothing about the request, so it is retried with backoff.
+func transientError(err error) bool {
+	if rf, ok := err.(awserr.RequestFailure); ok && rf.StatusCode() >= 500 {
+		return true
+	}
+
+	// the SDK wraps network errors without supporting errors.Unwrap, so its
+	// chain of original errors is followed by hand
+	for err != nil {
+		var nerr net.Error
+		if errors.As(err, &nerr) {
+			return true
+		}
+
+		aerr, ok := err.(awserr.Error)
+		if !ok {
+			return false
+		}
+		err = aerr.OrigErr()
+	}
+	return false
+}
+
+// clientError reports whether err is a 4xx response that retrying won't
+// fix. Throttling, request timeouts, and expired credentials, which the SDK
+// refreshes before the next attempt, aren't client errors.
+func clientError(err error) bool {
+	rf, ok := err.(awserr.RequestFailure)
+	if !ok {
+		return false
+	}
+
+	code := rf.StatusCode()
+	return code >= 400 && code < 500 && code != http.StatusRequestTimeout &&
+		!request.IsErrorThrottle(err) && !request.IsErrorExpiredCreds(err)
+}
+
+// handleError decides how a failed PutLogEvents attempt is retried. maybeSent
+// reports whether an earlier attempt at the same batch may have been accepted.
+func (w *LogWriter) handleError(ctx context.Context, err error, maybeSent bool) error {
 	if aerr, ok := err.(awserr.Error); ok {
 		switch aerr.Code() {
 		case cloudwatchlogs.ErrCodeDataAlreadyAcceptedException:
-			// data was already accepted
-			if e, ok := err.(*cloudwatchlogs.DataAlreadyAcceptedException); ok {
-				w.sequenceToken = *e.ExpectedSequenceToken
+			// a batch was already accepted with this sequence token. These
+			// are only returned when sequence tokens are in use
+			if e, ok := err.(*cloudwatchlogs.DataAlreadyAcceptedException); ok && w.sequenceTokens {
+				w.sequenceToken = aws.StringValue(e.ExpectedSequenceToken)
+				if maybeSent {
+					w.logf("batch already accepted, next sequence token: %s", w.sequenceToken)
+					return nil
+				}
+
+				// no earlier attempt could have delivered this batch, so
+				// the accepted one was another batch sent with a stale
+				// token. Send this one with the token that follows it
+				w.logf("sequence token already used, retrying with: %s", w.sequenceToken)
+				return errIgnore
 			}
-			return nil
 		case cloudwatchlogs.ErrCodeInvalidSequenceTokenException:
-			if e, ok := err.(*cloudwatchlogs.InvalidSequenceTokenException); ok {
-				w.sequenceToken = *e.ExpectedSequenceToken
+			if e, ok := err.(*cloudwatchlogs.InvalidSequenceTokenException); ok && w.sequenceTokens {
+				w.sequenceToken = aws.StringValue(e.ExpectedSequenceToken)
+				w.logf("retrying with expected sequence token: %s", w.sequenceToken)
+				return errIgnore
 			}
-			return errIgnore
 		case cloudwatchlogs.ErrCodeResourceNotFoundException:
-			if err := w.createLogStream(); err != nil {
+			if w.noCreate {
+				return noRetry(fmt.Errorf("log group %s or log stream %s does not exist, and creating them is disabled: %w", w.logGroup, w.logStream, err))
+			}
+
+			// the stream was just created, so wait for it to appear rather
+			// than creating it again
+			if atomic.LoadInt32(&w.streamCreated) == 1 {
+				return err
+			}
+
+			// errIgnore from createLogStream means the log group had to be
+			// created first. Either way, the next attempt will try again
+			if err := w.createLogStream(ctx); err != nil && err != errIgnore {
 				return noRetry(err)
 			}
 			return errIgnore
+		case cloudwatchlogs.ErrCodeThrottlingException:
+			return throttled(err)
+		}
+
+		if terminalErrors[aerr.Code()] {
+			return noRetry(err)
 		}
 	}
+
+	if request.IsErrorThrottle(err) {
+		return throttled(err)
+	}
+	if transientError(err) {
+		return err
+	}
+	if clientError(err) {
+		return noRetry(err)
+	}
 	return err
 }
 
-func (w *LogWriter) createLogStream() error {
+func (w *LogWriter) createLogStream(ctx context.Context) error {
 	lsInput := cloudwatchlogs.CreateLogStreamInput{
 		LogGroupName:  &w.logGroup,
 		LogStreamName: &w.logStream,
 	}
 
-	_, err := w.logsClient.CreateLogStream(&lsInput)
+	w.logf("creating log stream %s/%s", w.logGroup, w.logStream)
+	_, err := w.logsClient.CreateLogStreamWithContext(ctx, &lsInput)
 	if err != nil {
-		if ae, ok := err.(awserr.Error); ok {
-			switch ae.Code() {
-			case cloudwatchlogs.ErrCodeResourceAlreadyExistsException:
-				// Resource already created is ok
-			case cloudwatchlogs.ErrCodeResourceNotFoundException:
-				if err := w.createLogGroup(); err != nil {
-					return err
-				}
+		ae, ok := err.(awserr.Error)
+		if !ok {
+			return err
+		}
 
-				// retry creating the log stream
-				return errIgnore
-			default:
+		switch ae.Code() {
+		case cloudwatchlogs.ErrCodeResourceAlreadyExistsException, cloudwatchlogs.ErrCodeOperationAbortedException:
+			// Resource already created is ok. An aborted operation means
+			// another writer is creating it at the same time
+		case cloudwatchlogs.ErrCodeResourceNotFoundException:
+			if err := w.createLogGroup(ctx); err != nil {
 				return err
 			}
+
+			// retry creating the log stream
+			return errIgnore
+		default:
+			return err
 		}
 	}
 
+	atomic.StoreInt32(&w.streamCreated, 1)
 	return nil
 }
 
-func (w *LogWriter) createLogGroup() error {
+func (w *LogWriter) createLogGroup(ctx context.Context) error {
 	lgInput := cloudwatchlogs.CreateLogGroupInput{
 		LogGroupName: &w.logGroup,
 	}
+	if len(w.tags) > 0 {
+		lgInput.Tags = w.tags
+	}
+	if w.kmsKeyID != "" {
+		lgInput.KmsKeyId = &w.kmsKeyID
+	}
 
-	_, err := w.logsClient.CreateLogGroup(&lgInput)
+	w.logf("creating log group %s", w.logGroup)
+	_, err := w.logsClient.CreateLogGroupWithContext(ctx, &lgInput)
 	if err != nil {
-		// Resource already created is ok. Otherwise, return the error
-		if ae, ok := err.(awserr.Error); !ok || ae.Code() != cloudwatchlogs.ErrCodeResourceAlreadyExistsException {
+		// Resource already created is ok, as is an aborted operation, which
+		// means another writer is creating it at the same time. Otherwise,
+		// return the error
+		ae, ok := err.(awserr.Error)
+		if !ok || (ae.Code() != cloudwatchlogs.ErrCodeResourceAlreadyExistsException && ae.Code() != cloudwatchlogs.ErrCodeOperationAbortedException) {
+			return err
+		}
+
+		// the group was created elsewhere, so leave its settings alone
+		return nil
+	}
+
+	if w.retentionDays > 0 {
+		_, err := w.logsClient.PutRetentionPolicyWithContext(ctx, &cloudwatchlogs.PutRetentionPolicyInput{
+			LogGroupName:    &w.logGroup,
+			RetentionInDays: aws.Int64(int64(w.retentionDays)),
+		})
+		if err != nil {
 			return err
 		}
 	}
@@ -240,25 +1081,58 @@ func (w *LogWriter) createLogGroup() error {
 	return nil
 }
 
+// drainBuffer removes the next batch of events from the buffer. The caller
+// must hold the lock.
 func (w *LogWriter) drainBuffer() []*cloudwatchlogs.InputLogEvent {
 	var (
-		size   int
-		cnt    int
-		events []*cloudwatchlogs.InputLogEvent
+		size     int
+		events   []*cloudwatchlogs.InputLogEvent
+		min, max int64
 	)
 
 	for _, e := range w.buf {
-		if size > maxSize || len(events) >= maxEvents {
+		n := len(*e.Message) + eventSize
+		ts := *e.Timestamp
+
+		// stop before the event that would take the batch over the limit. The
+		// first event is always taken so that the buffer can be drained even
+		// if the limit is smaller than a single event
+		if len(events) > 0 && (len(events) >= w.maxBatchEvents || size+n > w.maxBatchBytes) {
 			break
 		}
 
-		size += len(*e.Message) + eventSize
+		// events with parsed timestamps can be far apart, and CloudWatch Logs
+		// rejects a batch spanning more than 24 hours
+		if len(events) == 0 {
+			min, max = ts, ts
+		} else if ts < min && max-ts > maxBatchSpan || ts > max && ts-min > maxBatchSpan {
+			break
+		}
+		if ts < min {
+			min = ts
+		}
+		if ts > max {
+			max = ts
+		}
+
+		size += n
 		events = append(events, e)
-		cnt++
 	}
 
-	w.buf = w.buf[cnt:]
+	// size counts exactly the events taken, so bufSize remains the total of
+	// the events left behind
+	for i := range events {
+		w.buf[i] = nil
+	}
+	w.buf = w.buf[len(events):]
 	w.bufSize -= size
+	w.bufCond.Broadcast()
+
+	// CloudWatch Logs rejects batches that are not in chronological order. The
+	// sort is stable so that events sharing a timestamp keep their arrival order
+	sort.SliceStable(events, func(i, j int) bool {
+		return *events[i].Timestamp < *events[j].Timestamp
+	})
 
 	return events
 }
@@ -266,57 +1140,348 @@ func (w *LogWriter) drainBuffer() []*cloudwatchlogs.InputLogEvent {
 func (w *LogWriter) start() {
 	go w.readLines()
 	go w.periodicFlush()
+	go w.watchContext()
+}
+
+// watchContext stops the scanner when the writer's context is cancelled. Any
+// subsequent Write will return the context's error.
+func (w *LogWriter) watchContext() {
+	select {
+	case <-w.ctx.Done():
+		w.pr.CloseWithError(w.ctx.Err())
+		w.wakeWaiters()
+	case <-w.closed:
+	}
 }
 
 func (w *LogWriter) readLines() {
 	sc := bufio.NewScanner(w.pr)
-	sc.Split(bufio.ScanLines)
+	sc.Buffer(nil, maxLineSize)
+	sc.Split(w.split)
+
+	// a record ending in CRLF leaves a stray carriage return, unless the split
+	// function already removed it
+	trimCR := !w.keepCR && !isScanLines(w.split)
+
 	for sc.Scan() {
-		w.appendEvent(sc.Text())
+		text := sc.Text()
+		if trimCR {
+			text = strings.TrimSuffix(text, "\r")
+		}
+
+		if w.multiline == nil {
+			w.appendEvent(text)
+		} else {
+			w.multiline.add(text, w.appendEvent)
+		}
+
+		// stop reading from the pipe while the buffer is too full, so that
+		// Write blocks
+		w.waitForDrain()
 	}
 
-	w.scanErr <- sc.Err()
+	if w.multiline != nil {
+		w.multiline.flush(w.appendEvent)
+	}
+
+	// unblock any pending or future Write if the scanner gave up early
+	err := sc.Err()
+	if err != nil {
+		w.pr.CloseWithError(err)
+	}
+	w.scanErr <- err
 }
 
 func (w *LogWriter) appendEvent(text string) {
+	w.appendEventAt(text, 0, false)
+}
+
+// appendEventAt buffers text as an event. If ok is set, ts is its timestamp
+// and text is not searched for one.
+func (w *LogWriter) appendEventAt(text string, ts int64, ok bool) {
+	if w.stripANSI {
+		text = stripANSI(text)
+	}
+
+	if w.trimSpace {
+		if text = strings.TrimSpace(text); text == "" {
+			return
+		}
+	}
+
+	if (w.include != nil && !w.include.MatchString(text)) || (w.exclude != nil && w.exclude.MatchString(text)) {
+		return
+	}
+
+	// the timestamp column is removed before the line is modified further,
+	// so that redaction and truncation can't disturb it
+	if !ok && w.timestampColumn != nil {
+		text, ts, ok = w.timestampColumn.extract(text)
+	}
+
+	// redact before anything is buffered, so the original text is never sent
+	text = w.redact(text)
+
+	if w.maxLineBytes > 0 {
+		text = truncateLine(text, w.maxLineBytes)
+	}
+
+	// the timestamp is parsed before the prefix is added, since it is
+	// usually found at the start of the line
+	if !ok {
+		ts, ok = w.parseTimestamp(text)
+	}
+
+	// a repeated message is counted rather than buffered. Whatever run it
+	// ends, if any, is buffered in its place
+	if w.repeats != nil {
+		t := w.now()
+		if !ok {
+			ts, ok = t, true
+		}
+		r, held := w.repeats.add(text, ts, t)
+		if !held {
+			return
+		}
+		w.wrapEvent(r.msg, r.ts, true, r.count)
+		return
+	}
+
+	w.wrapEvent(text, ts, ok, 1)
+}
+
+// wrapEvent wraps text in the configured envelopes, adds the prefix and
+// suffix, and buffers it. If repeated is more than 1, the text stands for that
+// many identical lines, and the count is added to the first JSON object the
+// event is put in, or as a prefix if it is sent as plain text.
+func (w *LogWriter) wrapEvent(text string, ts int64, ok bool, repeated int) {
+	if repeated < 2 {
+		repeated = 0
+	}
+
+	// the EMF envelope carries the event's timestamp, so it must be settled
+	// before the event is buffered
+	if w.emf != nil {
+		if !ok {
+			ts, ok = w.now(), true
+		}
+		var wrapped bool
+		if text, wrapped = w.emf.wrap(text, ts, repeated, maxEventSize-eventSize); wrapped {
+			repeated = 0
+		}
+	}
+
+	// the prefix and suffix count towards the size limits, so they are added
+	// before the message is split. A plain-text line is wrapped in JSON with
+	// them, but whether it is plain text is decided without them
+	jsonEnvelope := w.enricher != nil && w.enricher.format == EnrichJSON
+	if w.jsonOutput != nil && repeated > 0 && json.Valid([]byte(text)) {
+		// a JSON object holds the count in a field of its own. Any other JSON
+		// value is wrapped like a plain-text line, so that it has somewhere to
+		// hold it
+		if obj, added := withRepeatedField(text, repeated); added {
+			text, repeated = obj, 0
+		}
+	}
+	switch {
+	case w.jsonOutput != nil && (repeated > 0 || !json.Valid([]byte(text))):
+		if !ok {
+			ts, ok = w.now(), true
+		}
+		text = w.jsonOutput.wrap(w.prefix+text+w.suffix, ts, repeated, maxEventSize-eventSize)
+		repeated = 0
+	case repeated > 0 && !jsonEnvelope:
+		text = w.prefix + annotateRepeated(text, repeated) + w.suffix
+		repeated = 0
+	default:
+		text = w.prefix + text + w.suffix
+	}
+
+	if w.enricher != nil {
+		text = w.enricher.wrap(text, repeated, maxEventSize-eventSize)
+	}
+
 	if text == "" {
 		text = "\u0000"
 	}
 
+	w.bufferEvent(text, ts, ok)
+}
+
+// bufferEvent adds text to the buffer as one or more events. If ok is set, ts
+// is their timestamp; otherwise the current time is used.
+func (w *LogWriter) bufferEvent(text string, ts int64, ok bool) {
+	// messages over the per-event limit are split into contiguous events or
+	// truncated. Either way, every resulting event shares the same timestamp
+	var messages []string
+	if limit := maxEventSize - eventSize; w.truncate || w.neverSplit {
+		messages = []string{truncateMessage(text, limit, truncatedMarker)}
+	} else {
+		messages = splitMessage(text, limit)
+	}
+
 	w.Lock()
 	defer w.Unlock()
-	w.buf = append(w.buf, &cloudwatchlogs.InputLogEvent{
-		Message:   &text,
-		Timestamp: aws.Int64(now()),
-	})
 
-	w.bufSize += len(text) + 26
+	if w.overflow == Block {
+		var size int
+		for _, m := range messages {
+			size += len(m) + eventSize
+		}
+		w.waitForSpace(size)
+	}
+
+	if !ok {
+		ts = w.now()
+	}
+	w.markActive()
+	if len(w.buf) == 0 && w.holdsBatches() {
+		w.bufferedSince = w.now()
+	}
+	for i := range messages {
+		w.buf = append(w.buf, newEvent(&messages[i], ts))
+
+		w.bufSize += len(messages[i]) + eventSize
+	}
+
+	if w.overflow == DropOldest {
+		w.dropOldest()
+	}
+
+	if w.bufSize >= w.maxBatchBytes || len(w.buf) >= w.maxBatchEvents {
+		w.triggerFlush()
+	}
+}
+
+// now returns the current time in milliseconds since the epoch, according to
+// the writer's clock
+func (w *LogWriter) now() int64 {
+	if w.clock != nil {
+		return w.clock()
+	}
+	return now()
+}
+
+// triggerFlush signals periodicFlush to flush the buffer without waiting for
+// the next tick. signalFlush is buffered, so if a flush is already pending the
+// signal is coalesced with it rather than blocking the caller.
+func (w *LogWriter) triggerFlush() {
+	select {
+	case w.signalFlush <- struct{}{}:
+	default:
+	}
 }
 
 func (w *LogWriter) periodicFlush() {
+	defer close(w.flusherDone)
+
+	w.Lock()
+	tick := w.ticker.C
+	w.Unlock()
+
 	for {
 		select {
-		case <-w.ticker.C:
-			w.Flush()
+		case <-tick:
+			w.expireMultiline()
+			w.expireRepeats()
+			w.heartbeat()
+			if !w.holdBatch() {
+				w.backgroundFlush()
+			}
 		case <-w.signalFlush:
-			w.Flush()
+			w.backgroundFlush()
+		case <-w.tickerChanged:
+			w.Lock()
+			tick = w.ticker.C
+			w.Unlock()
 		case <-w.closed:
 			return
+		case <-w.ctx.Done():
+			return
 		}
 	}
 }
 
+// backgroundFlush flushes the buffer and reports any error to the error
+// handler, since there is no caller to return it to
+func (w *LogWriter) backgroundFlush() {
+	// the failure that opened the breaker was already reported
+	if err := w.Flush(); err != nil && w.errorHandler != nil && !errors.Is(err, ErrCircuitOpen) {
+		w.errorHandler(err)
+	}
+}
+
 func (w *LogWriter) stop() {
-	w.ticker.Stop()
-	w.closed <- struct{}{}
+	w.stopOnce.Do(func() {
+		// the ticker is stopped and the writer marked closed under the lock,
+		// so SetFlushInterval can't start a ticker that is never stopped
+		w.Lock()
+		w.ticker.Stop()
+		close(w.closed)
+		w.Unlock()
+
+		w.wakeWaiters()
+	})
 }
 
-func (w *LogWriter) flushAll() error {
-	for len(w.buf) > 0 {
-		if err := w.Flush(); err != nil {
-			return err
+// wakeWaiters wakes any appendEvent call waiting for room in the buffer so
+// that it can observe that the writer has stopped
+func (w *LogWriter) wakeWaiters() {
+	w.Lock()
+	defer w.Unlock()
+	w.bufCond.Broadcast()
+}
+
+// flushAll flushes the buffer until it is empty, giving up if ctx is done
+func (w *LogWriter) flushAll(ctx context.Context) error {
+	n := cap(w.flushSem)
+	errs := make(chan error, n)
+
+	// every flush removes at least one event from the buffer, and nothing
+	// is added once the writer is stopped, so this many flushes is enough.
+	// Running out means the buffer isn't draining
+	budget := int64(w.PendingEvents())
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			for w.PendingEvents() > 0 {
+				if atomic.AddInt64(&budget, -1) < 0 {
+					errs <- errFlushStalled
+					return
+				}
+				if err := w.FlushContext(ctx); err != nil {
+					if _, ok := err.(*RejectedEventsError); ok {
+						continue
+					}
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	// a background flush may still be sending events it drained from the
+	// buffer. Wait for it to finish
+	var held int
+wait:
+	for ; held < n; held++ {
+		select {
+		case w.flushSem <- struct{}{}:
+		case <-ctx.Done():
+			break wait
 		}
 	}
+	for i := 0; i < held; i++ {
+		<-w.flushSem
+	}
 
-	return nil
+	if err := <-errs; err != nil {
+		return err
+	}
+	return ctx.Err()
 }
@@ -1,62 +1,45 @@
 package writer
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net"
+	"net/url"
+	"os"
 	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/kylemcc/cwlog/writer/cwlogtest"
 )
 
-type mockLogsAPI struct {
-	cloudwatchlogsiface.CloudWatchLogsAPI
-	seq    int
-	events []*cloudwatchlogs.InputLogEvent
-}
-
-// PutLogEvents implements cloudwatchlogsiface.CloudWatchLogsAPI
-func (m *mockLogsAPI) PutLogEvents(input *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
-	m.events = append(m.events, input.LogEvents...)
-	m.seq++
-	return &cloudwatchlogs.PutLogEventsOutput{
-		NextSequenceToken: aws.String(strconv.Itoa(m.seq)),
-	}, nil
-}
-
-func newLogsCLientTest() *mockLogsAPI {
-	return &mockLogsAPI{}
-}
-
-type mockStdin struct {
-	cnt  int
-	data [][]byte
+func newLogsCLientTest() *cwlogtest.Client {
+	return cwlogtest.NewClient()
 }
 
 func newTestInput(input [][]byte) io.Reader {
-	return &mockStdin{data: input}
-}
-
-// Read implements io.Reader
-func (m *mockStdin) Read(b []byte) (int, error) {
-	if m.cnt >= len(m.data) {
-		return 0, io.EOF
-	}
-
-	d := m.data[m.cnt]
-	copy(b, d)
-	m.cnt++
-	return len(d), nil
+	return cwlogtest.NewChunkReader(input...)
 }
 
 func mockNow() func() int64 {
-	cnt := int64(0)
-	return func() int64 {
-		cnt++
-		return cnt
-	}
+	return cwlogtest.NewClock()
 }
 
 func TestWriter(t *testing.T) {
@@ -168,9 +151,3124 @@ func TestWriter(t *testing.T) {
 				t.Fatalf("unexpected error: %v", err)
 			}
 
-			if !reflect.DeepEqual(c.expected, logsClient.events) {
-				t.Errorf("log events did not matchc: got=%#v want=%#v", logsClient.events, c.expected)
+			if !reflect.DeepEqual(c.expected, logsClient.Events) {
+				t.Errorf("log events did not matchc: got=%#v want=%#v", logsClient.Events, c.expected)
+			}
+		})
+	}
+}
+
+func TestWriterFlushesFullBatch(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient)
+
+	input := bytes.Repeat([]byte("x\n"), maxEvents+1)
+	if _, err := w.Write(input); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the ticker won't fire for another 2 seconds, so any call made before
+	// then must have been triggered by the full batch
+	deadline := time.Now().Add(time.Second)
+	for logsClient.Calls() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("full batch was not flushed before the next tick")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := logsClient.Calls(); got < 2 {
+		t.Errorf("unexpected number of PutLogEvents calls: got=%d want>=2", got)
+	}
+	if got := len(logsClient.Events); got != maxEvents+1 {
+		t.Errorf("unexpected number of events: got=%d want=%d", got, maxEvents+1)
+	}
+}
+
+func TestWriterSetFlushInterval(t *testing.T) {
+	now = mockNow()
+
+	// each ticker is ticked by hand, and intervals records the period each
+	// was created with
+	var (
+		mu        sync.Mutex
+		ticks     []chan time.Time
+		intervals []time.Duration
+	)
+	newTicker = func(d time.Duration) *time.Ticker {
+		mu.Lock()
+		defer mu.Unlock()
+
+		c := make(chan time.Time)
+		ticks = append(ticks, c)
+		intervals = append(intervals, d)
+
+		ticker := time.NewTicker(time.Hour)
+		ticker.C = c
+		return ticker
+	}
+	defer func() { newTicker = time.NewTicker }()
+
+	// tick ticks the latest ticker. The second, empty tick is only received
+	// once the first tick's flush has finished
+	tick := func() {
+		mu.Lock()
+		c := ticks[len(ticks)-1]
+		mu.Unlock()
+
+		c <- time.Now()
+		c <- time.Now()
+	}
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithFlushInterval(time.Hour))
+
+	w.appendEvent("first")
+	tick()
+	if calls := logsClient.Calls(); calls != 1 {
+		t.Fatalf("unexpected number of flushes before the interval was changed: %d", calls)
+	}
+
+	w.SetFlushInterval(10 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		w.appendEvent("event")
+		tick()
+	}
+	if calls := logsClient.Calls(); calls != 3 {
+		t.Fatalf("unexpected number of flushes after the interval was changed: %d", calls)
+	}
+
+	// intervals that aren't positive are ignored
+	w.SetFlushInterval(0)
+	w.SetFlushInterval(-time.Second)
+	w.SetFlushInterval(time.Hour)
+
+	w.appendEvent("last")
+	tick()
+	if calls := logsClient.Calls(); calls != 4 {
+		t.Fatalf("unexpected number of flushes after the interval was lengthened: %d", calls)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.SetFlushInterval(time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if expected := []time.Duration{time.Hour, 10 * time.Millisecond, time.Hour}; !reflect.DeepEqual(expected, intervals) {
+		t.Errorf("unexpected ticker intervals: got=%v want=%v", intervals, expected)
+	}
+	if w.flushInterval != time.Hour {
+		t.Errorf("unexpected flush interval: %v", w.flushInterval)
+	}
+}
+
+func TestWriterSortsEvents(t *testing.T) {
+	timestamps := []int64{3, 1, 2, 1, 3}
+	now = func() int64 {
+		ts := timestamps[0]
+		timestamps = timestamps[1:]
+		return ts
+	}
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient)
+
+	if _, err := w.Write([]byte("a\nb\nc\nd\ne\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("b"), Timestamp: aws.Int64(1)},
+		{Message: aws.String("d"), Timestamp: aws.Int64(1)},
+		{Message: aws.String("c"), Timestamp: aws.Int64(2)},
+		{Message: aws.String("a"), Timestamp: aws.Int64(3)},
+		{Message: aws.String("e"), Timestamp: aws.Int64(3)},
+	}
+
+	if !reflect.DeepEqual(expected, logsClient.Events) {
+		t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+	}
+}
+
+func TestWriterBatchSpan(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithFlushInterval(time.Hour))
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, ts := range []time.Time{start, start.Add(time.Hour), start.Add(48 * time.Hour)} {
+		if err := w.WriteEvent("event", ts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// CloudWatch Logs rejects a batch spanning more than 24 hours
+	if expected := []int{2, 1}; !reflect.DeepEqual(logsClient.Batches, expected) {
+		t.Errorf("batches did not match: got=%v want=%v", logsClient.Batches, expected)
+	}
+}
+
+func TestWriterRejectedEvents(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	logsClient.Rejected = &cloudwatchlogs.RejectedLogEventsInfo{
+		TooOldLogEventEndIndex:   aws.Int64(2),
+		TooNewLogEventStartIndex: aws.Int64(4),
+		ExpiredLogEventEndIndex:  aws.Int64(1),
+	}
+	w := New("group", "stream", logsClient)
+
+	if _, err := w.Write([]byte("a\nb\nc\nd\ne\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := w.Close()
+	rerr, ok := err.(*RejectedEventsError)
+	if !ok {
+		t.Fatalf("expected a *RejectedEventsError, got: %v", err)
+	}
+
+	expected := RejectedEvents{TooOld: 2, TooNew: 1, Expired: 1}
+	if got := w.Rejected(); got != expected {
+		t.Errorf("rejected events did not match: got=%+v want=%+v", got, expected)
+	}
+	if rerr.RejectedEvents != expected {
+		t.Errorf("error counts did not match: got=%+v want=%+v", rerr.RejectedEvents, expected)
+	}
+
+	batches := []RejectedBatch{{Size: 5, TooOldEnd: 2, TooNewStart: 4, ExpiredEnd: 1}}
+	if !reflect.DeepEqual(rerr.Batches, batches) {
+		t.Errorf("error batches did not match: got=%+v want=%+v", rerr.Batches, batches)
+	}
+
+	if stats := w.Stats(); stats.SentEvents != 2 || stats.DroppedEvents != 3 {
+		t.Errorf("unexpected stats: sent=%d dropped=%d", stats.SentEvents, stats.DroppedEvents)
+	}
+}
+
+func TestWriterRejectedEventsAcrossBatches(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	logsClient.Rejected = &cloudwatchlogs.RejectedLogEventsInfo{
+		TooOldLogEventEndIndex:   aws.Int64(1),
+		TooNewLogEventStartIndex: aws.Int64(2),
+	}
+	w := New("group", "stream", logsClient, WithMaxBatchEvents(3))
+
+	if _, err := w.Write([]byte("a\nb\nc\nd\ne\nf\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := w.Close()
+	rerr, ok := err.(*RejectedEventsError)
+	if !ok {
+		t.Fatalf("expected a *RejectedEventsError, got: %v", err)
+	}
+
+	expected := RejectedEvents{TooOld: 2, TooNew: 2}
+	if rerr.RejectedEvents != expected {
+		t.Errorf("error counts did not match: got=%+v want=%+v", rerr.RejectedEvents, expected)
+	}
+
+	batch := RejectedBatch{Size: 3, TooOldEnd: 1, TooNewStart: 2}
+	if len(rerr.Batches) != 2 || rerr.Batches[0] != batch || rerr.Batches[1] != batch {
+		t.Errorf("error batches did not match: got=%+v", rerr.Batches)
+	}
+
+	if stats := w.Stats(); stats.SentEvents != 2 || stats.DroppedEvents != 4 {
+		t.Errorf("unexpected stats: sent=%d dropped=%d", stats.SentEvents, stats.DroppedEvents)
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	w := New("group", "stream", newLogsCLientTest())
+	defer w.Close()
+
+	if w.flushInterval != flushInterval || w.backoff.attempts != maxRetries || w.maxBatchBytes != maxSize || w.maxBatchEvents != maxEvents {
+		t.Errorf("unexpected defaults: flushInterval=%v maxRetries=%d maxBatchBytes=%d maxBatchEvents=%d", w.flushInterval, w.backoff.attempts, w.maxBatchBytes, w.maxBatchEvents)
+	}
+
+	w = New("group", "stream", newLogsCLientTest(),
+		WithFlushInterval(500*time.Millisecond),
+		WithMaxRetries(2),
+		WithMaxBatchBytes(2*maxSize),
+		WithMaxBatchEvents(2*maxEvents),
+	)
+	defer w.Close()
+
+	if w.flushInterval != 500*time.Millisecond || w.backoff.attempts != 2 || w.maxBatchBytes != maxSize || w.maxBatchEvents != maxEvents {
+		t.Errorf("options not applied: flushInterval=%v maxRetries=%d maxBatchBytes=%d maxBatchEvents=%d", w.flushInterval, w.backoff.attempts, w.maxBatchBytes, w.maxBatchEvents)
+	}
+}
+
+func TestWriterContextCancel(t *testing.T) {
+	now = mockNow()
+
+	started := make(chan struct{})
+	logsClient := newLogsCLientTest()
+	logsClient.PutHook = func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := NewWithContext(ctx, "group", "stream", logsClient, WithFlushInterval(10*time.Millisecond))
+
+	if _, err := w.Write([]byte("test input\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("flush did not start")
+	}
+
+	cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- w.Close()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return after the context was cancelled")
+	}
+}
+
+func TestWriterThrottled(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	logsClient.PutHook = cwlogtest.FailCalls(2, cwlogtest.Throttled())
+
+	w := New("group", "stream", logsClient)
+	w.backoff.sleep = func(context.Context, time.Duration) error { return nil }
+
+	if _, err := w.Write([]byte("test input\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("test input"), Timestamp: aws.Int64(1)},
+	}
+	if !reflect.DeepEqual(expected, logsClient.Events) {
+		t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+	}
+	if calls := len(logsClient.Tokens); calls != 3 {
+		t.Errorf("unexpected number of calls: got=%d want=3", calls)
+	}
+}
+
+func TestWriterTerminalErrors(t *testing.T) {
+	cases := []struct {
+		code     string
+		terminal bool
+	}{
+		{cloudwatchlogs.ErrCodeAccessDeniedException, true},
+		{cloudwatchlogs.ErrCodeInvalidParameterException, true},
+		{cloudwatchlogs.ErrCodeUnrecognizedClientException, true},
+		{"NoCredentialProviders", true},
+		{cloudwatchlogs.ErrCodeServiceUnavailableException, false},
+		{cloudwatchlogs.ErrCodeThrottlingException, false},
+		{"InternalFailure", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.code, func(t *testing.T) {
+			now = mockNow()
+
+			errFail := awserr.New(c.code, "failed", nil)
+			logsClient := newLogsCLientTest()
+			logsClient.PutHook = cwlogtest.FailCalls(10, errFail)
+
+			w := New("group", "stream", logsClient, WithFlushInterval(time.Hour), WithMaxRetries(3))
+			defer w.Close()
+
+			var sleeps int
+			w.backoff.sleep = func(context.Context, time.Duration) error {
+				sleeps++
+				return nil
+			}
+
+			w.appendEvent("test input")
+			if err := w.Flush(); err != errFail {
+				t.Errorf("unexpected error: got=%v want=%v", err, errFail)
+			}
+
+			calls, wantCalls, wantSleeps := len(logsClient.Tokens), 3, 2
+			if c.terminal {
+				wantCalls, wantSleeps = 1, 0
+			}
+			if calls != wantCalls || sleeps != wantSleeps {
+				t.Errorf("unexpected attempts: calls=%d sleeps=%d want calls=%d sleeps=%d", calls, sleeps, wantCalls, wantSleeps)
+			}
+		})
+	}
+}
+
+func TestWriterRequestFailures(t *testing.T) {
+	connReset := awserr.New(request.ErrCodeRequestError, "send request failed", &url.Error{
+		Op:  "Post",
+		URL: "https://logs.us-east-1.amazonaws.com/",
+		Err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET},
+	})
+
+	cases := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"503", awserr.NewRequestFailure(awserr.New("ServiceUnavailable", "unavailable", nil), 503, "id"), true},
+		{"500", awserr.NewRequestFailure(awserr.New("InternalFailure", "internal error", nil), 500, "id"), true},
+		{"connection reset", connReset, true},
+		{"dns failure", awserr.New(request.ErrCodeRequestError, "send request failed", &net.DNSError{Err: "no such host", Name: "logs.example.com"}), true},
+		{"429", awserr.NewRequestFailure(awserr.New("TooManyRequestsException", "slow down", nil), 429, "id"), true},
+		{"408", awserr.NewRequestFailure(awserr.New("RequestTimeout", "timed out", nil), 408, "id"), true},
+		{"expired credentials", awserr.NewRequestFailure(awserr.New("ExpiredTokenException", "expired", nil), 400, "id"), true},
+		{"400", awserr.NewRequestFailure(awserr.New("SerializationException", "bad request", nil), 400, "id"), false},
+		{"404", awserr.NewRequestFailure(awserr.New("UnknownOperationException", "not found", nil), 404, "id"), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+
+			logsClient := newLogsCLientTest()
+			logsClient.PutHook = cwlogtest.FailCalls(3, c.err)
+
+			w := New("group", "stream", logsClient, WithFlushInterval(time.Hour), WithMaxRetries(4), WithRequestRate(0))
+			defer w.Close()
+
+			var sleeps []time.Duration
+			w.backoff.jitter = func(d time.Duration) time.Duration { return d }
+			w.backoff.sleep = func(_ context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return nil
+			}
+
+			w.appendEvent("test input")
+			err := w.Flush()
+
+			if !c.retryable {
+				if err != c.err || len(logsClient.Tokens) != 1 || len(sleeps) != 0 {
+					t.Errorf("unexpected result: err=%v calls=%d sleeps=%v", err, len(logsClient.Tokens), sleeps)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(logsClient.Tokens) != 4 || len(sleeps) != 3 {
+				t.Fatalf("unexpected attempts: calls=%d sleeps=%v", len(logsClient.Tokens), sleeps)
+			}
+			for i := 1; i < len(sleeps); i++ {
+				if sleeps[i] != 2*sleeps[i-1] {
+					t.Errorf("backoff is not exponential: %v", sleeps)
+				}
+			}
+		})
+	}
+}
+
+// nilOutputClient returns neither an output nor an error from its first
+// PutLogEvents calls
+type nilOutputClient struct {
+	*cwlogtest.Client
+	nils int32
+}
+
+// PutLogEventsWithContext implements cloudwatchlogsiface.CloudWatchLogsAPI
+func (c *nilOutputClient) PutLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.PutLogEventsInput, opts ...request.Option) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	if atomic.AddInt32(&c.nils, -1) >= 0 {
+		return nil, nil
+	}
+	return c.Client.PutLogEventsWithContext(ctx, input, opts...)
+}
+
+func TestWriterNilOutput(t *testing.T) {
+	now = mockNow()
+
+	logsClient := &nilOutputClient{Client: newLogsCLientTest(), nils: 2}
+	w := New("group", "stream", logsClient, WithSequenceTokens(true), WithFlushInterval(time.Hour))
+
+	var sleeps int
+	w.backoff.sleep = func(context.Context, time.Duration) error {
+		sleeps++
+		return nil
+	}
+
+	w.appendEvent("event")
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if sleeps != 2 {
+		t.Errorf("unexpected number of retries: %d", sleeps)
+	}
+	if got := logsClient.Messages(); !reflect.DeepEqual(got, []string{"event"}) {
+		t.Errorf("unexpected messages: %v", got)
+	}
+}
+
+func TestWriterRequestRate(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient)
+	defer w.Close()
+
+	start := time.Unix(0, 0)
+	clock := start
+	w.limiter.now = func() time.Time { return clock }
+	w.limiter.sleep = func(_ context.Context, d time.Duration) error {
+		clock = clock.Add(d)
+		return nil
+	}
+
+	for i := 0; i < 10; i++ {
+		w.appendEvent("test input")
+		if err := w.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if got := logsClient.Calls(); got != 10 {
+		t.Errorf("unexpected number of PutLogEvents calls: got=%d want=10", got)
+	}
+	if elapsed := clock.Sub(start); elapsed < 1800*time.Millisecond {
+		t.Errorf("flushes were not rate limited: 10 calls took %v", elapsed)
+	}
+}
+
+func TestWriterMaxThroughput(t *testing.T) {
+	now = mockNow()
+
+	const rate = 2000
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithFlushInterval(time.Hour), WithRequestRate(0), WithMaxThroughput(rate))
+	defer w.Close()
+
+	start := time.Unix(0, 0)
+	clock := start
+	w.byteLimiter.now = func() time.Time { return clock }
+	w.byteLimiter.sleep = func(_ context.Context, d time.Duration) error {
+		clock = clock.Add(d)
+		return nil
+	}
+
+	// each batch is a single 1000 byte event, including the per-event
+	// overhead
+	var sent int
+	logsClient.PutHook = func(context.Context) error {
+		sent += 1000
+		return nil
+	}
+
+	line := strings.Repeat("x", 1000-eventSize)
+	for i := 0; i < 20; i++ {
+		w.appendEvent(line)
+		if err := w.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// a one second burst is allowed up front
+		if limit := rate + int(clock.Sub(start).Seconds()*rate); sent > limit {
+			t.Fatalf("sent %d bytes after %v, over the limit of %d", sent, clock.Sub(start), limit)
+		}
+	}
+
+	if got := logsClient.Calls(); got != 20 {
+		t.Errorf("unexpected number of PutLogEvents calls: got=%d want=20", got)
+	}
+	if elapsed := clock.Sub(start); elapsed < 9*time.Second {
+		t.Errorf("flushes were not throttled: 20000 bytes took %v", elapsed)
+	}
+}
+
+func TestWriterLargeEvents(t *testing.T) {
+	line := strings.Repeat("é", 150_000) // 300KB
+
+	cases := []struct {
+		name     string
+		opts     []Option
+		expected []string
+	}{
+		{
+			"split",
+			nil,
+			[]string{
+				strings.Repeat("é", (maxEventSize-eventSize)/2),
+				strings.Repeat("é", 150_000-(maxEventSize-eventSize)/2),
+			},
+		},
+		{
+			"truncate",
+			[]Option{WithTruncateLargeEvents()},
+			[]string{
+				strings.Repeat("é", (maxEventSize-eventSize-len(truncatedMarker))/2) + truncatedMarker,
+			},
+		},
+		{
+			"never split",
+			[]Option{WithNeverSplitLines(true)},
+			[]string{
+				strings.Repeat("é", (maxEventSize-eventSize-len(truncatedMarker))/2) + truncatedMarker,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+
+			logsClient := newLogsCLientTest()
+			w := New("group", "stream", logsClient, c.opts...)
+
+			if _, err := w.Write([]byte(line + "\n")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(logsClient.Events) != len(c.expected) {
+				t.Fatalf("unexpected number of events: got=%d want=%d", len(logsClient.Events), len(c.expected))
+			}
+			for i, e := range logsClient.Events {
+				if size := len(*e.Message) + eventSize; size > maxEventSize {
+					t.Errorf("event %d exceeds the size limit: %d bytes", i, size)
+				}
+				if *e.Message != c.expected[i] {
+					t.Errorf("event %d did not match: got %d bytes, want %d bytes", i, len(*e.Message), len(c.expected[i]))
+				}
 			}
 		})
 	}
 }
+
+func TestWriterRecovery(t *testing.T) {
+	errFail := errors.New("network down")
+
+	cases := []struct {
+		name    string
+		opts    []Option
+		recover func(w *LogWriter)
+	}{
+		{
+			"reset",
+			nil,
+			func(w *LogWriter) { w.Reset() },
+		},
+		{
+			"cooldown",
+			[]Option{WithErrorCooldown(10 * time.Millisecond)},
+			func(w *LogWriter) { time.Sleep(20 * time.Millisecond) },
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+
+			fail := true
+			logsClient := newLogsCLientTest()
+			logsClient.PutHook = func(context.Context) error {
+				if fail {
+					return errFail
+				}
+				return nil
+			}
+
+			w := New("group", "stream", logsClient, append(c.opts, WithMaxRetries(1))...)
+			defer w.Close()
+
+			w.appendEvent("lost")
+			if err := w.Flush(); err != errFail {
+				t.Fatalf("unexpected error: got=%v want=%v", err, errFail)
+			}
+
+			// the problem clears, but the error is sticky until the writer recovers
+			fail = false
+			w.appendEvent("test input")
+			if err := w.Flush(); err != errFail {
+				t.Fatalf("unexpected error: got=%v want=%v", err, errFail)
+			}
+
+			c.recover(w)
+
+			if err := w.Flush(); err != nil {
+				t.Fatalf("unexpected error after recovery: %v", err)
+			}
+
+			expected := []*cloudwatchlogs.InputLogEvent{
+				{Message: aws.String("test input"), Timestamp: aws.Int64(2)},
+			}
+			if !reflect.DeepEqual(expected, logsClient.Events) {
+				t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+			}
+		})
+	}
+}
+
+func TestWriterErrorHandler(t *testing.T) {
+	now = mockNow()
+
+	errFail := errors.New("network down")
+	logsClient := newLogsCLientTest()
+	logsClient.PutHook = func(context.Context) error {
+		return errFail
+	}
+
+	errs := make(chan error, 1)
+	w := New("group", "stream", logsClient,
+		WithFlushInterval(10*time.Millisecond),
+		WithMaxRetries(1),
+		WithErrorHandler(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}),
+	)
+	defer w.Close()
+
+	if _, err := w.Write([]byte("test input\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case err := <-errs:
+		if err != errFail {
+			t.Errorf("unexpected error: got=%v want=%v", err, errFail)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("error handler was not called")
+	}
+}
+
+func TestWriterWriteAfterFailure(t *testing.T) {
+	now = mockNow()
+
+	errFail := errors.New("access denied")
+	logsClient := newLogsCLientTest()
+	logsClient.PutHook = func(context.Context) error {
+		return errFail
+	}
+
+	w := New("group", "stream", logsClient, WithFlushInterval(10*time.Millisecond), WithMaxRetries(1))
+	defer w.Close()
+
+	// the source never ends, so io.Copy only returns if Write fails
+	copied := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(w, endlessReader{})
+		copied <- err
+	}()
+
+	select {
+	case err := <-copied:
+		if !errors.Is(err, errFail) {
+			t.Errorf("unexpected error: got=%v want=%v", err, errFail)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("io.Copy did not stop after delivery failed")
+	}
+}
+
+// endlessReader returns lines of input forever
+type endlessReader struct{}
+
+func (endlessReader) Read(b []byte) (int, error) {
+	line := []byte("test input\n")
+	if len(b) < len(line) {
+		return copy(b, line[:len(b)]), nil
+	}
+	return copy(b, line), nil
+}
+
+func TestWriterStats(t *testing.T) {
+	now = mockNow()
+
+	var calls int
+	logsClient := newLogsCLientTest()
+	logsClient.PutHook = func(context.Context) error {
+		// fail the first attempt of each of the first two batches. The first
+		// batch succeeds on retry, the second gives up
+		if calls++; calls == 1 || calls == 3 || calls == 4 {
+			return errors.New("network down")
+		}
+		return nil
+	}
+
+	w := New("group", "stream", logsClient, WithMaxRetries(2), WithErrorCooldown(time.Nanosecond))
+	defer w.Close()
+	w.backoff.sleep = func(context.Context, time.Duration) error { return nil }
+
+	w.appendEvent("one")
+	w.appendEvent("two")
+
+	expected := Stats{BufferedEvents: 2, BufferedBytes: 6 + 2*eventSize}
+	if got := w.Stats(); got != expected {
+		t.Errorf("stats did not match: got=%+v want=%+v", got, expected)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w.appendEvent("three")
+	if err := w.Flush(); err == nil {
+		t.Fatal("expected flush to fail")
+	}
+
+	w.appendEvent("four")
+	time.Sleep(time.Millisecond)
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected = Stats{SentEvents: 3, SentBytes: 10 + 3*eventSize, SentBatches: 2, DroppedEvents: 1, RetryCount: 2}
+	if got := w.Stats(); got != expected {
+		t.Errorf("stats did not match: got=%+v want=%+v", got, expected)
+	}
+}
+
+// fakeMetrics totals the measurements reported by a writer
+type fakeMetrics struct {
+	sync.Mutex
+	sent, dropped, retries int
+	batches, batchBytes    int
+	latencies              []time.Duration
+}
+
+func (m *fakeMetrics) IncSent(n int) {
+	m.Lock()
+	defer m.Unlock()
+	m.sent += n
+}
+
+func (m *fakeMetrics) IncDropped(n int) {
+	m.Lock()
+	defer m.Unlock()
+	m.dropped += n
+}
+
+func (m *fakeMetrics) IncRetries(n int) {
+	m.Lock()
+	defer m.Unlock()
+	m.retries += n
+}
+
+func (m *fakeMetrics) ObserveBatchBytes(n int) {
+	m.Lock()
+	defer m.Unlock()
+	m.batches++
+	m.batchBytes += n
+}
+
+func (m *fakeMetrics) ObserveFlushLatency(d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.latencies = append(m.latencies, d)
+}
+
+func TestWriterBufferSize(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithFlushInterval(time.Hour), WithPrefix("> "), WithMaxBatchEvents(3))
+	defer w.Close()
+
+	// plain, empty, multibyte, and split events
+	for _, line := range []string{"one", "", "héllo wörld", strings.Repeat("x", maxEventSize)} {
+		w.appendEvent(line)
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	var size int
+	for _, e := range w.buf {
+		size += len(*e.Message) + eventSize
+	}
+	if w.bufSize != size {
+		t.Fatalf("bufSize does not match the buffered events: got=%d want=%d", w.bufSize, size)
+	}
+
+	// draining in batches accounts for exactly the bytes taken each time
+	for len(w.buf) > 0 {
+		before := w.bufSize
+
+		var drained int
+		for _, e := range w.drainBuffer() {
+			drained += len(*e.Message) + eventSize
+		}
+		if w.bufSize != before-drained {
+			t.Fatalf("bufSize drifted while draining: got=%d want=%d", w.bufSize, before-drained)
+		}
+	}
+	if w.bufSize != 0 {
+		t.Errorf("bufSize of an empty buffer: %d", w.bufSize)
+	}
+}
+
+func TestWriterPending(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithFlushInterval(time.Hour))
+	defer w.Close()
+
+	const n = 10
+	if _, err := w.Write(bytes.Repeat([]byte("line\n"), n)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Write returns once the input is read, which may be just before the last
+	// line is buffered
+	deadline := time.Now().Add(time.Second)
+	for w.PendingEvents() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected number of pending events: got=%d want=%d", w.PendingEvents(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got, want := w.PendingBytes(), n*(4+eventSize); got != want {
+		t.Errorf("unexpected pending bytes: got=%d want=%d", got, want)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.PendingEvents() != 0 || w.PendingBytes() != 0 {
+		t.Errorf("events still pending after flush: events=%d bytes=%d", w.PendingEvents(), w.PendingBytes())
+	}
+	if logsClient.Calls() != 1 {
+		t.Errorf("unexpected number of PutLogEvents calls: %d", logsClient.Calls())
+	}
+}
+
+func TestWriterMetrics(t *testing.T) {
+	now = mockNow()
+
+	var calls int
+	logsClient := newLogsCLientTest()
+	logsClient.PutHook = func(context.Context) error {
+		// as in TestWriterStats, the first batch succeeds on retry and the
+		// second gives up
+		if calls++; calls == 1 || calls == 3 || calls == 4 {
+			return errors.New("network down")
+		}
+		return nil
+	}
+
+	m := &fakeMetrics{}
+	w := New("group", "stream", logsClient, WithMaxRetries(2), WithErrorCooldown(time.Nanosecond), WithMetrics(m))
+	w.backoff.sleep = func(context.Context, time.Duration) error { return nil }
+
+	w.appendEvent("one")
+	w.appendEvent("two")
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w.appendEvent("three")
+	if err := w.Flush(); err == nil {
+		t.Fatal("expected flush to fail")
+	}
+
+	w.appendEvent("four")
+	time.Sleep(time.Millisecond)
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if m.sent != 3 || m.dropped != 1 || m.retries != 2 {
+		t.Errorf("unexpected counts: sent=%d dropped=%d retries=%d", m.sent, m.dropped, m.retries)
+	}
+
+	// every event was in exactly one batch, however they were grouped
+	if expected := 15 + 4*eventSize; m.batchBytes != expected {
+		t.Errorf("unexpected batch bytes: got=%d want=%d", m.batchBytes, expected)
+	}
+	if stats := w.Stats(); m.batches != stats.SentBatches+1 {
+		t.Errorf("unexpected number of batches: got=%d want=%d", m.batches, stats.SentBatches+1)
+	}
+	if len(m.latencies) != m.batches {
+		t.Errorf("latency was not observed for every batch: got=%d want=%d", len(m.latencies), m.batches)
+	}
+}
+
+func TestWriterCreatesResources(t *testing.T) {
+	cases := []struct {
+		name              string
+		noGroup           bool
+		expectedRetention []*cloudwatchlogs.PutRetentionPolicyInput
+	}{
+		{
+			"missing group",
+			true,
+			[]*cloudwatchlogs.PutRetentionPolicyInput{
+				{LogGroupName: aws.String("group"), RetentionInDays: aws.Int64(30)},
+			},
+		},
+		{
+			"existing group",
+			false,
+			nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+
+			logsClient := newLogsCLientTest()
+			logsClient.NoGroup = c.noGroup
+			logsClient.NoStream = true
+
+			w := New("group", "stream", logsClient, WithRetentionDays(30))
+
+			if _, err := w.Write([]byte("test input\n")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expected := []*cloudwatchlogs.InputLogEvent{
+				{Message: aws.String("test input"), Timestamp: aws.Int64(1)},
+			}
+			if !reflect.DeepEqual(expected, logsClient.Events) {
+				t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+			}
+			if !reflect.DeepEqual(c.expectedRetention, logsClient.Retention) {
+				t.Errorf("retention policy did not match: got=%v want=%v", logsClient.Retention, c.expectedRetention)
+			}
+		})
+	}
+}
+
+func TestValidRetentionDays(t *testing.T) {
+	for _, d := range []int{1, 30, 365, 3653} {
+		if !ValidRetentionDays(d) {
+			t.Errorf("%d should be a valid retention period", d)
+		}
+	}
+	for _, d := range []int{0, 2, 31, 10000} {
+		if ValidRetentionDays(d) {
+			t.Errorf("%d should not be a valid retention period", d)
+		}
+	}
+}
+
+func TestWriterCreateLogGroupInput(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	logsClient.NoGroup = true
+	logsClient.NoStream = true
+
+	tags := map[string]*string{"team": aws.String("logging")}
+	w := New("group", "stream", logsClient,
+		WithTags(tags),
+		WithKMSKeyID("arn:aws:kms:us-east-1:123456789012:key/example"),
+	)
+
+	if _, err := w.Write([]byte("test input\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*cloudwatchlogs.CreateLogGroupInput{
+		{
+			LogGroupName: aws.String("group"),
+			Tags:         tags,
+			KmsKeyId:     aws.String("arn:aws:kms:us-east-1:123456789012:key/example"),
+		},
+	}
+	if !reflect.DeepEqual(expected, logsClient.CreatedGroups) {
+		t.Errorf("CreateLogGroup input did not match: got=%v want=%v", logsClient.CreatedGroups, expected)
+	}
+}
+
+func TestWriterTimestampFormat(t *testing.T) {
+	// a second before the parsed timestamp, so that both events fit in a
+	// batch's 24 hours
+	now = func() int64 { return 1591014644000 }
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithTimestampFormat(time.RFC3339))
+
+	if _, err := w.Write([]byte("2020-06-01T12:30:45Z matched\nunmatched\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("unmatched"), Timestamp: aws.Int64(1591014644000)},
+		{Message: aws.String("2020-06-01T12:30:45Z matched"), Timestamp: aws.Int64(1591014645000)},
+	}
+	if !reflect.DeepEqual(expected, logsClient.Events) {
+		t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+	}
+}
+
+func TestWriterMultiline(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithMultilinePattern(regexp.MustCompile(`^\S`)))
+
+	input := `continuation before the first event
+Exception in thread "main" java.lang.IllegalStateException: boom
+	at com.example.App.run(App.java:42)
+	at com.example.App.main(App.java:10)
+Caused by: java.io.IOException: disk full
+	... 2 more
+INFO recovered
+`
+	if _, err := w.Write([]byte(input)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("continuation before the first event"), Timestamp: aws.Int64(1)},
+		{Message: aws.String("Exception in thread \"main\" java.lang.IllegalStateException: boom\n\tat com.example.App.run(App.java:42)\n\tat com.example.App.main(App.java:10)"), Timestamp: aws.Int64(2)},
+		{Message: aws.String("Caused by: java.io.IOException: disk full\n\t... 2 more"), Timestamp: aws.Int64(3)},
+		{Message: aws.String("INFO recovered"), Timestamp: aws.Int64(4)},
+	}
+	if !reflect.DeepEqual(expected, logsClient.Events) {
+		t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+	}
+}
+
+func TestWriterMultilineIdle(t *testing.T) {
+	now = mockNow()
+
+	ticks := make(chan time.Time)
+	newTicker = func(time.Duration) *time.Ticker {
+		ticker := time.NewTicker(time.Hour)
+		ticker.C = ticks
+		return ticker
+	}
+	defer func() { newTicker = time.NewTicker }()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithMultilinePattern(regexp.MustCompile(`^\S`)))
+
+	// the input goes quiet partway through a stack trace, without ending
+	if _, err := w.Write([]byte("Exception: boom\n\tat App.run\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		w.multiline.Lock()
+		read := strings.HasSuffix(w.multiline.buf.String(), "App.run")
+		w.multiline.Unlock()
+		if read {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("input was not read")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the first tick only notes that the event has stopped growing. The third
+	// is received once the second tick's flush has finished
+	ticks <- time.Now()
+	if calls := logsClient.Calls(); calls != 0 {
+		t.Fatalf("event was sent while it may still be growing")
+	}
+	ticks <- time.Now()
+	ticks <- time.Now()
+
+	expected := []string{"Exception: boom\n\tat App.run"}
+	if got := logsClient.Messages(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("unexpected messages before Close: got=%q want=%q", got, expected)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := logsClient.Messages(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("unexpected messages after Close: got=%q want=%q", got, expected)
+	}
+}
+
+func TestWriterMultilineLimit(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithMultilinePattern(regexp.MustCompile(`^\S`)))
+
+	// three continuation lines of 100KB each can't fit in a single event
+	cont := "\t" + strings.Repeat("x", 100_000)
+	input := "start\n" + cont + "\n" + cont + "\n" + cont + "\n"
+	if _, err := w.Write([]byte(input)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"start\n" + cont + "\n" + cont,
+		cont,
+	}
+	if len(logsClient.Events) != len(expected) {
+		t.Fatalf("unexpected number of events: got=%d want=%d", len(logsClient.Events), len(expected))
+	}
+	for i, e := range logsClient.Events {
+		if *e.Message != expected[i] {
+			t.Errorf("event %d did not match: got %d bytes, want %d bytes", i, len(*e.Message), len(expected[i]))
+		}
+	}
+}
+
+func TestWriterSplitFunc(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithSplitFunc(ScanDelimiter(0)))
+
+	if _, err := w.Write([]byte("first\x00second\nwith a newline\x00")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := w.Write([]byte("third")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("first"), Timestamp: aws.Int64(1)},
+		{Message: aws.String("second\nwith a newline"), Timestamp: aws.Int64(2)},
+		{Message: aws.String("third"), Timestamp: aws.Int64(3)},
+	}
+	if !reflect.DeepEqual(expected, logsClient.Events) {
+		t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+	}
+}
+
+func TestWriterChunks(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithSplitFunc(ScanChunks(1000)))
+
+	// no newlines at all, written in pieces that don't line up with chunks
+	input := strings.Repeat("0123456789", 350)
+	for i := 0; i < len(input); i += 300 {
+		end := i + 300
+		if end > len(input) {
+			end = len(input)
+		}
+		if _, err := w.Write([]byte(input[i:end])); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{input[:1000], input[1000:2000], input[2000:3000], input[3000:]}
+	if got := logsClient.Messages(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("input was not chunked into 1000 byte events: got %d events", len(got))
+	}
+}
+
+func TestWriterWriteEvent(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithPrefix("app: "))
+
+	ts := time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC)
+	if err := w.WriteEvent("first\nwith a newline", ts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.WriteEvent("second", ts.Add(time.Second)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.WriteEvent("too late", ts); err == nil {
+		t.Error("expected an error writing an event after Close")
+	}
+
+	expected := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("app: first\nwith a newline"), Timestamp: aws.Int64(1709296200123)},
+		{Message: aws.String("app: second"), Timestamp: aws.Int64(1709296201123)},
+	}
+	if !reflect.DeepEqual(expected, logsClient.Events) {
+		t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+	}
+}
+
+func TestWriterAddEvent(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithTimestampFormat(time.RFC3339), WithMaxBatchEvents(10))
+
+	var expected []string
+	for i := 0; i < 25; i++ {
+		msg := fmt.Sprintf("record %d\nline 2", i)
+		if err := w.AddEvent(msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected = append(expected, msg)
+	}
+
+	// the timestamp is parsed as it would be from a written line
+	if err := w.AddEvent("2024-03-01T12:30:00Z parsed"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = append(expected, "2024-03-01T12:30:00Z parsed")
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.AddEvent("too late"); err == nil {
+		t.Error("expected an error adding an event after Close")
+	}
+
+	if got := logsClient.Messages(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("unexpected messages: got=%q want=%q", got, expected)
+	}
+	if ts := *logsClient.Events[len(expected)-1].Timestamp; ts != 1709296200000 {
+		t.Errorf("unexpected timestamp: %d", ts)
+	}
+}
+
+func TestWriterWriteDuringFlush(t *testing.T) {
+	now = mockNow()
+
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	logsClient := newLogsCLientTest()
+	logsClient.PutHook = func(ctx context.Context) error {
+		select {
+		case entered <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil
+	}
+	w := New("group", "stream", logsClient, WithFlushInterval(time.Hour))
+
+	if _, err := w.Write([]byte("first\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for w.Stats().BufferedEvents == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	flushed := make(chan error)
+	go func() { flushed <- w.Flush() }()
+	<-entered
+
+	// the flush is stuck in PutLogEvents. New events should still be buffered
+	if _, err := w.Write([]byte("second\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for w.Stats().BufferedEvents == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("write was blocked by an in-flight flush")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	if err := <-flushed; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("first"), Timestamp: aws.Int64(1)},
+		{Message: aws.String("second"), Timestamp: aws.Int64(2)},
+	}
+	if !reflect.DeepEqual(expected, logsClient.Events) {
+		t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+	}
+}
+
+func TestWriterOverflowDropOldest(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+
+	// each event is 1 byte + 26 bytes of overhead, so only three fit
+	w := New("group", "stream", logsClient,
+		WithFlushInterval(time.Hour),
+		WithMaxBufferBytes(100),
+	)
+
+	for _, line := range []string{"1", "2", "3", "4", "5"} {
+		if _, err := w.Write([]byte(line + "\n")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("3"), Timestamp: aws.Int64(3)},
+		{Message: aws.String("4"), Timestamp: aws.Int64(4)},
+		{Message: aws.String("5"), Timestamp: aws.Int64(5)},
+	}
+	if !reflect.DeepEqual(expected, logsClient.Events) {
+		t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+	}
+
+	if got := w.Stats().DroppedEvents; got != 2 {
+		t.Errorf("unexpected dropped events: got=%d want=2", got)
+	}
+}
+
+func TestWriterOverflowBlock(t *testing.T) {
+	now = mockNow()
+
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	logsClient := newLogsCLientTest()
+	logsClient.PutHook = func(ctx context.Context) error {
+		select {
+		case entered <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil
+	}
+
+	// each event is 1 byte + 26 bytes of overhead, so only three fit
+	w := New("group", "stream", logsClient,
+		WithFlushInterval(time.Hour),
+		WithMaxBufferBytes(100),
+		WithOverflowPolicy(Block),
+	)
+
+	// the fourth event triggers a flush, which gets stuck sending the first
+	// three. The next three fill the buffer again, leaving the last blocked
+	if _, err := w.Write([]byte("1\n2\n3\n4\n5\n6\n7\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-entered
+
+	deadline := time.Now().Add(time.Second)
+	for w.Stats().BufferedEvents < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("buffer was not refilled during the flush")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if s := w.Stats(); s.BufferedEvents != 3 || s.BufferedBytes > 100 || s.DroppedEvents != 0 {
+		t.Errorf("buffer exceeded its limit: %+v", s)
+	}
+
+	close(release)
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, e := range logsClient.Events {
+		got = append(got, *e.Message)
+	}
+	if expected := []string{"1", "2", "3", "4", "5", "6", "7"}; !reflect.DeepEqual(expected, got) {
+		t.Errorf("log events did not match: got=%v want=%v", got, expected)
+	}
+}
+
+func TestWriterBackpressure(t *testing.T) {
+	now = mockNow()
+
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	logsClient := newLogsCLientTest()
+	logsClient.PutHook = func(ctx context.Context) error {
+		select {
+		case entered <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil
+	}
+
+	// each event is 99 bytes + 26 bytes of overhead
+	w := New("group", "stream", logsClient,
+		WithFlushInterval(time.Hour),
+		WithRequestRate(0),
+		WithBackpressure(1000, 500),
+	)
+
+	line := strings.Repeat("x", 99) + "\n"
+	input := []byte(strings.Repeat(line, 1000))
+
+	written := make(chan error)
+	go func() {
+		_, err := w.Write(input)
+		written <- err
+	}()
+
+	// the first flush gets stuck, and the buffer fills up behind it
+	<-entered
+	deadline := time.Now().Add(time.Second)
+	for w.Stats().BufferedBytes < 1000 {
+		if time.Now().After(deadline) {
+			t.Fatal("buffer was not refilled during the flush")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case <-written:
+		t.Fatal("Write returned while the buffer was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if s := w.Stats(); s.BufferedBytes > 1000+125 || s.DroppedEvents != 0 {
+		t.Errorf("buffer grew past the high-water mark: %+v", s)
+	}
+
+	close(release)
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write did not resume once the buffer drained")
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(logsClient.Events) != 1000 {
+		t.Errorf("unexpected number of events: got=%d want=1000", len(logsClient.Events))
+	}
+}
+
+func TestWriterBufferAccounting(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithFlushInterval(time.Hour), WithRequestRate(0))
+	defer w.Close()
+
+	for i := 0; i < 50; i++ {
+		w.appendEvent(strings.Repeat("é", i))
+	}
+
+	// recomputed returns the size of the events still buffered
+	recomputed := func() int {
+		var n int
+		for _, e := range w.buf {
+			n += len(*e.Message) + eventSize
+		}
+		return n
+	}
+
+	w.Lock()
+	if w.bufSize != recomputed() {
+		t.Fatalf("bufSize did not match buffered events: got=%d want=%d", w.bufSize, recomputed())
+	}
+
+	// force several size-limited batches
+	w.maxBatchBytes = 300
+	w.Unlock()
+
+	for flushes := 1; w.PendingEvents() > 0; flushes++ {
+		if err := w.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		w.Lock()
+		if w.bufSize != recomputed() {
+			t.Errorf("flush %d: bufSize did not match buffered events: got=%d want=%d", flushes, w.bufSize, recomputed())
+		}
+		w.Unlock()
+	}
+
+	if got := logsClient.Calls(); got < 5 {
+		t.Errorf("unexpected number of PutLogEvents calls: got=%d want>=5", got)
+	}
+	if got := len(logsClient.Events); got != 50 {
+		t.Errorf("unexpected number of events: got=%d want=50", got)
+	}
+}
+
+func TestDrainBufferBatchSize(t *testing.T) {
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithFlushInterval(time.Hour))
+	defer w.Close()
+
+	// four of these events fill a batch exactly, so a check made before
+	// adding each event's size would let a fifth one in
+	msg := strings.Repeat("x", maxSize/4-eventSize)
+
+	w.Lock()
+	defer w.Unlock()
+	for i := 0; i < 9; i++ {
+		w.buf = append(w.buf, &cloudwatchlogs.InputLogEvent{
+			Message:   aws.String(msg),
+			Timestamp: aws.Int64(int64(i)),
+		})
+		w.bufSize += len(msg) + eventSize
+	}
+
+	var counts []int
+	for len(w.buf) > 0 {
+		batch := w.drainBuffer()
+
+		var size int
+		for _, e := range batch {
+			size += len(*e.Message) + eventSize
+		}
+		if size > maxSize {
+			t.Errorf("batch %d exceeds the size limit: %d bytes", len(counts), size)
+		}
+		counts = append(counts, len(batch))
+	}
+
+	if expected := []int{4, 4, 1}; !reflect.DeepEqual(expected, counts) {
+		t.Errorf("unexpected batch sizes: got=%v want=%v", counts, expected)
+	}
+}
+
+func TestWriterHeartbeat(t *testing.T) {
+	var clock int64
+	now = func() int64 {
+		return atomic.LoadInt64(&clock)
+	}
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient,
+		WithFlushInterval(5*time.Millisecond),
+		WithHeartbeat(time.Minute, "heartbeat"),
+	)
+
+	if _, err := w.Write([]byte("real event\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// events were flushed recently, so no heartbeat is due
+	time.Sleep(50 * time.Millisecond)
+	logsClient.Lock()
+	if len(logsClient.Events) != 1 {
+		t.Errorf("unexpected events before the stream went idle: %v", logsClient.Events)
+	}
+	logsClient.Unlock()
+
+	atomic.StoreInt64(&clock, time.Minute.Milliseconds())
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		logsClient.Lock()
+		n := len(logsClient.Events)
+		logsClient.Unlock()
+		if n > 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("heartbeat was not sent after the stream went idle")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the heartbeat itself counts as activity, so only one is sent per interval
+	expected := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("real event"), Timestamp: aws.Int64(0)},
+		{Message: aws.String("heartbeat"), Timestamp: aws.Int64(time.Minute.Milliseconds())},
+	}
+	if !reflect.DeepEqual(expected, logsClient.Events) {
+		t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+	}
+}
+
+func TestWriterHeartbeatUnfiltered(t *testing.T) {
+	var clock int64
+	now = func() int64 {
+		return atomic.LoadInt64(&clock)
+	}
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient,
+		WithFlushInterval(time.Hour),
+		WithHeartbeat(time.Minute, "heartbeat"),
+		WithInclude(regexp.MustCompile("ERROR")),
+		WithPrefix("[app] "),
+	)
+
+	atomic.StoreInt64(&clock, time.Minute.Milliseconds())
+	w.heartbeat()
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the heartbeat doesn't match the include pattern, but is sent unchanged
+	expected := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("heartbeat"), Timestamp: aws.Int64(time.Minute.Milliseconds())},
+	}
+	if !reflect.DeepEqual(expected, logsClient.Events) {
+		t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+	}
+}
+
+func TestWriterStripANSI(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithStripANSI())
+
+	if _, err := w.Write([]byte("\x1b[1;31mERROR\x1b[0m something broke\nplain\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("ERROR something broke"), Timestamp: aws.Int64(1)},
+		{Message: aws.String("plain"), Timestamp: aws.Int64(2)},
+	}
+	if !reflect.DeepEqual(expected, logsClient.Events) {
+		t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+	}
+}
+
+func TestWriterCarriageReturn(t *testing.T) {
+	cases := []struct {
+		name     string
+		opts     []Option
+		expected []string
+	}{
+		{"default", nil, []string{"a", "b", "x\r", "c\r\nd\r"}},
+		{"scan lines", []Option{WithSplitFunc(bufio.ScanLines)}, []string{"a", "b", "x\r", "c\r\nd\r"}},
+		{"delimiter", []Option{WithSplitFunc(ScanDelimiter('\n'))}, []string{"a", "b", "x\r", "c\r\nd\r"}},
+		{"keep", []Option{WithSplitFunc(ScanDelimiter('\n')), WithKeepCarriageReturn()}, []string{"a\r", "b\r", "x\r\r", "c\r\nd\r"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+
+			logsClient := newLogsCLientTest()
+			w := New("group", "stream", logsClient, c.opts...)
+
+			// only the one carriage return ending each line is removed
+			if _, err := w.Write([]byte("a\r\nb\r\nx\r\r\n")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// events built by the caller are left alone
+			w = New("group", "stream", logsClient, c.opts...)
+			if err := w.AddEvent("c\r\nd\r"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := logsClient.Messages(); !reflect.DeepEqual(c.expected, got) {
+				t.Errorf("unexpected messages: got=%q want=%q", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestWriterTrimSpace(t *testing.T) {
+	input := "  indented\ntrailing \t\n \t \n\tboth  \n"
+
+	cases := []struct {
+		name     string
+		trim     bool
+		expected []string
+	}{
+		{"trimmed", true, []string{"indented", "trailing", "both"}},
+		{"preserved", false, []string{"  indented", "trailing \t", " \t ", "\tboth  "}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+
+			logsClient := newLogsCLientTest()
+			w := New("group", "stream", logsClient, WithTrimSpace(c.trim))
+
+			if _, err := w.Write([]byte(input)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := logsClient.Messages(); !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("messages did not match: got=%q want=%q", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestWriterFilter(t *testing.T) {
+	input := "INFO started\nERROR disk full\nINFO GET /health 200\nERROR GET /health 500\n"
+
+	cases := []struct {
+		name     string
+		opts     []Option
+		expected []string
+	}{
+		{
+			"include",
+			[]Option{WithInclude(regexp.MustCompile(`^ERROR`))},
+			[]string{"ERROR disk full", "ERROR GET /health 500"},
+		},
+		{
+			"exclude",
+			[]Option{WithExclude(regexp.MustCompile(`/health`))},
+			[]string{"INFO started", "ERROR disk full"},
+		},
+		{
+			"include and exclude",
+			[]Option{
+				WithInclude(regexp.MustCompile(`^ERROR`)),
+				WithExclude(regexp.MustCompile(`/health`)),
+			},
+			[]string{"ERROR disk full"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+
+			logsClient := newLogsCLientTest()
+			w := New("group", "stream", logsClient, c.opts...)
+
+			if _, err := w.Write([]byte(input)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got []string
+			for _, e := range logsClient.Events {
+				got = append(got, *e.Message)
+			}
+			if !reflect.DeepEqual(c.expected, got) {
+				t.Errorf("log events did not match: got=%q want=%q", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestWriterRedact(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient,
+		WithRedactor(regexp.MustCompile(`password=\S+`)),
+		WithRedactor(EmailPattern),
+	)
+
+	if _, err := w.Write([]byte("login user=ops@example.com password=hunter2 ok\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("login user=*** *** ok"), Timestamp: aws.Int64(1)},
+	}
+	if !reflect.DeepEqual(expected, logsClient.Events) {
+		t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+	}
+}
+
+func TestWriterMaxLineBytes(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithMaxLineBytes(8))
+
+	if _, err := w.Write([]byte("short\nthis line is too long\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("short"), Timestamp: aws.Int64(1)},
+		{Message: aws.String("this lin…[truncated 13 bytes]"), Timestamp: aws.Int64(2)},
+	}
+	if !reflect.DeepEqual(expected, logsClient.Events) {
+		t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+	}
+}
+
+func TestWriterPrefixSuffix(t *testing.T) {
+	cases := []struct {
+		name     string
+		opts     []Option
+		expected []string
+	}{
+		{
+			"prefix",
+			[]Option{WithPrefix("[prod-web-3] ")},
+			[]string{"[prod-web-3] first", "[prod-web-3] ", "[prod-web-3] 2020-06-01T12:30:45Z third"},
+		},
+		{
+			"suffix",
+			[]Option{WithSuffix(" env=prod")},
+			[]string{"first env=prod", " env=prod", "2020-06-01T12:30:45Z third env=prod"},
+		},
+		{
+			"both",
+			[]Option{WithPrefix("<"), WithSuffix(">")},
+			[]string{"<first>", "<>", "<2020-06-01T12:30:45Z third>"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+
+			logsClient := newLogsCLientTest()
+			opts := append(c.opts, WithTimestampFormat(time.RFC3339))
+			w := New("group", "stream", logsClient, opts...)
+
+			if _, err := w.Write([]byte("first\n\n2020-06-01T12:30:45Z third\n")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got []string
+			for _, e := range logsClient.Events {
+				got = append(got, *e.Message)
+			}
+			if !reflect.DeepEqual(c.expected, got) {
+				t.Errorf("log events did not match: got=%q want=%q", got, c.expected)
+			}
+
+			// the timestamp is still found behind the prefix
+			if ts := *logsClient.Events[len(logsClient.Events)-1].Timestamp; ts != 1591014645000 {
+				t.Errorf("unexpected timestamp: got=%d want=1591014645000", ts)
+			}
+		})
+	}
+}
+
+func TestWriterEnrichment(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithEnrichment(EnrichJSON))
+
+	if _, err := w.Write([]byte("hello \"world\"\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(logsClient.Events) != 1 {
+		t.Fatalf("unexpected number of events: got=%d want=1", len(logsClient.Events))
+	}
+
+	var env envelope
+	if err := json.Unmarshal([]byte(*logsClient.Events[0].Message), &env); err != nil {
+		t.Fatalf("event is not valid JSON: %v", err)
+	}
+
+	host, _ := os.Hostname()
+	if env.Host != host || env.PID != os.Getpid() || env.Msg != `hello "world"` {
+		t.Errorf("unexpected envelope: %+v", env)
+	}
+}
+
+func TestWriterSequenceTokens(t *testing.T) {
+	cases := []struct {
+		name     string
+		opts     []Option
+		expected []*string
+	}{
+		{"disabled", nil, []*string{nil, nil, nil}},
+		{"enabled", []Option{WithSequenceTokens(true)}, []*string{nil, aws.String("1"), aws.String("2")}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+
+			logsClient := newLogsCLientTest()
+			w := New("group", "stream", logsClient, append(c.opts, WithRequestRate(0))...)
+
+			for _, line := range []string{"one", "two", "three"} {
+				w.appendEvent(line)
+				if err := w.Flush(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(c.expected, logsClient.Tokens) {
+				t.Errorf("unexpected sequence tokens: got=%v want=%v", aws.StringValueSlice(logsClient.Tokens), aws.StringValueSlice(c.expected))
+			}
+		})
+	}
+}
+
+func TestWriterInvalidSequenceToken(t *testing.T) {
+	errInvalid := &cloudwatchlogs.InvalidSequenceTokenException{
+		ExpectedSequenceToken: aws.String("expected"),
+	}
+
+	cases := []struct {
+		name     string
+		opts     []Option
+		err      bool
+		expected []*string
+	}{
+		// without sequence tokens, the error is retried like any other
+		{"disabled", []Option{WithMaxRetries(2)}, true, []*string{nil, nil}},
+		{"enabled", []Option{WithSequenceTokens(true)}, false, []*string{nil, aws.String("expected")}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+
+			var calls int
+			logsClient := newLogsCLientTest()
+			logsClient.PutHook = func(ctx context.Context) error {
+				if calls++; calls == 1 || c.err {
+					return errInvalid
+				}
+				return nil
+			}
+
+			w := New("group", "stream", logsClient, append(c.opts, WithFlushInterval(time.Hour))...)
+			w.backoff.sleep = func(context.Context, time.Duration) error { return nil }
+
+			w.appendEvent("event")
+			err := w.Flush()
+			if c.err != (err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(c.expected, logsClient.Tokens) {
+				t.Errorf("unexpected sequence tokens: got=%v want=%v", aws.StringValueSlice(logsClient.Tokens), aws.StringValueSlice(c.expected))
+			}
+		})
+	}
+}
+
+func TestWriterDataAlreadyAccepted(t *testing.T) {
+	errAccepted := &cloudwatchlogs.DataAlreadyAcceptedException{
+		ExpectedSequenceToken: aws.String("next"),
+	}
+	errTimeout := awserr.New(request.ErrCodeResponseTimeout, "timed out", nil)
+
+	cases := []struct {
+		name     string
+		errs     []error
+		tokens   []*string
+		expected []string
+	}{
+		// nothing was sent before, so the accepted batch was another one and
+		// this batch is sent with the expected token
+		{"first attempt", []error{errAccepted}, []*string{nil, aws.String("next")}, []string{"event"}},
+
+		// the timed out attempt may have been accepted, so the batch isn't
+		// sent again
+		{"after timeout", []error{errTimeout, errAccepted}, []*string{nil, nil}, []string{}},
+
+		// a throttled attempt was never accepted
+		{"after throttling", []error{cwlogtest.Throttled(), errAccepted}, []*string{nil, nil, aws.String("next")}, []string{"event"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+
+			var calls int
+			logsClient := newLogsCLientTest()
+			logsClient.PutHook = func(ctx context.Context) error {
+				if calls++; calls <= len(c.errs) {
+					return c.errs[calls-1]
+				}
+				return nil
+			}
+
+			w := New("group", "stream", logsClient, WithSequenceTokens(true), WithFlushInterval(time.Hour))
+			w.backoff.sleep = func(context.Context, time.Duration) error { return nil }
+
+			w.appendEvent("event")
+			if err := w.Flush(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(c.tokens, logsClient.Tokens) {
+				t.Errorf("unexpected sequence tokens: got=%v want=%v", aws.StringValueSlice(logsClient.Tokens), aws.StringValueSlice(c.tokens))
+			}
+			if got := logsClient.Messages(); !reflect.DeepEqual(c.expected, got) {
+				t.Errorf("unexpected messages: got=%q want=%q", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestWriterRetryDeadline(t *testing.T) {
+	now = mockNow()
+
+	errFail := errors.New("network down")
+	logsClient := newLogsCLientTest()
+	logsClient.PutHook = func(ctx context.Context) error {
+		return errFail
+	}
+
+	w := New("group", "stream", logsClient,
+		WithFlushInterval(time.Hour),
+		WithMaxRetries(100),
+		WithRetryDeadline(200*time.Millisecond),
+	)
+	defer w.Close()
+
+	w.appendEvent("event")
+
+	start := time.Now()
+	if err := w.Flush(); err != errFail {
+		t.Errorf("unexpected error: got=%v want=%v", err, errFail)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("flush did not stop at the retry deadline: took %v", elapsed)
+	}
+	logsClient.Lock()
+	defer logsClient.Unlock()
+	if n := len(logsClient.Tokens); n < 2 || n >= 100 {
+		t.Errorf("unexpected number of attempts: %d", n)
+	}
+}
+
+func TestWriterSmallBatches(t *testing.T) {
+	cases := []struct {
+		name     string
+		opts     []Option
+		expected []int
+	}{
+		{"events", []Option{WithMaxBatchEvents(2)}, []int{2, 2, 1}},
+		// each event is 5 bytes + 26 bytes of overhead
+		{"bytes", []Option{WithMaxBatchBytes(70)}, []int{2, 2, 1}},
+		{"both", []Option{WithMaxBatchEvents(3), WithMaxBatchBytes(70)}, []int{2, 2, 1}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+
+			logsClient := newLogsCLientTest()
+			w := New("group", "stream", logsClient, append(c.opts, WithFlushInterval(time.Hour), WithRequestRate(0))...)
+
+			for _, line := range []string{"event", "event", "event", "event", "event"} {
+				w.appendEvent(line)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(c.expected, logsClient.Batches) {
+				t.Errorf("unexpected batch sizes: got=%v want=%v", logsClient.Batches, c.expected)
+			}
+		})
+	}
+}
+
+func TestWriterWrittenCount(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithMaxBatchEvents(2))
+
+	if _, err := w.Write([]byte("one\ntwo\nthree\nfour\nfive\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := w.WrittenCount(); got != 5 {
+		t.Errorf("unexpected written count: got=%d want=5", got)
+	}
+}
+
+func TestWriterFlushContext(t *testing.T) {
+	now = mockNow()
+
+	var slow int32 = 1
+	logsClient := newLogsCLientTest()
+	logsClient.PutHook = func(ctx context.Context) error {
+		if atomic.LoadInt32(&slow) == 0 {
+			return nil
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	w := New("group", "stream", logsClient, WithFlushInterval(time.Hour))
+	w.appendEvent("event")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := w.FlushContext(ctx); err == nil {
+		t.Error("expected an error from the cancelled flush")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("flush did not return promptly after cancellation: took %v", elapsed)
+	}
+
+	// the batch is kept, and the writer is still usable
+	if s := w.Stats(); s.BufferedEvents != 1 || s.DroppedEvents != 0 {
+		t.Errorf("cancelled batch was not returned to the buffer: %+v", s)
+	}
+
+	atomic.StoreInt32(&slow, 0)
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*cloudwatchlogs.InputLogEvent{
+		{Message: aws.String("event"), Timestamp: aws.Int64(1)},
+	}
+	if !reflect.DeepEqual(expected, logsClient.Events) {
+		t.Errorf("log events did not match: got=%v want=%v", logsClient.Events, expected)
+	}
+}
+
+func TestWriterCloseWithTimeout(t *testing.T) {
+	now = mockNow()
+
+	// requests hang until they are cancelled, or until the test ends for a
+	// background flush, which the timeout doesn't cancel
+	release := make(chan struct{})
+	defer close(release)
+
+	logsClient := newLogsCLientTest()
+	logsClient.PutHook = func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-release:
+			return errors.New("released")
+		}
+	}
+
+	w := New("group", "stream", logsClient, WithFlushInterval(time.Hour))
+	if _, err := w.Write([]byte("event 1\nevent 2\nevent 3\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	n, err := w.CloseWithTimeout(50 * time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a timeout error, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("close did not give up after the timeout: took %v", elapsed)
+	}
+	if n != 3 {
+		t.Errorf("unexpected number of undelivered events: got=%d want=3", n)
+	}
+	if len(logsClient.Events) != 0 {
+		t.Errorf("unexpected events: %v", logsClient.Events)
+	}
+}
+
+func TestWriterCloseTwice(t *testing.T) {
+	now = mockNow()
+
+	errInvalid := awserr.NewRequestFailure(awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "invalid", nil), 400, "request-id")
+	logsClient := newLogsCLientTest()
+	logsClient.PutHook = func(context.Context) error { return errInvalid }
+
+	w := New("group", "stream", logsClient, WithFlushInterval(time.Hour))
+	w.backoff.sleep = func(context.Context, time.Duration) error { return nil }
+	if err := w.WriteEvent("event", time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	first := w.Close()
+	if !errors.Is(first, errInvalid) {
+		t.Fatalf("unexpected error: %v", first)
+	}
+
+	// later calls neither panic nor block, and report the first result
+	if err := w.Close(); err != first {
+		t.Errorf("unexpected error from the second Close: got=%v want=%v", err, first)
+	}
+	if n, err := w.CloseWithTimeout(time.Second); err != first || n != 0 {
+		t.Errorf("unexpected result from CloseWithTimeout: n=%d err=%v", n, err)
+	}
+	if calls := len(logsClient.Tokens); calls != 1 {
+		t.Errorf("the batch was sent again: %d calls", calls)
+	}
+}
+
+func TestWriterCloseWithTimeoutDelivered(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient)
+	if _, err := w.Write([]byte("event 1\nevent 2\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	n, err := w.CloseWithTimeout(time.Second)
+	if err != nil || n != 0 {
+		t.Fatalf("unexpected result: n=%d err=%v", n, err)
+	}
+	if got := logsClient.Messages(); !reflect.DeepEqual(got, []string{"event 1", "event 2"}) {
+		t.Errorf("unexpected messages: %v", got)
+	}
+}
+
+// discardLogsAPI accepts every batch without keeping it
+type discardLogsAPI struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+}
+
+// PutLogEventsWithContext implements cloudwatchlogsiface.CloudWatchLogsAPI
+func (discardLogsAPI) PutLogEventsWithContext(aws.Context, *cloudwatchlogs.PutLogEventsInput, ...request.Option) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	return &cloudwatchlogs.PutLogEventsOutput{}, nil
+}
+
+// retainingLogsAPI keeps the events of every batch without copying them, as
+// a client that records or forwards them later might
+type retainingLogsAPI struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	sync.Mutex
+	events []*cloudwatchlogs.InputLogEvent
+}
+
+// PutLogEventsWithContext implements cloudwatchlogsiface.CloudWatchLogsAPI
+func (c *retainingLogsAPI) PutLogEventsWithContext(_ aws.Context, input *cloudwatchlogs.PutLogEventsInput, _ ...request.Option) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	c.Lock()
+	defer c.Unlock()
+	c.events = append(c.events, input.LogEvents...)
+	return &cloudwatchlogs.PutLogEventsOutput{}, nil
+}
+
+func TestWriterRetainedEvents(t *testing.T) {
+	logsClient := &retainingLogsAPI{}
+	w := New("group", "stream", logsClient, WithFlushInterval(time.Hour), WithRequestRate(0))
+	defer w.Close()
+
+	// events sent earlier must not be reused for later ones
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			w.appendEvent(strconv.Itoa(i*10 + j))
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for i, e := range logsClient.events {
+		if e.Message == nil || *e.Message != strconv.Itoa(i) {
+			t.Fatalf("event %d was modified after it was sent: %v", i, e)
+		}
+	}
+}
+
+func TestWriterReuseEvents(t *testing.T) {
+	sess := session.Must(session.NewSession(aws.NewConfig().WithRegion("us-east-1")))
+
+	for _, c := range []struct {
+		name     string
+		client   Client
+		expected bool
+	}{
+		{"sdk client", cloudwatchlogs.New(sess), true},
+		{"other client", newLogsCLientTest(), false},
+	} {
+		w := New("group", "stream", c.client)
+		if w.reuseEvents != c.expected {
+			t.Errorf("%s: unexpected reuseEvents: got=%v want=%v", c.name, w.reuseEvents, c.expected)
+		}
+		w.Close()
+	}
+}
+
+func BenchmarkAppendEvent(b *testing.B) {
+	w := New("group", "stream", discardLogsAPI{}, WithFlushInterval(time.Hour), WithRequestRate(0))
+	defer w.Close()
+
+	// discardLogsAPI doesn't keep the events, so they may be reused as they
+	// would be with the SDK's client
+	w.reuseEvents = true
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.appendEvent("GET /index.html 200 1234")
+		if i%1000 == 999 {
+			if err := w.Flush(); err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+}
+
+func TestWriterFlushConcurrency(t *testing.T) {
+	cases := []struct {
+		name     string
+		opts     []Option
+		parallel bool
+	}{
+		{"concurrent", []Option{WithFlushConcurrency(4)}, true},
+		{"sequence tokens", []Option{WithFlushConcurrency(4), WithSequenceTokens(true)}, false},
+		{"default", nil, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+
+			var inFlight, maxInFlight int32
+			logsClient := newLogsCLientTest()
+			logsClient.PutHook = func(ctx context.Context) error {
+				n := atomic.AddInt32(&inFlight, 1)
+				defer atomic.AddInt32(&inFlight, -1)
+				for {
+					max := atomic.LoadInt32(&maxInFlight)
+					if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+				return nil
+			}
+
+			opts := append(c.opts, WithFlushInterval(time.Hour), WithRequestRate(0), WithMaxBatchEvents(1))
+			w := New("group", "stream", logsClient, opts...)
+			for i := 0; i < 8; i++ {
+				w.appendEvent(strconv.Itoa(i))
+			}
+
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := len(logsClient.Events); got != 8 {
+				t.Errorf("unexpected number of events: got=%d want=8", got)
+			}
+			if max := atomic.LoadInt32(&maxInFlight); (max > 1) != c.parallel {
+				t.Errorf("unexpected number of concurrent requests: %d", max)
+			}
+		})
+	}
+}
+
+func TestMultiWriter(t *testing.T) {
+	clock := WithClock(func() int64 { return 1 })
+
+	errFail := errors.New("access denied")
+	primary, audit, broken := newLogsCLientTest(), newLogsCLientTest(), newLogsCLientTest()
+	broken.PutHook = func(ctx context.Context) error {
+		return errFail
+	}
+
+	m := NewMulti(
+		Target{LogGroup: "group", LogStream: "primary", Client: primary, Options: []Option{clock}},
+		Target{LogGroup: "audit", LogStream: "audit", Client: audit, Options: []Option{clock}},
+		Target{LogGroup: "group", LogStream: "broken", Client: broken, Options: []Option{clock, WithMaxRetries(1)}},
+	)
+
+	if _, err := m.Write([]byte("first\nsecond\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := m.Close()
+	merr, ok := err.(MultiError)
+	if !ok || len(merr) != 1 || merr[0] != errFail {
+		t.Errorf("unexpected error: got=%v want=%v", err, MultiError{errFail})
+	}
+
+	for _, client := range []*cwlogtest.Client{primary, audit} {
+		var got []string
+		for _, e := range client.Events {
+			got = append(got, *e.Message)
+		}
+		if expected := []string{"first", "second"}; !reflect.DeepEqual(expected, got) {
+			t.Errorf("log events did not match: got=%v want=%v", got, expected)
+		}
+	}
+}
+
+func TestMultiWriterPartialFailure(t *testing.T) {
+	clock := WithClock(func() int64 { return 1 })
+
+	errInvalid := awserr.NewRequestFailure(awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "invalid", nil), 400, "request-id")
+	healthy, broken := newLogsCLientTest(), newLogsCLientTest()
+	broken.PutHook = func(ctx context.Context) error {
+		return errInvalid
+	}
+
+	m := NewMulti(
+		Target{LogGroup: "group", LogStream: "healthy", Client: healthy, Options: []Option{clock}},
+		Target{LogGroup: "group", LogStream: "broken", Client: broken, Options: []Option{clock}},
+	)
+
+	if _, err := m.Write([]byte("first\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Write is asynchronous, so the event may not be buffered by the first
+	// Flush
+	deadline := time.Now().Add(time.Second)
+	for m.Flush() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the broken target to fail")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the healthy target took all of data, so none of it is left to retry
+	data := []byte("second\n")
+	n, err := m.Write(data)
+	if err == nil {
+		t.Error("expected an error from the broken target")
+	}
+	if n != len(data) {
+		t.Errorf("unexpected count: got=%d want=%d", n, len(data))
+	}
+	if n < len(data) {
+		m.Write(data[n:])
+	}
+
+	m.Close()
+
+	if expected := []string{"first", "second"}; !reflect.DeepEqual(expected, healthy.Messages()) {
+		t.Errorf("log events did not match: got=%v want=%v", healthy.Messages(), expected)
+	}
+}
+
+func TestWriterWithClock(t *testing.T) {
+	t.Parallel()
+
+	clock := func(start int64) func() int64 {
+		var ts int64 = start
+		return func() int64 {
+			return atomic.AddInt64(&ts, 1)
+		}
+	}
+
+	first, second := newLogsCLientTest(), newLogsCLientTest()
+	w1 := New("group", "first", first, WithClock(clock(1000)))
+	w2 := New("group", "second", second, WithClock(clock(2000)))
+
+	for _, w := range []*LogWriter{w1, w2} {
+		if _, err := w.Write([]byte("one\ntwo\n")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	cases := []struct {
+		client   *cwlogtest.Client
+		expected []int64
+	}{
+		{first, []int64{1001, 1002}},
+		{second, []int64{2001, 2002}},
+	}
+	for _, c := range cases {
+		var got []int64
+		for _, e := range c.client.Events {
+			got = append(got, *e.Timestamp)
+		}
+		if !reflect.DeepEqual(c.expected, got) {
+			t.Errorf("unexpected timestamps: got=%v want=%v", got, c.expected)
+		}
+	}
+}
+
+// memTokenStore is a TokenStore that keeps the token in memory
+type memTokenStore struct {
+	token string
+	saved []string
+}
+
+func (m *memTokenStore) Load() (string, error) {
+	return m.token, nil
+}
+
+func (m *memTokenStore) Save(token string) error {
+	m.token = token
+	m.saved = append(m.saved, token)
+	return nil
+}
+
+func TestWriterTokenStore(t *testing.T) {
+	cases := []struct {
+		name     string
+		stored   string
+		expected []*string
+	}{
+		{"valid token", "0", []*string{aws.String("0"), aws.String("1")}},
+		{"stale token", "stale", []*string{aws.String("stale"), aws.String("0"), aws.String("1")}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+
+			logsClient := newLogsCLientTest()
+			logsClient.PutHook = func(ctx context.Context) error {
+				logsClient.Lock()
+				defer logsClient.Unlock()
+				if token := logsClient.Tokens[len(logsClient.Tokens)-1]; token != nil && *token == "stale" {
+					return &cloudwatchlogs.InvalidSequenceTokenException{
+						ExpectedSequenceToken: aws.String(strconv.Itoa(len(logsClient.Batches))),
+					}
+				}
+				return nil
+			}
+
+			store := &memTokenStore{token: c.stored}
+			w := New("group", "stream", logsClient,
+				WithFlushInterval(time.Hour),
+				WithRequestRate(0),
+				WithSequenceTokens(true),
+				WithTokenStore(store),
+			)
+
+			for _, line := range []string{"one", "two"} {
+				w.appendEvent(line)
+				if err := w.Flush(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(c.expected, logsClient.Tokens) {
+				t.Errorf("unexpected sequence tokens: got=%v want=%v", aws.StringValueSlice(logsClient.Tokens), aws.StringValueSlice(c.expected))
+			}
+			if expected := []string{"1", "2"}; !reflect.DeepEqual(expected, store.saved) {
+				t.Errorf("unexpected saved tokens: got=%v want=%v", store.saved, expected)
+			}
+		})
+	}
+}
+
+func TestWriterInitialSequenceToken(t *testing.T) {
+	cases := []struct {
+		name     string
+		initial  string
+		stored   string
+		expected []*string
+	}{
+		{"valid token", "0", "", []*string{aws.String("0"), aws.String("1")}},
+		{"stale token", "stale", "", []*string{aws.String("stale"), aws.String("0"), aws.String("1")}},
+		{"stored token", "0", "stale", []*string{aws.String("0"), aws.String("1")}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+
+			logsClient := newLogsCLientTest()
+			logsClient.PutHook = func(ctx context.Context) error {
+				logsClient.Lock()
+				defer logsClient.Unlock()
+				if token := logsClient.Tokens[len(logsClient.Tokens)-1]; token != nil && *token == "stale" {
+					return &cloudwatchlogs.InvalidSequenceTokenException{
+						ExpectedSequenceToken: aws.String(strconv.Itoa(len(logsClient.Batches))),
+					}
+				}
+				return nil
+			}
+
+			w := New("group", "stream", logsClient,
+				WithFlushInterval(time.Hour),
+				WithRequestRate(0),
+				WithSequenceTokens(true),
+				WithTokenStore(&memTokenStore{token: c.stored}),
+				WithInitialSequenceToken(c.initial),
+			)
+
+			for _, line := range []string{"one", "two"} {
+				w.appendEvent(line)
+				if err := w.Flush(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(c.expected, logsClient.Tokens) {
+				t.Errorf("unexpected sequence tokens: got=%v want=%v", aws.StringValueSlice(logsClient.Tokens), aws.StringValueSlice(c.expected))
+			}
+		})
+	}
+}
+
+func TestWriterNilSequenceToken(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient, WithRequestRate(0), WithSequenceTokens(true))
+
+	w.appendEvent("one")
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the last known token is kept if a response doesn't include one
+	logsClient.Lock()
+	logsClient.NilToken = true
+	logsClient.Unlock()
+	for _, line := range []string{"two", "three"} {
+		w.appendEvent(line)
+		if err := w.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*string{nil, aws.String("1"), aws.String("1")}
+	if !reflect.DeepEqual(expected, logsClient.Tokens) {
+		t.Errorf("unexpected sequence tokens: got=%v want=%v", aws.StringValueSlice(logsClient.Tokens), aws.StringValueSlice(expected))
+	}
+	if got := len(logsClient.Events); got != 3 {
+		t.Errorf("unexpected number of events: got=%d want=3", got)
+	}
+}
+
+func TestWriterConcurrentCreate(t *testing.T) {
+	cases := []struct {
+		name    string
+		noGroup bool
+		aborts  int
+	}{
+		{"stream", false, 1},
+		{"group", true, 1},
+		{"group and stream", true, 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now = mockNow()
+
+			logsClient := newLogsCLientTest()
+			logsClient.NoGroup = c.noGroup
+			logsClient.NoStream = true
+			logsClient.AbortCreates = c.aborts
+
+			w := New("group", "stream", logsClient, WithRetentionDays(7))
+			if _, err := w.Write([]byte("event\n")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(logsClient.Events) != 1 {
+				t.Errorf("unexpected number of events: got=%d want=1", len(logsClient.Events))
+			}
+
+			// a group created by someone else is left alone
+			if len(logsClient.Retention) != 0 {
+				t.Errorf("retention policy was applied to a group created elsewhere")
+			}
+		})
+	}
+}
+
+type recordingLogger struct {
+	sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func TestWriterLogger(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	logsClient.NoGroup = true
+	logsClient.NoStream = true
+
+	logger := &recordingLogger{}
+	w := New("group", "stream", logsClient, WithLogger(logger))
+	if _, err := w.Write([]byte("event\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"flushing 1 events (31 bytes) to group/stream",
+		"PutLogEvents attempt 1 failed: ResourceNotFoundException",
+		"creating log stream group/stream",
+		"creating log group group",
+	}
+	logged := strings.Join(logger.messages, "\n")
+	for _, e := range expected {
+		if !strings.Contains(logged, e) {
+			t.Errorf("expected log message %q, got:\n%s", e, logged)
+		}
+	}
+}
+
+func TestWriterCloseTokenNeverAccepted(t *testing.T) {
+	now = mockNow()
+
+	// every token is rejected, each time with a new expected token
+	logsClient := newLogsCLientTest()
+	logsClient.PutHook = func(ctx context.Context) error {
+		logsClient.Lock()
+		defer logsClient.Unlock()
+		return &cloudwatchlogs.InvalidSequenceTokenException{
+			ExpectedSequenceToken: aws.String(strconv.Itoa(len(logsClient.Tokens))),
+		}
+	}
+
+	w := New("group", "stream", logsClient, WithMaxBatchEvents(1), WithErrorCooldown(time.Nanosecond), WithRequestRate(0), WithSequenceTokens(true))
+	if _, err := w.Write([]byte("a\nb\nc\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- w.Close() }()
+
+	select {
+	case err := <-done:
+		if err != errNoProgress {
+			t.Errorf("unexpected error: got=%v want=%v", err, errNoProgress)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return")
+	}
+
+	// each event is attempted at most once per immediate retry
+	if calls := len(logsClient.Tokens); calls > 3*(maxImmediateRetries+1) {
+		t.Errorf("too many PutLogEvents calls: %d", calls)
+	}
+}
+
+func TestWriterCreateStreamOnce(t *testing.T) {
+	now = mockNow()
+
+	// the new stream takes a few attempts to appear
+	logsClient := newLogsCLientTest()
+	logsClient.NoStream = true
+	logsClient.PutHook = cwlogtest.FailCalls(4, awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "stream does not exist", nil))
+
+	w := New("group", "stream", logsClient, WithFlushInterval(time.Hour), WithRequestRate(0))
+	w.backoff.sleep = func(context.Context, time.Duration) error { return nil }
+
+	for i := 0; i < 5; i++ {
+		w.appendEvent("event")
+		if err := w.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := len(logsClient.CreatedStreams); n != 1 {
+		t.Errorf("unexpected number of CreateLogStream calls: got=%d want=1", n)
+	}
+
+	// once the stream has been written to, a missing stream was deleted and
+	// is created again
+	logsClient.Lock()
+	logsClient.NoStream = true
+	logsClient.Unlock()
+
+	w.appendEvent("event")
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := len(logsClient.CreatedStreams); n != 2 {
+		t.Errorf("deleted stream was not recreated: got=%d CreateLogStream calls", n)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(logsClient.Events) != 6 {
+		t.Errorf("unexpected number of events: %d", len(logsClient.Events))
+	}
+}
+
+func TestWriterNoCreate(t *testing.T) {
+	now = mockNow()
+
+	logsClient := newLogsCLientTest()
+	logsClient.NoStream = true
+
+	w := New("group", "stream", logsClient, WithAutoCreate(false))
+	if _, err := w.Write([]byte("event\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := w.Close()
+	if err == nil || !strings.Contains(err.Error(), "creating them is disabled") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if aerr, ok := errors.Unwrap(err).(awserr.Error); !ok || aerr.Code() != cloudwatchlogs.ErrCodeResourceNotFoundException {
+		t.Errorf("the CloudWatch Logs error was not wrapped: %v", err)
+	}
+
+	if len(logsClient.CreatedGroups) != 0 || len(logsClient.CreatedStreams) != 0 {
+		t.Errorf("unexpected create calls: groups=%d streams=%d", len(logsClient.CreatedGroups), len(logsClient.CreatedStreams))
+	}
+	if calls := len(logsClient.Tokens); calls != 1 {
+		t.Errorf("a missing stream should not be retried: %d calls", calls)
+	}
+}
+
+func TestWriterMinBatch(t *testing.T) {
+	cases := []struct {
+		name   string
+		opts   []Option
+		events []string
+		held   bool
+	}{
+		{"below events", []Option{WithMinBatchEvents(3)}, []string{"one", "two"}, true},
+		{"events reached", []Option{WithMinBatchEvents(3)}, []string{"one", "two", "three"}, false},
+		{"below bytes", []Option{WithMinBatchBytes(100)}, []string{"one"}, true},
+		{"bytes reached", []Option{WithMinBatchBytes(100)}, []string{strings.Repeat("x", 100-eventSize)}, false},
+		{"either reached", []Option{WithMinBatchEvents(3), WithMinBatchBytes(100)}, []string{strings.Repeat("x", 100)}, false},
+		{"empty", []Option{WithMinBatchEvents(3)}, nil, false},
+		{"no minimum", nil, []string{"one"}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			opts := append([]Option{WithFlushInterval(time.Hour), WithClock(func() int64 { return 0 })}, c.opts...)
+			w := New("group", "stream", newLogsCLientTest(), opts...)
+			defer w.Close()
+
+			for _, e := range c.events {
+				w.appendEvent(e)
+			}
+			if held := w.holdBatch(); held != c.held {
+				t.Errorf("unexpected result: got=%v want=%v", held, c.held)
+			}
+		})
+	}
+}
+
+func TestWriterMinBatchMaxDelay(t *testing.T) {
+	var clock int64
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient,
+		WithFlushInterval(10*time.Millisecond),
+		WithMinBatchEvents(10),
+		WithMaxFlushDelay(time.Minute),
+		WithClock(func() int64 { return atomic.LoadInt64(&clock) }),
+	)
+	defer w.Close()
+
+	if _, err := w.Write([]byte("one\ntwo\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// several flush intervals pass without the batch being sent
+	time.Sleep(100 * time.Millisecond)
+	if calls := logsClient.Calls(); calls != 0 {
+		t.Fatalf("small batch was flushed before the max delay: %d calls", calls)
+	}
+
+	atomic.StoreInt64(&clock, 59999)
+	time.Sleep(50 * time.Millisecond)
+	if calls := logsClient.Calls(); calls != 0 {
+		t.Fatalf("small batch was flushed before the max delay: %d calls", calls)
+	}
+
+	atomic.StoreInt64(&clock, 60000)
+	deadline := time.Now().Add(5 * time.Second)
+	for logsClient.Calls() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("small batch was not flushed after the max delay")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if got := logsClient.Messages(); !reflect.DeepEqual(got, []string{"one", "two"}) {
+		t.Errorf("unexpected messages: %v", got)
+	}
+}
+
+func TestWriterOnFlush(t *testing.T) {
+	now = mockNow()
+
+	type confirmed struct {
+		events, bytes int
+		token         string
+	}
+	var (
+		mu    sync.Mutex
+		calls []confirmed
+	)
+
+	logsClient := newLogsCLientTest()
+	w := New("group", "stream", logsClient,
+		WithFlushInterval(time.Hour),
+		WithMaxBatchEvents(2),
+		WithOnFlush(func(events, bytes int, token string) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, confirmed{events, bytes, token})
+		}),
+	)
+
+	lines := []string{"one", "two", "three", "four", "five"}
+	if _, err := w.Write([]byte(strings.Join(lines, "\n") + "\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(calls) != len(logsClient.Batches) {
+		t.Fatalf("unexpected number of callbacks: got=%d want=%d", len(calls), len(logsClient.Batches))
+	}
+
+	// running totals only ever grow, and end at everything that was sent
+	var events, bytes, prev int
+	for i, c := range calls {
+		if c.events <= 0 || c.bytes <= 0 {
+			t.Errorf("callback %d: empty batch: %+v", i, c)
+		}
+		if c.events != logsClient.Batches[i] {
+			t.Errorf("callback %d: unexpected events: got=%d want=%d", i, c.events, logsClient.Batches[i])
+		}
+		if c.token != strconv.Itoa(i+1) {
+			t.Errorf("callback %d: unexpected token: %q", i, c.token)
+		}
+
+		events += c.events
+		bytes += c.bytes
+		if events <= prev {
+			t.Errorf("callback %d: total did not increase: %d", i, events)
+		}
+		prev = events
+	}
+
+	var expectedBytes int
+	for _, l := range lines {
+		expectedBytes += len(l) + eventSize
+	}
+	if events != len(lines) || bytes != expectedBytes {
+		t.Errorf("unexpected totals: events=%d bytes=%d want events=%d bytes=%d", events, bytes, len(lines), expectedBytes)
+	}
+}